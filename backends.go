@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// backendPool rotates through the -backends-file addresses, one per request.
+type backendPool struct {
+	addrs []string
+	next  uint64
+}
+
+func (p *backendPool) pick() string {
+	i := atomic.AddUint64(&p.next, 1) - 1
+	return p.addrs[i%uint64(len(p.addrs))]
+}
+
+// loadBackends reads one ip:port address per line from path, skipping blank
+// lines and # comments.
+func loadBackends(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: expected ip:port, got %q", path, i+1, line)
+		}
+		addrs = append(addrs, line)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no backends in %s", path)
+	}
+	return addrs, nil
+}
+
+// backendKey is the context key under which sendRequest records the backend a
+// request must be sent to.
+type backendKey struct{}
+
+// backendTransport sends each request to the backend chosen for it, keeping the
+// URL, and so the Host header and TLS server name, unchanged. Each backend has its
+// own transport, so pooled connections are never reused for another backend; the
+// -connections limit is shared by all of them.
+type backendTransport struct {
+	pool       *backendPool
+	transports map[string]*http.Transport
+}
+
+func newBackendTransport(cfg *Config, pool *backendPool) *backendTransport {
+	t := &backendTransport{pool: pool, transports: make(map[string]*http.Transport)}
+	for _, addr := range pool.addrs {
+		t.transports[addr] = newTransport(cfg, addr)
+	}
+	return t
+}
+
+func (t *backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr, ok := req.Context().Value(backendKey{}).(string)
+	if !ok {
+		// Requests outside the measured run, such as -preflight, just take the next one.
+		addr = t.pool.pick()
+	}
+	return t.transports[addr].RoundTrip(req)
+}
+
+// withBackend picks the next backend for a request, returning the context that
+// carries it and its address.
+func withBackend(ctx context.Context, pool *backendPool) (context.Context, string) {
+	addr := pool.pick()
+	return context.WithValue(ctx, backendKey{}, addr), addr
+}
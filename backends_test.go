@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeBackendsFile writes content to a backends file and returns its path.
+func writeBackendsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "backends.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadBackends(t *testing.T) {
+	path := writeBackendsFile(t, "# fleet\n10.0.0.1:80\n\n  10.0.0.2:8080  \n")
+	addrs, err := loadBackends(path)
+	if err != nil || strings.Join(addrs, ",") != "10.0.0.1:80,10.0.0.2:8080" {
+		t.Errorf("loadBackends = %v, %v; want both addresses", addrs, err)
+	}
+
+	if _, err := loadBackends(writeBackendsFile(t, "10.0.0.1:80\n10.0.0.2\n")); err == nil || !strings.Contains(err.Error(), ":2: expected ip:port") {
+		t.Errorf("missing port: err = %v, want one naming line 2", err)
+	}
+	if _, err := loadBackends(writeBackendsFile(t, "# nothing yet\n")); err == nil {
+		t.Error("an empty backends file was accepted")
+	}
+}
+
+func TestBackendsDistribution(t *testing.T) {
+	var mu sync.Mutex
+	hosts := make(map[string]int)
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hosts[r.Host]++
+			mu.Unlock()
+			w.WriteHeader(status)
+		}
+	}
+	a := httptest.NewServer(handler(http.StatusOK))
+	defer a.Close()
+	b := httptest.NewServer(handler(http.StatusServiceUnavailable))
+	defer b.Close()
+	addrA, addrB := a.Listener.Addr().String(), b.Listener.Addr().String()
+
+	path := writeBackendsFile(t, addrA+"\n"+addrB+"\n")
+
+	// The host does not resolve, so every request has to go to a backend.
+	summary, out := runSummary(t, "-url", "http://api.example.invalid/items", "-backends-file", path, "-requests", "6", "-concurrency", "2")
+	statsA, statsB := summary.Backends[addrA], summary.Backends[addrB]
+	if statsA == nil || statsB == nil {
+		t.Fatalf("backends %v, want %s and %s:\n%s", summary.Backends, addrA, addrB, out)
+	}
+	if statsA.Requests != 3 || statsA.Failures != 0 || statsB.Requests != 3 || statsB.Failures != 3 {
+		t.Errorf("backend A %d requests, %d failures; B %d, %d; want 3/0 and 3/3", statsA.Requests, statsA.Failures, statsB.Requests, statsB.Failures)
+	}
+	if len(hosts) != 1 || hosts["api.example.invalid"] != 6 {
+		t.Errorf("Host headers received = %v, want api.example.invalid on all 6", hosts)
+	}
+	if !strings.Contains(out, addrB) {
+		t.Errorf("per-backend stats not printed:\n%s", out)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// chainHeader is a -chain-header: a request header whose value is a template
+// rendered against the previous response of the same worker, as in
+// 'Authorization=Bearer {{.PrevBody.token}}'.
+type chainHeader struct {
+	Name string
+	tmpl *template.Template
+	raw  string
+}
+
+// chainHeaders is a custom flag type for handling multiple -chain-header flags.
+type chainHeaders []chainHeader
+
+func (c *chainHeaders) String() string {
+	parts := make([]string, len(*c))
+	for i, header := range *c {
+		parts[i] = header.raw
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Set parses a header of the form 'Name=template'.
+func (c *chainHeaders) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("expected format 'Name=template', got %q", value)
+	}
+	tmpl, err := template.New(parts[0]).Option("missingkey=error").Parse(parts[1])
+	if err != nil {
+		return err
+	}
+	*c = append(*c, chainHeader{Name: strings.TrimSpace(parts[0]), tmpl: tmpl, raw: value})
+	return nil
+}
+
+// chainValues renders each header against a response body, returning the values
+// that rendered. Headers whose fields the body lacks, or every header if it is not
+// JSON, are left out.
+func (c chainHeaders) chainValues(body []byte) map[string]string {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+	data := struct{ PrevBody interface{} }{doc}
+	values := make(map[string]string)
+	for _, header := range c {
+		var b strings.Builder
+		if err := header.tmpl.Execute(&b, data); err != nil {
+			continue
+		}
+		values[header.Name] = b.String()
+	}
+	return values
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestChainHeadersParse(t *testing.T) {
+	var headers chainHeaders
+	if err := headers.Set("Authorization=Bearer {{.PrevBody.token}}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := headers.Set("X-Next={{.PrevBody.page.next}}"); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"Authorization", "=x", "X-Bad={{.PrevBody"} {
+		if err := headers.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", bad)
+		}
+	}
+
+	values := headers.chainValues([]byte(`{"token":"abc","page":{"next":2}}`))
+	if values["Authorization"] != "Bearer abc" || values["X-Next"] != "2" {
+		t.Errorf("chainValues = %v, want both headers rendered", values)
+	}
+	values = headers.chainValues([]byte(`{"token":"abc"}`))
+	if _, ok := values["X-Next"]; ok || values["Authorization"] != "Bearer abc" {
+		t.Errorf("chainValues = %v, want only the header whose field is present", values)
+	}
+	if values := headers.chainValues([]byte("<html>")); len(values) != 0 {
+		t.Errorf("chainValues of a non-JSON body = %v, want none", values)
+	}
+}
+
+func TestChainHeaderCarriesToken(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Header.Get("Authorization"))
+		n := len(received)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token":"t%d"}`, n)
+	}))
+	defer srv.Close()
+
+	out, code := runTool(t, "-url", srv.URL, "-requests", "4", "-concurrency", "1", "-chain-header", "Authorization=Bearer {{.PrevBody.token}}")
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, out)
+	}
+	want := []string{"", "Bearer t1", "Bearer t2", "Bearer t3"}
+	if strings.Join(received, "|") != strings.Join(want, "|") {
+		t.Errorf("Authorization headers received = %q, want %q", received, want)
+	}
+}
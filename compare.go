@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// exitCodeRegression is the exit status used when a run regresses against its baseline.
+const exitCodeRegression = 2
+
+// Comparison holds one metric from a baseline run and the current run.
+type Comparison struct {
+	Metric    string
+	Key       string // the metric's name in a -regression-policy file
+	Baseline  float64
+	Current   float64
+	Change    float64 // relative change in percent, or percentage points for rates
+	Absolute  bool    // Change is in percentage points
+	Tolerance float64 // how far the metric may worsen, in the same unit as Change
+	Regressed bool
+}
+
+// summaryMetric describes how a metric is compared between two summaries.
+type summaryMetric struct {
+	name          string
+	key           string
+	value         func(*Summary) float64
+	higherIsWorse bool
+	// absolute compares the difference in percentage points rather than the relative
+	// change, for metrics that are already percentages and are often zero.
+	absolute bool
+}
+
+var comparedMetrics = []summaryMetric{
+	{"Average Response Time", "avg", func(s *Summary) float64 { return s.AvgResponseTime }, true, false},
+	{"90th Percentile", "p90", func(s *Summary) float64 { return s.Percentile90 }, true, false},
+	{"99th Percentile", "p99", func(s *Summary) float64 { return s.Percentile99 }, true, false},
+	{"Requests per Second", "rps", func(s *Summary) float64 { return s.RequestsPerSecond }, false, false},
+	{"Failure Rate", "failureRate", func(s *Summary) float64 { return s.FailureRate }, true, true},
+}
+
+// regressionPolicy maps metric keys to how far each may worsen before it counts
+// as a regression. Metrics it leaves out use -regression-threshold.
+type regressionPolicy map[string]float64
+
+// loadPolicy reads a -regression-policy file: a JSON object of metric keys to
+// tolerances, such as {"p99": 5, "failureRate": 0, "rps": 10}.
+func loadPolicy(path string) (regressionPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy regressionPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	keys := make([]string, len(comparedMetrics))
+	for i, metric := range comparedMetrics {
+		keys[i] = metric.key
+	}
+	for key, tolerance := range policy {
+		if !contains(keys, key) {
+			return nil, fmt.Errorf("%s: unknown metric %q (want one of %s)", path, key, strings.Join(keys, ", "))
+		}
+		if tolerance < 0 {
+			return nil, fmt.Errorf("%s: tolerance for %q must not be negative", path, key)
+		}
+	}
+	return policy, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSummary reads a summary previously written with -output.
+func loadSummary(path string) (*Summary, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var summary Summary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &summary, nil
+}
+
+// compareSummaries compares each tracked metric of current against baseline. A
+// metric regresses when it worsens by more than its tolerance in policy, or by
+// more than threshold if the policy doesn't name it, in percent (or percentage
+// points, for rates).
+func compareSummaries(baseline, current *Summary, threshold float64, policy regressionPolicy) []Comparison {
+	comparisons := make([]Comparison, 0, len(comparedMetrics))
+	for _, metric := range comparedMetrics {
+		c := Comparison{
+			Metric:    metric.name,
+			Key:       metric.key,
+			Baseline:  metric.value(baseline),
+			Current:   metric.value(current),
+			Absolute:  metric.absolute,
+			Tolerance: threshold,
+		}
+		if tolerance, ok := policy[metric.key]; ok {
+			c.Tolerance = tolerance
+		}
+		if metric.absolute {
+			c.Change = c.Current - c.Baseline
+		} else if c.Baseline != 0 {
+			c.Change = (c.Current - c.Baseline) / c.Baseline * 100
+		}
+		worsening := c.Change
+		if !metric.higherIsWorse {
+			worsening = -worsening
+		}
+		c.Regressed = worsening > c.Tolerance
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+// regressions returns the comparisons that regressed.
+func regressions(comparisons []Comparison) []Comparison {
+	var regressed []Comparison
+	for _, c := range comparisons {
+		if c.Regressed {
+			regressed = append(regressed, c)
+		}
+	}
+	return regressed
+}
+
+func printComparisons(baselinePath string, comparisons []Comparison) {
+	fmt.Printf("\n%sBaseline Comparison (%s)%s\n%s-------------------%s\n", ColorYellow, baselinePath, ColorReset, ColorYellow, ColorReset)
+	for _, c := range comparisons {
+		status, color := "ok", ColorGreen
+		if c.Regressed {
+			status, color = "REGRESSED", ColorRed
+		}
+		fmt.Printf("%-25s: %.4f -> %.4f (%+.2f%s, limit %.2f%s) %s%s%s\n", c.Metric, c.Baseline, c.Current, c.Change, c.unit(), c.Tolerance, c.unit(), color, status, ColorReset)
+	}
+
+	if regressed := regressions(comparisons); len(regressed) > 0 {
+		fmt.Printf("%s%d metric(s) regressed against the baseline:%s\n", ColorRed, len(regressed), ColorReset)
+		for _, c := range regressed {
+			fmt.Printf("%s  - %s (%s) changed by %+.2f%s, beyond the allowed %.2f%s%s\n", ColorRed, c.Metric, c.Key, c.Change, c.unit(), c.Tolerance, c.unit(), ColorReset)
+		}
+	} else {
+		fmt.Printf("%sNo regressions against the baseline.%s\n", ColorGreen, ColorReset)
+	}
+}
+
+// unit is the unit of the comparison's Change and Tolerance.
+func (c Comparison) unit() string {
+	if c.Absolute {
+		return "pp"
+	}
+	return "%"
+}
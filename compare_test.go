@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareSummaries(t *testing.T) {
+	baseline := &Summary{AvgResponseTime: 0.1, Percentile90: 0.2, Percentile99: 0.4, RequestsPerSecond: 100, FailureRate: 1}
+	current := &Summary{AvgResponseTime: 0.105, Percentile90: 0.3, Percentile99: 0.3, RequestsPerSecond: 80, FailureRate: 12}
+	comparisons := compareSummaries(baseline, current, 10, nil)
+
+	want := map[string]struct {
+		change    float64
+		regressed bool
+	}{
+		"avg":         {5, false},   // 5% slower, within 10%
+		"p90":         {50, true},   // 50% slower
+		"p99":         {-25, false}, // faster
+		"rps":         {-20, true},  // throughput fell by 20%
+		"failureRate": {11, true},   // 11 percentage points
+	}
+	if len(comparisons) != len(want) {
+		t.Fatalf("got %d comparisons, want %d", len(comparisons), len(want))
+	}
+	for _, c := range comparisons {
+		w := want[c.Key]
+		if !approxEqual(c.Change, w.change) || c.Regressed != w.regressed {
+			t.Errorf("%s: change %.2f regressed %v, want %.2f and %v", c.Key, c.Change, c.Regressed, w.change, w.regressed)
+		}
+	}
+	if got := len(regressions(comparisons)); got != 3 {
+		t.Errorf("%d regressions, want 3", got)
+	}
+}
+
+// writeBaseline saves summary as a -baseline file and returns its path.
+func writeBaseline(t *testing.T, summary Summary) string {
+	t.Helper()
+	data, err := json.Marshal(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBaselineRegression(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	fast := writeBaseline(t, Summary{AvgResponseTime: 0.001, Percentile90: 0.001, Percentile99: 0.001, RequestsPerSecond: 10, FailureRate: 0})
+	out, code := runTool(t, "-url", srv.URL, "-requests", "5", "-concurrency", "1", "-baseline", fast)
+	if code != exitCodeRegression {
+		t.Errorf("exit code %d against a faster baseline, want %d", code, exitCodeRegression)
+	}
+	if !strings.Contains(out, "REGRESSED") || !strings.Contains(out, "regressed against the baseline") {
+		t.Errorf("output does not report the regression:\n%s", out)
+	}
+
+	slow := writeBaseline(t, Summary{AvgResponseTime: 1, Percentile90: 1, Percentile99: 1, RequestsPerSecond: 1, FailureRate: 0})
+	out, code = runTool(t, "-url", srv.URL, "-requests", "5", "-concurrency", "1", "-baseline", slow)
+	if code != 0 {
+		t.Errorf("exit code %d against a slower baseline, want 0:\n%s", code, out)
+	}
+	if !strings.Contains(out, "No regressions against the baseline.") {
+		t.Errorf("output does not report a clean comparison:\n%s", out)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	policy, err := loadPolicy(write("ok.json", `{"p99": 5, "failureRate": 0, "rps": 10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy) != 3 || policy["p99"] != 5 || policy["failureRate"] != 0 || policy["rps"] != 10 {
+		t.Errorf("policy = %v", policy)
+	}
+
+	for name, content := range map[string]string{
+		"unknown.json":  `{"p95": 5}`,
+		"negative.json": `{"p99": -1}`,
+		"syntax.json":   `{"p99": }`,
+	} {
+		if _, err := loadPolicy(write(name, content)); err == nil {
+			t.Errorf("%s loaded without error", name)
+		}
+	}
+}
+
+func TestCompareSummariesWithPolicy(t *testing.T) {
+	policy := regressionPolicy{"p99": 5, "failureRate": 0, "rps": 10}
+	baseline := &Summary{AvgResponseTime: 0.1, Percentile90: 0.2, Percentile99: 0.4, RequestsPerSecond: 100, FailureRate: 0}
+	current := &Summary{AvgResponseTime: 0.118, Percentile90: 0.21, Percentile99: 0.43, RequestsPerSecond: 91, FailureRate: 0.5}
+
+	want := map[string]struct {
+		tolerance float64
+		regressed bool
+	}{
+		"avg":         {10, true},  // 18% slower, over the default threshold
+		"p90":         {10, false}, // 5% slower, under the default threshold
+		"p99":         {5, true},   // 7.5% slower, over its 5% rule
+		"rps":         {10, false}, // 9% lower, within its 10% rule
+		"failureRate": {0, true},   // any increase breaks the zero rule
+	}
+	for _, c := range compareSummaries(baseline, current, 10, policy) {
+		w := want[c.Key]
+		if c.Tolerance != w.tolerance || c.Regressed != w.regressed {
+			t.Errorf("%s: tolerance %v regressed %v, want %v and %v", c.Key, c.Tolerance, c.Regressed, w.tolerance, w.regressed)
+		}
+	}
+}
+
+func TestRegressionPolicyReport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.json")
+	// Latency may get a hundredfold worse, but failures may not increase at all.
+	os.WriteFile(policy, []byte(`{"avg": 10000, "p90": 10000, "p99": 10000, "rps": 100, "failureRate": 0}`), 0644)
+	baseline := writeBaseline(t, Summary{AvgResponseTime: 0.001, Percentile90: 0.001, Percentile99: 0.001, RequestsPerSecond: 1000, FailureRate: 0})
+
+	out, code := runTool(t, "-url", srv.URL, "-requests", "5", "-baseline", baseline, "-regression-policy", policy)
+	if code != 0 || strings.Contains(out, "REGRESSED") {
+		t.Errorf("exit code %d within the policy, want 0:\n%s", code, out)
+	}
+
+	// Every request now fails, which breaks the failure rate rule only.
+	out, code = runTool(t, "-url", srv.URL, "-requests", "5", "-baseline", baseline, "-regression-policy", policy, "-success-expr", "status == 500")
+	if code != exitCodeRegression {
+		t.Errorf("exit code %d, want %d", code, exitCodeRegression)
+	}
+	if !strings.Contains(out, "1 metric(s) regressed against the baseline:") || !strings.Contains(out, "Failure Rate (failureRate) changed by +100.00pp, beyond the allowed 0.00pp") {
+		t.Errorf("report does not name the broken rule:\n%s", out)
+	}
+}
+
+func TestSummaryOnlyOnChange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	slow := writeBaseline(t, Summary{AvgResponseTime: 1, Percentile90: 1, Percentile99: 1, RequestsPerSecond: 1, FailureRate: 0})
+	out, code := runTool(t, "-url", srv.URL, "-requests", "5", "-concurrency", "1", "-baseline", slow, "-summary-only-on-change")
+	if code != 0 || out != "" {
+		t.Errorf("within tolerance: exit code %d, output %q; want 0 and silence", code, out)
+	}
+
+	fast := writeBaseline(t, Summary{AvgResponseTime: 0.001, Percentile90: 0.001, Percentile99: 0.001, RequestsPerSecond: 10, FailureRate: 0})
+	out, code = runTool(t, "-url", srv.URL, "-requests", "5", "-concurrency", "1", "-baseline", fast, "-summary-only-on-change")
+	if code != exitCodeRegression {
+		t.Errorf("on regression: exit code %d, want %d", code, exitCodeRegression)
+	}
+	for _, want := range []string{"Load Test Summary", "Total Requests Sent      : 5", "REGRESSED"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("on regression: output is missing %q:\n%s", want, out)
+		}
+	}
+
+	out, code = runTool(t, "-url", srv.URL, "-requests", "1", "-summary-only-on-change")
+	if code != 1 || !strings.Contains(out, "Error: -summary-only-on-change requires -baseline.") {
+		t.Errorf("without -baseline: exit code %d, want 1:\n%s", code, out)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestTransport answers HTTP Digest authentication challenges (RFC 7616) for
+// -digest-auth. The first 401 challenge is answered by resending the request with
+// credentials; the challenge is then reused so later requests authenticate up
+// front and only pay for the extra round trip when the server issues a new nonce.
+type digestTransport struct {
+	base     http.RoundTripper
+	username string
+	password string
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint32 // requests sent with the current nonce
+}
+
+// digestChallenge holds the parameters of a WWW-Authenticate: Digest header.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string // "auth" if offered, else empty for the RFC 2069 form
+}
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	challenge := t.challenge
+	t.mu.Unlock()
+
+	if challenge != nil {
+		authed := req.Clone(req.Context())
+		authed.Header.Set("Authorization", t.authorization(challenge, req))
+		resp, err := t.base.RoundTrip(authed)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+		return t.retry(req, resp)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	return t.retry(req, resp)
+}
+
+// retry answers the challenge in a 401 response by sending req again with
+// credentials. Without a Digest challenge, or a body that can be replayed, the
+// 401 is returned as it is.
+func (t *digestTransport) retry(req *http.Request, resp *http.Response) (*http.Response, error) {
+	challenge := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if challenge == nil || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return resp, nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	t.mu.Lock()
+	t.challenge = challenge
+	t.nc = 0
+	t.mu.Unlock()
+
+	authed := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		authed.Body = body
+	}
+	authed.Header.Set("Authorization", t.authorization(challenge, req))
+	return t.base.RoundTrip(authed)
+}
+
+// authorization builds the Authorization header answering challenge for req.
+func (t *digestTransport) authorization(c *digestChallenge, req *http.Request) string {
+	t.mu.Lock()
+	t.nc++
+	nc := fmt.Sprintf("%08x", t.nc)
+	t.mu.Unlock()
+
+	newHash := md5.New
+	algorithm := strings.ToUpper(c.algorithm)
+	if strings.HasPrefix(algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(parts ...string) string {
+		return digestHash(newHash, strings.Join(parts, ":"))
+	}
+
+	cnonce := newCnonce()
+	uri := req.URL.RequestURI()
+	ha1 := h(t.username, c.realm, t.password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = h(ha1, c.nonce, cnonce)
+	}
+	ha2 := h(req.Method, uri)
+
+	fields := []string{
+		fmt.Sprintf("username=%q", t.username),
+		fmt.Sprintf("realm=%q", c.realm),
+		fmt.Sprintf("nonce=%q", c.nonce),
+		fmt.Sprintf("uri=%q", uri),
+	}
+	if c.qop != "" {
+		fields = append(fields,
+			"qop="+c.qop,
+			"nc="+nc,
+			fmt.Sprintf("cnonce=%q", cnonce),
+			fmt.Sprintf("response=%q", h(ha1, c.nonce, nc, cnonce, c.qop, ha2)))
+	} else {
+		fields = append(fields, fmt.Sprintf("response=%q", h(ha1, c.nonce, ha2)))
+	}
+	if c.algorithm != "" {
+		fields = append(fields, "algorithm="+c.algorithm)
+	}
+	if c.opaque != "" {
+		fields = append(fields, fmt.Sprintf("opaque=%q", c.opaque))
+	}
+	return "Digest " + strings.Join(fields, ", ")
+}
+
+// parseDigestChallenge returns the first Digest challenge among WWW-Authenticate
+// values, or nil if there is none or it uses an unsupported algorithm.
+func parseDigestChallenge(values []string) *digestChallenge {
+	for _, value := range values {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(value), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		params := parseAuthParams(rest)
+		c := &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: params["algorithm"],
+		}
+		switch strings.ToUpper(c.algorithm) {
+		case "", "MD5", "MD5-SESS", "SHA-256", "SHA-256-SESS":
+		default:
+			return nil
+		}
+		for _, qop := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		if c.nonce == "" {
+			return nil
+		}
+		return c
+	}
+	return nil
+}
+
+// parseAuthParams splits comma-separated key=value pairs, where values may be
+// quoted strings containing commas.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			if i < len(rest) {
+				i++ // closing quote
+			}
+			value, s = b.String(), rest[i:]
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func digestHash(newHash func() hash.Hash, s string) string {
+	h := newHash()
+	io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newCnonce returns a random client nonce.
+func newCnonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
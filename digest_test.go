@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// digestServer is a test server behind HTTP Digest authentication with qop=auth.
+type digestServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	challenges int // 401 responses sent
+	accepted   int
+}
+
+func newDigestServer(t *testing.T, algorithm, username, password string) *digestServer {
+	newHash := md5.New
+	if strings.HasPrefix(algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(parts ...string) string {
+		sum := newHash()
+		sum.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+	const realm, nonce = "tests@example.com", "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+
+	s := &digestServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := map[string]string{}
+		if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Digest "); ok {
+			for _, field := range strings.Split(auth, ", ") {
+				key, value, _ := strings.Cut(field, "=")
+				params[key] = strings.Trim(value, `"`)
+			}
+		}
+		ha1 := h(username, realm, password)
+		want := h(ha1, nonce, params["nc"], params["cnonce"], "auth", h(r.Method, r.URL.RequestURI()))
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if params["username"] != username || params["uri"] != r.URL.RequestURI() || params["response"] != want {
+			s.challenges++
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth,auth-int", algorithm=%s, nonce="%s", opaque="5ccc069c403ebaf9f0171e9517f40e41"`, realm, algorithm, nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.accepted++
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestDigestAuth(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "SHA-256"} {
+		srv := newDigestServer(t, algorithm, "Mufasa", "Circle of Life")
+		summary, out := runSummary(t, "-url", srv.URL+"/dir/index.html?x=1", "-requests", "5", "-concurrency", "1", "-digest-auth", "Mufasa:Circle of Life")
+		if summary.SuccessfulRequests != 5 {
+			t.Errorf("%s: %d successes, want 5:\n%s", algorithm, summary.SuccessfulRequests, out)
+		}
+		srv.mu.Lock()
+		// Only the first request is challenged; the rest reuse the nonce.
+		if srv.challenges != 1 || srv.accepted != 5 {
+			t.Errorf("%s: %d challenges and %d accepted requests, want 1 and 5", algorithm, srv.challenges, srv.accepted)
+		}
+		srv.mu.Unlock()
+	}
+}
+
+func TestDigestAuthWrongPassword(t *testing.T) {
+	srv := newDigestServer(t, "MD5", "Mufasa", "Circle of Life")
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "3", "-concurrency", "1", "-digest-auth", "Mufasa:wrong")
+	if summary.FailedRequests != 3 || summary.StatusCodeDist[http.StatusUnauthorized] != 3 {
+		t.Errorf("%d failures with status codes %v, want 3 401s", summary.FailedRequests, summary.StatusCodeDist)
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	got := parseAuthParams(`realm="a, \"quoted\" realm", qop="auth,auth-int",algorithm=MD5, nonce="n1"`)
+	want := map[string]string{"realm": `a, "quoted" realm`, "qop": "auth,auth-int", "algorithm": "MD5", "nonce": "n1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("parseAuthParams = %v, want %v", got, want)
+	}
+	if c := parseDigestChallenge([]string{`Basic realm="x"`, `Digest realm="r", nonce="n", algorithm=SHA-512`}); c != nil {
+		t.Errorf("unsupported algorithm accepted: %+v", c)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// maxErrorDumps caps how many failed exchanges -dump-on-error writes, so a run
+// where everything fails does not fill the disk.
+const maxErrorDumps = 100
+
+// errorDumps counts the dump files written so far.
+var errorDumps uint64
+
+// dumpExchange writes a failed request and, if one arrived, its response to a new
+// file in dir. reqErr is the transport error, if any; classifyErr is why an
+// otherwise received response was treated as a failure.
+func dumpExchange(dir string, req *http.Request, resp *http.Response, respBody []byte, reqErr, classifyErr error) {
+	n := atomic.AddUint64(&errorDumps, 1)
+	if n > maxErrorDumps {
+		return
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
+	fmt.Fprintf(&buf, "Host: %s\n", req.Host)
+	writeHeaders(&buf, req.Header)
+	buf.WriteString("\n")
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			io.Copy(&buf, body)
+			body.Close()
+		}
+	}
+
+	buf.WriteString("\n\n--- Response ---\n")
+	if reqErr != nil {
+		fmt.Fprintf(&buf, "No response: %v\n", reqErr)
+	} else {
+		fmt.Fprintf(&buf, "%s %s\n", resp.Proto, resp.Status)
+		writeHeaders(&buf, resp.Header)
+		buf.WriteString("\n")
+		buf.Write(respBody)
+		if len(resp.Trailer) > 0 {
+			buf.WriteString("\n\n--- Trailers ---\n")
+			writeHeaders(&buf, resp.Trailer)
+		}
+		if classifyErr != nil {
+			fmt.Fprintf(&buf, "\n\n--- Failure ---\n%v\n", classifyErr)
+		}
+	}
+
+	name := fmt.Sprintf("%s-%03d.txt", time.Now().Format("20060102T150405.000"), n)
+	if err := ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+		fmt.Printf("\nError writing dump of a failed request: %v\n", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDumpOnError(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		n++
+		fail := n%3 == 0
+		mu.Unlock()
+		if fail {
+			w.Header().Set("X-Error-Id", "E42")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"overloaded"}`))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	summary, _ := runSummary(t, "-url", srv.URL+"/orders?id=7", "-method", "POST", "-body", `{"item":1}`,
+		"-header", "X-Client: tests", "-requests", "9", "-concurrency", "1", "-dump-on-error", dir)
+	if summary.FailedRequests != 3 {
+		t.Fatalf("%d failures, want 3", summary.FailedRequests)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("%d dump files, want one per failed request: %v", len(files), files)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dump := string(data)
+		for _, want := range []string{
+			"POST /orders?id=7 HTTP/1.1\n",
+			"X-Client: tests\n",
+			"\n{\"item\":1}\n",
+			"--- Response ---\nHTTP/1.1 503 Service Unavailable\n",
+			"X-Error-Id: E42\n",
+			`{"error":"overloaded"}`,
+		} {
+			if !strings.Contains(dump, want) {
+				t.Errorf("%s is missing %q:\n%s", filepath.Base(file), want, dump)
+			}
+		}
+	}
+}
+
+func TestDumpOnErrorWithoutResponse(t *testing.T) {
+	ln := newClosedPortURL(t)
+	dir := t.TempDir()
+	runSummary(t, "-url", ln, "-requests", "2", "-concurrency", "1", "-dump-on-error", dir)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if len(files) != 2 {
+		t.Fatalf("%d dump files, want 2", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "--- Response ---\nNo response: ") {
+		t.Errorf("dump does not say no response arrived:\n%s", data)
+	}
+}
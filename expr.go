@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// successExpr is a small boolean expression deciding whether a response succeeded.
+// It supports the variables status, latency (seconds) and body; number, string and
+// boolean literals; the comparisons == != < <= > >= and contains; and && || ! with
+// parentheses, e.g.:
+//
+//	status == 200 && latency < 0.5
+//	(status < 500 || status == 503) && !(body contains "error")
+type successExpr struct {
+	source   string
+	root     exprNode
+	needBody bool
+}
+
+func (e *successExpr) String() string {
+	return e.source
+}
+
+// Set parses the expression, so syntax errors are reported with the flags.
+func (e *successExpr) Set(value string) error {
+	tokens, err := tokenizeExpr(value)
+	if err != nil {
+		return err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return err
+	}
+	if p.pos < len(p.tokens) {
+		return fmt.Errorf("unexpected %q in expression", p.tokens[p.pos].text)
+	}
+	e.source, e.root = value, root
+	for _, tok := range tokens {
+		if tok.kind == tokIdent && tok.text == "body" {
+			e.needBody = true
+		}
+	}
+	return nil
+}
+
+func (e *successExpr) enabled() bool {
+	return e.root != nil
+}
+
+// usesBody reports whether evaluating the expression requires the response body.
+func (e *successExpr) usesBody() bool {
+	return e.needBody
+}
+
+// match evaluates the expression for a single response.
+func (e *successExpr) match(status int, latency float64, body string) (bool, error) {
+	vars := map[string]interface{}{
+		"status":  float64(status),
+		"latency": latency,
+		"body":    body,
+	}
+	result, err := e.root.eval(vars)
+	if err != nil {
+		return false, fmt.Errorf("success expression: %w", err)
+	}
+	b, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("success expression: result %v is not a boolean", result)
+	}
+	return b, nil
+}
+
+type exprNode interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) { return n.value, nil }
+
+type identNode struct{ name string }
+
+func (n identNode) eval(vars map[string]interface{}) (interface{}, error) {
+	return vars[n.name], nil
+}
+
+type notNode struct{ operand exprNode }
+
+func (n notNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := evalBool(n.operand, vars)
+	return !v, err
+}
+
+type logicalNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n logicalNode) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(n.left, vars)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit like Go does.
+	if (n.op == "&&" && !left) || (n.op == "||" && left) {
+		return left, nil
+	}
+	return evalBool(n.right, vars)
+}
+
+type compareNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n compareNode) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare number with %v", right)
+		}
+		switch n.op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %v", right)
+		}
+		switch n.op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		case "contains":
+			return strings.Contains(l, r), nil
+		}
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare boolean with %v", right)
+		}
+		switch n.op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s is not supported for %v", n.op, left)
+}
+
+func evalBool(n exprNode, vars map[string]interface{}) (bool, error) {
+	v, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v is not a boolean", v)
+	}
+	return b, nil
+}
+
+type exprTokenKind int
+
+const (
+	tokNumber exprTokenKind = iota
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+}
+
+func tokenizeExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string in expression %q", src)
+			}
+			tokens = append(tokens, exprToken{tokString, src[i : end+1]})
+			i = end + 1
+		case c >= '0' && c <= '9' || c == '.':
+			end := i
+			for end < len(src) && (src[end] >= '0' && src[end] <= '9' || src[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, exprToken{tokNumber, src[i:end]})
+			i = end
+		case unicode.IsLetter(rune(c)) || c == '_':
+			end := i
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || src[end] == '_') {
+				end++
+			}
+			word := src[i:end]
+			if word == "contains" {
+				tokens = append(tokens, exprToken{tokOp, word})
+			} else {
+				tokens = append(tokens, exprToken{tokIdent, word})
+			}
+			i = end
+		default:
+			matched := false
+			for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, exprToken{tokOp, op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q in expression %q", c, src)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// exprParser is a recursive-descent parser over the token stream, from lowest
+// precedence (||) to highest (literals and parenthesized expressions).
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peekOp(ops ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekOp("||"); !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{"||", left, right}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekOp("&&"); !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{"&&", left, right}
+	}
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if _, ok := p.peekOp("!"); ok {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.peekOp("==", "!=", "<", "<=", ">", ">=", "contains")
+	if !ok {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op, left, right}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in expression", tok.text)
+		}
+		return literalNode{v}, nil
+	case tokString:
+		v, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s in expression", tok.text)
+		}
+		return literalNode{v}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return literalNode{tok.text == "true"}, nil
+		case "status", "latency", "body":
+			return identNode{tok.text}, nil
+		}
+		return nil, fmt.Errorf("unknown variable %q in expression (use status, latency or body)", tok.text)
+	}
+	if tok.text == "(" {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.peekOp(")"); !ok {
+			return nil, fmt.Errorf("missing ')' in expression")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return nil, fmt.Errorf("unexpected %q in expression", tok.text)
+}
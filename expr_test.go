@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSuccessExprMatch(t *testing.T) {
+	tests := []struct {
+		expr    string
+		status  int
+		latency float64
+		body    string
+		want    bool
+	}{
+		{"status == 200", 200, 0.1, "", true},
+		{"status == 200", 503, 0.1, "", false},
+		{"status == 200 && latency < 0.5", 200, 0.2, "", true},
+		{"status == 200 && latency < 0.5", 200, 0.7, "", false},
+		{"status < 500 || status == 503", 503, 0, "", true},
+		{"status < 500 || status == 503", 502, 0, "", false},
+		{`!(body contains "error")`, 200, 0, `{"ok":true}`, true},
+		{`!(body contains "error")`, 200, 0, `{"error":"boom"}`, false},
+		{`body == "pong"`, 200, 0, "pong", true},
+		{"status >= 200 && status <= 299 && !(latency > 1)", 204, 0.5, "", true},
+		{"(status == 404 || status == 410) && latency <= 0.1", 410, 0.1, "", true},
+		{"true", 500, 0, "", true},
+		{"status != 200", 200, 0, "", false},
+	}
+	for _, tt := range tests {
+		var e successExpr
+		if err := e.Set(tt.expr); err != nil {
+			t.Errorf("Set(%q): %v", tt.expr, err)
+			continue
+		}
+		got, err := e.match(tt.status, tt.latency, tt.body)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s with status %d, latency %v, body %q = %v, want %v", tt.expr, tt.status, tt.latency, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestSuccessExprErrors(t *testing.T) {
+	for _, bad := range []string{"status ==", "status == 200 &&", "(status == 200", "status = 200", `body contains "x`, "status == 200 )"} {
+		var e successExpr
+		if err := e.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want a syntax error", bad)
+		}
+	}
+	for _, expr := range []string{`status == "200"`, "status", "latency < body"} {
+		var e successExpr
+		if err := e.Set(expr); err != nil {
+			t.Errorf("Set(%q): %v", expr, err)
+			continue
+		}
+		if _, err := e.match(200, 0.1, "ok"); err == nil {
+			t.Errorf("%s evaluated without error, want a type error", expr)
+		}
+	}
+}
+
+func TestSuccessExprUsesBody(t *testing.T) {
+	var e successExpr
+	e.Set("status == 200")
+	if e.usesBody() {
+		t.Error("status-only expression reports that it uses the body")
+	}
+	e = successExpr{}
+	e.Set(`body contains "ok"`)
+	if !e.usesBody() {
+		t.Error("body expression does not report that it uses the body")
+	}
+}
+
+func TestSuccessExprClassifiesResponses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("status: degraded"))
+	}))
+	defer srv.Close()
+
+	// A 404 is a success here, and a 200 whose body reports trouble is not.
+	summary, _ := runSummary(t, "-url", srv.URL+"/missing", "-requests", "3", "-success-expr", "status == 404")
+	if summary.SuccessfulRequests != 3 {
+		t.Errorf("404s: %d successes, want 3", summary.SuccessfulRequests)
+	}
+	summary, _ = runSummary(t, "-url", srv.URL, "-requests", "3", "-success-expr", `status == 200 && !(body contains "degraded")`)
+	if summary.FailedRequests != 3 {
+		t.Errorf("degraded 200s: %d failures, want 3", summary.FailedRequests)
+	}
+}
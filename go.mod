@@ -1,3 +1,10 @@
 module github.com/saransridatha/httptest
 
 go 1.24.7
+
+require (
+	golang.org/x/net v0.41.0
+	golang.org/x/term v0.32.0
+)
+
+require golang.org/x/sys v0.33.0 // indirect
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInsecureHostsSet(t *testing.T) {
+	var hosts insecureHosts
+	for _, value := range []string{"Internal.Example.com", "10.0.0.5:8443", "[::1]:443"} {
+		if err := hosts.Set(value); err != nil {
+			t.Fatalf("Set(%q): %v", value, err)
+		}
+	}
+	for _, host := range []string{"internal.example.com", "INTERNAL.example.COM", "10.0.0.5", "::1"} {
+		if !hosts.contains(host) {
+			t.Errorf("%q is not listed", host)
+		}
+	}
+	for _, host := range []string{"example.com", "10.0.0.50", ""} {
+		if hosts.contains(host) {
+			t.Errorf("%q is listed", host)
+		}
+	}
+	if err := hosts.Set(" "); err == nil {
+		t.Error("an empty host was accepted")
+	}
+}
+
+func TestInsecureHost(t *testing.T) {
+	var mu sync.Mutex
+	protos := make(map[int]int)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		protos[r.ProtoMajor]++
+		mu.Unlock()
+	})
+	// Both servers have certificates no system root trusts. One is reached by
+	// name and the other by IP address, so each can be listed on its own.
+	quiet := log.New(io.Discard, "", 0) // rejected handshakes are expected
+	byName := httptest.NewUnstartedServer(handler)
+	byName.EnableHTTP2 = true
+	byName.Config.ErrorLog = quiet
+	byName.StartTLS()
+	defer byName.Close()
+	byIP := httptest.NewUnstartedServer(handler)
+	byIP.EnableHTTP2 = true
+	byIP.Config.ErrorLog = quiet
+	byIP.StartTLS()
+	defer byIP.Close()
+	nameURL := strings.Replace(byName.URL, "127.0.0.1", "localhost", 1)
+
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(path, []byte(nameURL+"\n"+byIP.URL+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		host, skipped, verified string
+	}{
+		{"localhost", nameURL, byIP.URL},
+		{"127.0.0.1", byIP.URL, nameURL},
+	} {
+		mu.Lock()
+		clear(protos)
+		mu.Unlock()
+		summary, out := runSummary(t, "-urls-file", path, "-requests", "6", "-concurrency", "1", "-insecure-host", tt.host, "-errors-per-category", "5")
+		if summary.SuccessfulRequests != 3 || summary.FailedRequests != 3 {
+			t.Errorf("-insecure-host %s: %d successes, %d failures; want 3 and 3:\n%s", tt.host, summary.SuccessfulRequests, summary.FailedRequests, out)
+		}
+		tlsErrs := summary.ErrorCategories["tls"]
+		if tlsErrs == nil || tlsErrs.Count != 3 || len(summary.ErrorCategories) != 1 {
+			t.Errorf("-insecure-host %s: error categories %v, want 3 TLS failures", tt.host, summary.ErrorCategories)
+		} else if example := tlsErrs.Examples[0]; !strings.Contains(example, strings.TrimPrefix(tt.verified, "https://")) || !strings.Contains(example, "certificate") {
+			t.Errorf("-insecure-host %s: TLS failure %q, want one verifying %s", tt.host, example, tt.verified)
+		}
+		// The transport still makes and traces the handshakes: one for the
+		// reused connection to the listed host and one per failed request.
+		if summary.TLSHandshakes != 4 || protos[2] != 3 {
+			t.Errorf("-insecure-host %s: %d TLS handshakes, requests by HTTP version %v; want 4 and 3 over HTTP/2", tt.host, summary.TLSHandshakes, protos)
+		}
+		if !strings.Contains(out, "Warning: TLS certificates are not verified for "+tt.host) {
+			t.Errorf("-insecure-host %s: no warning printed:\n%s", tt.host, out)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"time"
+)
+
+// requestTimings records when each phase of a single request started and ended.
+type requestTimings struct {
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	firstByte    time.Time
+	reused       bool
+	resumed      bool // the TLS handshake resumed an earlier session
+}
+
+// trace returns a ClientTrace that fills in t as the request progresses.
+func (t *requestTimings) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { t.connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { t.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(state tls.ConnectionState, _ error) { t.tlsDone = time.Now(); t.resumed = state.DidResume },
+		GotConn:              func(info httptrace.GotConnInfo) { t.gotConn = time.Now(); t.reused = info.Reused },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+}
+
+// phase returns the time between two trace events, or zero if either did not happen.
+func phase(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// inspectRequest sends a single request and writes the full exchange and a timing
+// breakdown to w, curl-style, without collecting any aggregate metrics.
+func inspectRequest(w io.Writer, client *http.Client, cfg *Config, targets targetSource, body bodyVariant) error {
+	timings := &requestTimings{}
+	ctx := httptrace.WithClientTrace(context.Background(), timings.trace())
+	req, _, err := newRequest(ctx, cfg, targets, body)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	fmt.Fprintf(w, "%sRequest%s\n%s-------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	fmt.Fprintf(w, "%s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
+	fmt.Fprintf(w, "Host: %s\n", req.Host)
+	if req.ContentLength > 0 {
+		fmt.Fprintf(w, "Content-Length: %d\n", req.ContentLength)
+	}
+	writeHeaders(w, req.Header)
+
+	timings.start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	total := time.Since(timings.start)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	statusColor := ColorGreen
+	if resp.StatusCode >= 400 {
+		statusColor = ColorRed
+	}
+	fmt.Fprintf(w, "\n%sResponse%s\n%s--------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	fmt.Fprintf(w, "%s%s %s%s\n", statusColor, resp.Proto, resp.Status, ColorReset)
+	writeHeaders(w, resp.Header)
+
+	fmt.Fprintf(w, "\n%sTiming%s\n%s------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	fmt.Fprintf(w, "DNS Lookup               : %s\n", phase(timings.dnsStart, timings.dnsDone))
+	fmt.Fprintf(w, "TCP Connect              : %s\n", phase(timings.connectStart, timings.connectDone))
+	fmt.Fprintf(w, "TLS Handshake            : %s\n", phase(timings.tlsStart, timings.tlsDone))
+	fmt.Fprintf(w, "Time to First Byte       : %s\n", phase(timings.gotConn, timings.firstByte))
+	fmt.Fprintf(w, "Content Transfer         : %s\n", phase(timings.firstByte, timings.start.Add(total)))
+	fmt.Fprintf(w, "Total                    : %s%s%s\n", ColorCyan, total, ColorReset)
+	fmt.Fprintf(w, "Connection Reused        : %t\n", timings.reused)
+
+	fmt.Fprintf(w, "\n%sBody (%d bytes)%s\n%s--------------%s\n", ColorYellow, len(respBody), ColorReset, ColorYellow, ColorReset)
+	fmt.Fprintln(w, string(respBody))
+	return nil
+}
+
+// writeHeaders writes headers to w in sorted order, one 'Key: Value' line each.
+func writeHeaders(w io.Writer, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(w, "%s: %s\n", key, value)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+		w.Write([]byte("hello inspector"))
+	}))
+	defer srv.Close()
+
+	out, code := runTool(t, "-url", srv.URL+"/path?q=1", "-inspect", "-header", "X-Test:probe")
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, out)
+	}
+	for _, want := range []string{
+		"Request\n-------\n",
+		"GET /path?q=1 HTTP/1.1\n",
+		"X-Test: probe\n",
+		"Response\n--------\n",
+		"HTTP/1.1 200 OK",
+		"X-Echo: probe\n",
+		"Timing\n------\n",
+		"TCP Connect              : ",
+		"Time to First Byte       : ",
+		"Total                    : ",
+		"Body (15 bytes)",
+		"hello inspector\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Requests Sent") {
+		t.Errorf("inspection printed aggregate stats:\n%s", out)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonPathAssertion checks that a JSONPath expression evaluated against a JSON
+// response body yields an expected value.
+type jsonPathAssertion struct {
+	Path     string
+	Expected interface{}
+	raw      string
+}
+
+// jsonPathAssertions is a custom flag type for handling multiple -assert-jsonpath flags.
+type jsonPathAssertions []jsonPathAssertion
+
+func (a *jsonPathAssertions) String() string {
+	parts := make([]string, len(*a))
+	for i, assertion := range *a {
+		parts[i] = assertion.raw
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Set parses an assertion of the form 'expr=expected'. The expected value is read
+// as a JSON literal (e.g. "ok", 200, true, null) and otherwise as a plain string.
+func (a *jsonPathAssertions) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected format 'expr=expected', got %q", value)
+	}
+	path := strings.TrimSpace(parts[0])
+	// Accept 'expr == expected' as well as 'expr=expected'.
+	expectedText := strings.TrimSpace(strings.TrimPrefix(parts[1], "="))
+	if _, err := parseJSONPath(path); err != nil {
+		return err
+	}
+
+	var expected interface{}
+	if err := json.Unmarshal([]byte(expectedText), &expected); err != nil {
+		expected = expectedText
+	}
+	*a = append(*a, jsonPathAssertion{Path: path, Expected: expected, raw: value})
+	return nil
+}
+
+// check evaluates the assertion against a parsed JSON document and returns an
+// error describing any mismatch.
+func (a jsonPathAssertion) check(doc interface{}) error {
+	actual, err := evalJSONPath(doc, a.Path)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(actual, a.Expected) {
+		return fmt.Errorf("%s is %s, want %s", a.Path, jsonText(actual), jsonText(a.Expected))
+	}
+	return nil
+}
+
+func jsonText(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// jsonPathStep is a single object key or array index in a parsed JSONPath.
+type jsonPathStep struct {
+	key   string
+	index int
+	isKey bool
+}
+
+// parseJSONPath parses the subset of JSONPath made of a leading '$' followed by
+// '.key', '[index]' and '['key']' segments.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath %q must start with '$'", path)
+	}
+	var steps []jsonPathStep
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("JSONPath %q has an empty key", path)
+			}
+			steps = append(steps, jsonPathStep{key: key, isKey: true})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("JSONPath %q has an unclosed '['", path)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1], isKey: true})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("JSONPath %q has an invalid index %q", path, inner)
+				}
+				steps = append(steps, jsonPathStep{index: index})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("JSONPath %q has unexpected %q", path, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+// evalJSONPath returns the value path addresses within doc, a document decoded
+// by encoding/json into interface{} values.
+func evalJSONPath(doc interface{}, path string) (interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	current := doc
+	for _, step := range steps {
+		if step.isKey {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: cannot look up key %q in a non-object", path, step.key)
+			}
+			if current, ok = obj[step.key]; !ok {
+				return nil, fmt.Errorf("%s: key %q not found", path, step.key)
+			}
+			continue
+		}
+		arr, ok := current.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: cannot index a non-array", path)
+		}
+		index := step.index
+		if index < 0 {
+			index += len(arr)
+		}
+		if index < 0 || index >= len(arr) {
+			return nil, fmt.Errorf("%s: index %d out of range", path, step.index)
+		}
+		current = arr[index]
+	}
+	return current, nil
+}
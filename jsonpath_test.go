@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONPathAssertionCheck(t *testing.T) {
+	var doc interface{}
+	body := `{"status":"ok","data":{"items":[{"id":7},{"id":8}],"count":2,"done":true}}`
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		assertion string
+		pass      bool
+	}{
+		{`$.status="ok"`, true},
+		{`$.status=ok`, true},
+		{`$.data.count=2`, true},
+		{`$.data.done == true`, true},
+		{`$.data.items[1].id=8`, true},
+		{`$.data['count']=2`, true},
+		{`$.status="failed"`, false},
+		{`$.data.count="2"`, false},
+		{`$.data.items[5].id=8`, false},
+		{`$.missing=1`, false},
+	}
+	for _, tt := range tests {
+		var assertions jsonPathAssertions
+		if err := assertions.Set(tt.assertion); err != nil {
+			t.Errorf("Set(%q): %v", tt.assertion, err)
+			continue
+		}
+		if err := assertions[0].check(doc); (err == nil) != tt.pass {
+			t.Errorf("%s: err = %v, want pass=%v", tt.assertion, err, tt.pass)
+		}
+	}
+}
+
+func TestJSONPathAssertionSyntax(t *testing.T) {
+	for _, bad := range []string{`status=ok`, `$.status`, `$..x=1`, `$[x=1`, `$[abc]=1`} {
+		var assertions jsonPathAssertions
+		if err := assertions.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestJSONPathAssertionsClassifyResponses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok","count":3}`))
+	}))
+	defer srv.Close()
+
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "4", "-assert-jsonpath", `$.status="ok"`, "-assert-jsonpath", "$.count=3")
+	if summary.SuccessfulRequests != 4 || summary.AssertionFailures != 0 {
+		t.Errorf("passing assertions: %d successes, %d assertion failures; want 4 and 0", summary.SuccessfulRequests, summary.AssertionFailures)
+	}
+
+	summary, _ = runSummary(t, "-url", srv.URL, "-requests", "4", "-assert-jsonpath", `$.status="ok"`, "-assert-jsonpath", "$.count=4")
+	if summary.FailedRequests != 4 || summary.AssertionFailures != 4 {
+		t.Errorf("failing assertion: %d failures, %d assertion failures; want 4 and 4", summary.FailedRequests, summary.AssertionFailures)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// junitSuite is the <testsuite> element of a -junit report. The run is the suite
+// and each SLO, assertion and baseline check is one test case.
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitCases turns the checks configured for the run into test cases. A check
+// passes or fails on the same terms as the console report; the measured and
+// expected values are given either way.
+func junitCases(summary *Summary, cfg *Config, comparisons []Comparison) []junitCase {
+	var cases []junitCase
+	add := func(class, name string, failed bool, detail string) {
+		c := junitCase{Name: name, ClassName: class}
+		if failed {
+			c.Failure = &junitFailure{Message: detail, Text: detail}
+		} else {
+			c.SystemOut = detail
+		}
+		cases = append(cases, c)
+	}
+
+	abortDetail := "the run completed"
+	if summary.AbortReason != "" {
+		abortDetail = "the run was aborted: " + summary.AbortReason
+	}
+	add("httptest.run", "run completed", summary.AbortReason != "", abortDetail)
+
+	if cfg.SLOP99 > 0 {
+		add("httptest.slo", "p99 latency", summary.Percentile99 > cfg.SLOP99,
+			fmt.Sprintf("measured p99 %.4fs, expected at most %gs", summary.Percentile99, cfg.SLOP99))
+	}
+	if cfg.SLOErrorRate > 0 {
+		add("httptest.slo", "error rate", summary.FailureRate > cfg.SLOErrorRate,
+			fmt.Sprintf("measured error rate %.2f%%, expected at most %g%%", summary.FailureRate, cfg.SLOErrorRate))
+	}
+
+	var assertions []string
+	for _, a := range cfg.JSONPathAsserts {
+		assertions = append(assertions, a.raw)
+	}
+	for _, a := range cfg.TrailerAsserts {
+		assertions = append(assertions, "trailer "+a.Name+"="+a.Value)
+	}
+	if len(assertions) > 0 {
+		add("httptest.assertions", strings.Join(assertions, ", "), summary.AssertionFailures > 0,
+			fmt.Sprintf("%d of %d responses failed the assertions, expected 0", summary.AssertionFailures, summary.TotalRequestsSent))
+	}
+	if cfg.ExpectSHA256 != "" {
+		add("httptest.assertions", "response sha256", summary.IntegrityFailures > 0,
+			fmt.Sprintf("%d of %d responses did not match SHA-256 %s, expected 0", summary.IntegrityFailures, summary.TotalRequestsSent, cfg.ExpectSHA256))
+	}
+	if cfg.MaxAcceptableLatency > 0 {
+		add("httptest.assertions", "max acceptable latency", summary.TooSlowRequests > 0,
+			fmt.Sprintf("%d of %d responses took over %gs, expected 0", summary.TooSlowRequests, summary.TotalRequestsSent, cfg.MaxAcceptableLatency))
+	}
+
+	for _, c := range comparisons {
+		add("httptest.baseline", c.Metric, c.Regressed,
+			fmt.Sprintf("baseline %.4f, measured %.4f (%+.2f%s, limit %.2f%s)", c.Baseline, c.Current, c.Change, c.unit(), c.Tolerance, c.unit()))
+	}
+	return cases
+}
+
+// writeJUnit writes the -junit report for a finished run to path.
+func writeJUnit(path string, summary *Summary, cfg *Config, comparisons []Comparison) error {
+	target := cfg.URL
+	if target == "" {
+		target = cfg.URLsFile
+	}
+	suite := junitSuite{
+		Name:  "httptest " + target,
+		Time:  summary.TotalTimeTaken,
+		Cases: junitCases(summary, cfg, comparisons),
+	}
+	suite.Tests = len(suite.Cases)
+	for _, c := range suite.Cases {
+		if c.Failure != nil {
+			suite.Failures++
+		}
+	}
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
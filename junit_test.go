@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readJUnit parses a -junit report back into its suite.
+func readJUnit(t *testing.T, path string) junitSuite {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Errorf("report does not start with the XML header:\n%s", data)
+	}
+	var suite junitSuite
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, data)
+	}
+	return suite
+}
+
+func TestWriteJUnit(t *testing.T) {
+	summary := &Summary{TotalRequestsSent: 100, Percentile99: 0.8, FailureRate: 1, TotalTimeTaken: 12.5}
+	cfg := &Config{URL: "http://example.com/api", SLOP99: 0.5, SLOErrorRate: 5}
+	comparisons := []Comparison{
+		{Metric: "Requests per Second", Key: "rps", Baseline: 100, Current: 95, Change: -5, Tolerance: 10},
+	}
+	path := filepath.Join(t.TempDir(), "report.xml")
+	if err := writeJUnit(path, summary, cfg, comparisons); err != nil {
+		t.Fatal(err)
+	}
+
+	suite := readJUnit(t, path)
+	if suite.Name != "httptest http://example.com/api" || suite.Time != 12.5 {
+		t.Errorf("suite name %q and time %v", suite.Name, suite.Time)
+	}
+	if suite.Tests != 4 || suite.Failures != 1 || len(suite.Cases) != 4 {
+		t.Fatalf("%d tests (%d cases) with %d failures, want 4 with 1", suite.Tests, len(suite.Cases), suite.Failures)
+	}
+	want := []struct {
+		class, name string
+		failed      bool
+	}{
+		{"httptest.run", "run completed", false},
+		{"httptest.slo", "p99 latency", true},
+		{"httptest.slo", "error rate", false},
+		{"httptest.baseline", "Requests per Second", false},
+	}
+	for i, w := range want {
+		c := suite.Cases[i]
+		if c.ClassName != w.class || c.Name != w.name || (c.Failure != nil) != w.failed {
+			t.Errorf("case %d = %s/%s failed=%v, want %s/%s failed=%v", i, c.ClassName, c.Name, c.Failure != nil, w.class, w.name, w.failed)
+		}
+	}
+	if f := suite.Cases[1].Failure; f == nil || f.Message != "measured p99 0.8000s, expected at most 0.5s" || f.Text != f.Message {
+		t.Errorf("p99 failure = %+v", f)
+	}
+	if out := suite.Cases[2].SystemOut; out != "measured error rate 1.00%, expected at most 5%" {
+		t.Errorf("passing case output = %q", out)
+	}
+}
+
+func TestJUnitReport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "report.xml")
+	out, _ := runTool(t, "-url", srv.URL, "-requests", "3", "-slo-p99", "0.01", "-junit", path)
+	if !strings.Contains(out, "JUnit report saved to "+path) {
+		t.Errorf("report not announced:\n%s", out)
+	}
+	suite := readJUnit(t, path)
+	if suite.Tests != 2 || suite.Failures != 1 {
+		t.Errorf("%d tests with %d failures, want the run and a failed p99 SLO", suite.Tests, suite.Failures)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// requestLog writes the -requests-csv log: one row per request with its
+// wall-clock start and end, so runs can be lined up with server logs and traces.
+type requestLog struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *csv.Writer
+}
+
+func openRequestLog(path string) (*requestLog, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &requestLog{file: file, w: csv.NewWriter(file)}
+	l.w.Write([]string{"start", "end", "latency_seconds", "status", "success", "error", "request_id"})
+	return l, nil
+}
+
+// record adds a row for one request. start is when it was sent and end when its
+// response had been handled, so the two bracket its recorded latency. status is 0
+// if no response arrived; err is why the request failed, if it did; requestID is
+// the -request-id-header value sent with it, if any.
+func (l *requestLog) record(start, end time.Time, latency float64, status int, success bool, err error, requestID string) {
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write([]string{
+		start.UTC().Format(time.RFC3339Nano),
+		end.UTC().Format(time.RFC3339Nano),
+		strconv.FormatFloat(latency, 'f', 6, 64),
+		strconv.Itoa(status),
+		strconv.FormatBool(success),
+		errText,
+		requestID,
+	})
+}
+
+// Close flushes the log and closes its file.
+func (l *requestLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
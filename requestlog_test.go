@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestsCSV(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		if n == 4 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "requests.csv")
+	before := time.Now()
+	out, _ := runTool(t, "-url", srv.URL, "-requests", "4", "-concurrency", "1", "-requests-csv", path, "-request-id-header", "X-Request-ID")
+	after := time.Now()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("no requests CSV: %v\n%s", err, out)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || rows[0][0] != "start" || rows[0][6] != "request_id" {
+		t.Fatalf("got %d rows with header %v, want a header and 4 requests", len(rows), rows[0])
+	}
+	for i, row := range rows[1:] {
+		start, err1 := time.Parse(time.RFC3339Nano, row[0])
+		end, err2 := time.Parse(time.RFC3339Nano, row[1])
+		latency, err3 := strconv.ParseFloat(row[2], 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			t.Fatalf("row %d %v does not parse: %v %v %v", i+1, row, err1, err2, err3)
+		}
+		if start.Before(before) || end.After(after) {
+			t.Errorf("row %d: %s to %s falls outside the run, %s to %s", i+1, start, end, before, after)
+		}
+		// Latency is rounded to the microsecond in the log.
+		if latency < 0.02 || end.Sub(start).Seconds() < latency-1e-6 {
+			t.Errorf("row %d: %s to %s does not bracket a latency of %vs", i+1, start, end, latency)
+		}
+		if row[6] == "" {
+			t.Errorf("row %d has no request ID", i+1)
+		}
+	}
+	if ok := rows[1][3] == "200" && rows[1][4] == "true"; !ok {
+		t.Errorf("first request logged as %v, want a 200 success", rows[1])
+	}
+	if ok := rows[4][3] == "500" && rows[4][4] == "false"; !ok {
+		t.Errorf("last request logged as %v, want a 500 failure", rows[4])
+	}
+}
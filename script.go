@@ -1,21 +1,43 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
+	mrand "math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"runtime/pprof"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"golang.org/x/term"
 )
 
 // ANSI color codes
@@ -33,34 +55,692 @@ type HistogramBucket struct {
 	Count int     `json:"count"`
 }
 
+// histogramBucketJSON is the wire form of a HistogramBucket. JSON cannot represent
+// infinity, so the open-ended last bucket is encoded with a null mark.
+type histogramBucketJSON struct {
+	Mark  *float64 `json:"mark"`
+	Count int      `json:"count"`
+}
+
+func (b *HistogramBucket) MarshalJSON() ([]byte, error) {
+	wire := histogramBucketJSON{Count: b.Count}
+	if !math.IsInf(b.Mark, 1) {
+		wire.Mark = &b.Mark
+	}
+	return json.Marshal(wire)
+}
+
+func (b *HistogramBucket) UnmarshalJSON(data []byte) error {
+	var wire histogramBucketJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	b.Count = wire.Count
+	b.Mark = math.Inf(1)
+	if wire.Mark != nil {
+		b.Mark = *wire.Mark
+	}
+	return nil
+}
+
+// TimelineInterval aggregates the requests that completed during one second of the run.
+type TimelineInterval struct {
+	Second        int       `json:"second"`
+	Requests      int       `json:"requests"`
+	Failures      int       `json:"failures"`
+	Resets        int       `json:"resets"`
+	ResponseTimes []float64 `json:"-"`
+	// In-flight request counts sampled by sampleInFlight during this second.
+	inFlightSum     int64
+	inFlightSamples int
+}
+
+// avgInFlight returns the average sampled in-flight count, rounded, or 0 if the
+// interval was never sampled.
+func (i *TimelineInterval) avgInFlight() int {
+	if i.inFlightSamples == 0 {
+		return 0
+	}
+	return int(math.Round(float64(i.inFlightSum) / float64(i.inFlightSamples)))
+}
+
 // Metrics holds the collected data from the load test.
 type Metrics struct {
 	SuccessCount    int64
 	FailureCount    int64
-	ResponseTimes   []float64
+	ResponseTimes   []float64 // every response time, unless -max-latency-samples is set
+	TimedCount      int64     // response times recorded, kept or not
+	TimeSum         float64
+	TimeMin         float64
+	TimeMax         float64
 	StatusCodeCount map[int]int
 	Histogram       []*HistogramBucket
 	ErrorLog        []string
-	Lock            sync.Mutex
+	ErrorExamples   *errorExamples // nil unless -errors-per-category is set
+	AbortReason     string
+	BodyUsage       map[string]int
+	PollCount       int64
+	PollTimeouts    int64
+	AssertFailures  int64
+	IntegrityFails  int64 // responses whose body did not match -expect-sha256
+	TooSlow         int64
+	OpenConns       int64
+	BytesReceived   int64 // response body bytes from all requests
+	GoodBytes       int64 // response body bytes from successful requests only
+	PeakConns       int64
+	InFlight        int64 // requests dispatched and not yet completed; updated atomically
+	PeakInFlight    int64
+	ClampedCount    int64   // responses slower than -clamp-max-latency
+	ClampedMax      float64 // the slowest of them, in seconds
+	CancelledSlow   int64   // requests cancelled at -soft-deadline; neither successes nor failures
+	TLSHandshakes   int64
+	TLSResumed      int64 // handshakes that resumed an earlier session
+	TrailerValues   map[string]map[string]int
+	Cache           *CacheStats                    // nil unless -analyze-cache is set
+	ContentTypes    map[string]*contentTypeSamples // nil unless -split-by-content-type is set
+	Slowest         *slowestRequests               // the -top-slowest slowest requests, if set
+	ServerTiming    map[string]*timingSample       // seconds reported per Server-Timing metric name
+	StreamBytes     int64
+	StreamReadTimes timingSample   // seconds spent reading each -stream-response body
+	QueueTimes      timingSample   // seconds each request waited for a free concurrency slot
+	BuildTimes      timingSample   // seconds spent building each request before it was sent
+	RecentTimes     *latencyRing   // the last -max-latency-samples response times, if set
+	Digest          *latencyDigest // lifetime response times with -max-latency-samples
+	TTFBTimes       timingSample   // seconds from sending each request to its first response byte
+	WorstTTFB       float64
+	WorstTTFBSecond int // second of the run, as in Timeline, in which WorstTTFB arrived
+	// EarlyDNSFailures counts DNS-resolution failures until the first request gets
+	// past DNS, which sets TargetResolved.
+	EarlyDNSFailures int
+	TargetResolved   bool
+	LastDNSError     string
+	ConnResets       int64
+	ConnectFailures  int64                      // failed requests that never got a connection
+	Backends         map[string]*backendSamples // by address, with -backends-file
+	Timeouts         map[string]int64           // timed-out requests by timeoutCategory
+	StartTime        time.Time
+	Timeline         []*TimelineInterval
+	TimesKept        int            // seconds of per-interval response times the timeline keeps
+	TimeseriesStep   int            // seconds per -timeseries-csv row, or 0 without it
+	TimeseriesRows   []*TimingStats // latency of each -timeseries-csv row closed so far
+	Lock             sync.Mutex
 }
 
 // Summary holds the final calculated results of the load test.
 type Summary struct {
-	TotalRequestsSent   int64               `json:"totalRequestsSent"`
-	SuccessfulRequests  int64               `json:"successfulRequests"`
-	FailedRequests      int64               `json:"failedRequests"`
-	SuccessRate         float64             `json:"successRate"`
-	FailureRate         float64             `json:"failureRate"`
-	TotalTimeTaken      float64             `json:"totalTimeTaken"`
-	RequestsPerSecond   float64             `json:"requestsPerSecond"`
-	AvgResponseTime     float64             `json:"avgResponseTime"`
-	MinResponseTime     float64             `json:"minResponseTime"`
-	MaxResponseTime     float64             `json:"maxResponseTime"`
-	Percentile90        float64             `json:"percentile90"`
-	Percentile99        float64             `json:"percentile99"`
-	StatusCodeDist      map[int]int         `json:"statusCodeDistribution"`
-	Histogram           []*HistogramBucket  `json:"histogram"`
-	ErrorSummary        []string            `json:"errorSummary"`
+	TotalRequestsSent  int64                        `json:"totalRequestsSent"`
+	SuccessfulRequests int64                        `json:"successfulRequests"`
+	FailedRequests     int64                        `json:"failedRequests"`
+	ConnectionFailures int64                        `json:"connectionFailures"` // failures before a connection was established
+	SuccessRate        float64                      `json:"successRate"`
+	FailureRate        float64                      `json:"failureRate"`
+	TotalTimeTaken     float64                      `json:"totalTimeTaken"`
+	RequestsPerSecond  float64                      `json:"requestsPerSecond"`
+	AvgResponseTime    float64                      `json:"avgResponseTime"`
+	MinResponseTime    float64                      `json:"minResponseTime"`
+	MaxResponseTime    float64                      `json:"maxResponseTime"`
+	Percentile90       float64                      `json:"percentile90"`
+	Percentile99       float64                      `json:"percentile99"`
+	P99CI              *ConfidenceInterval          `json:"p99ConfidenceInterval,omitempty"`
+	TimedRequests      int                          `json:"timedRequests"` // requests with a response time; the latency fields are zero without any
+	Clamped            *ClampStats                  `json:"clamped,omitempty"`
+	CancelledSlow      int64                        `json:"cancelledSlow,omitempty"` // cancelled at -soft-deadline and left out of the other counts
+	StatusCodeDist     map[int]int                  `json:"statusCodeDistribution"`
+	Histogram          []*HistogramBucket           `json:"histogram"`
+	CDF                []CDFPoint                   `json:"cdf"`
+	ErrorSummary       []string                     `json:"errorSummary"`
+	ErrorCategories    map[string]*ErrorCategory    `json:"errorCategories,omitempty"` // with -errors-per-category
+	SlowestRequests    []SlowRequest                `json:"slowestRequests,omitempty"`
+	SLOBudget          *SLOBudget                   `json:"sloBudget,omitempty"`
+	RequestEncoding    string                       `json:"requestEncoding"`
+	AbortReason        string                       `json:"abortReason,omitempty"`
+	BodyUsage          map[string]int               `json:"bodyUsage,omitempty"`
+	RPSStats           *RPSStats                    `json:"rpsStats,omitempty"`
+	PollCount          int64                        `json:"pollCount,omitempty"`
+	PollTimeouts       int64                        `json:"pollTimeouts,omitempty"`
+	AssertionFailures  int64                        `json:"assertionFailures,omitempty"`
+	IntegrityFailures  int64                        `json:"integrityFailures,omitempty"`
+	TooSlowRequests    int64                        `json:"tooSlowRequests,omitempty"`
+	PeakConnections    int64                        `json:"peakConnections"`
+	Concurrency        *ConcurrencyStats            `json:"concurrency,omitempty"`
+	TLSHandshakes      int64                        `json:"tlsHandshakes"`
+	TLSResumed         int64                        `json:"tlsResumed"`
+	Throughput         ThroughputStats              `json:"throughput"`
+	TrailerValues      map[string]map[string]int    `json:"trailerValues,omitempty"`
+	Cache              *CacheStats                  `json:"cache,omitempty"`
+	ContentTypes       map[string]*ContentTypeStats `json:"contentTypes,omitempty"`
+	Backends           map[string]*BackendStats     `json:"backends,omitempty"`
+	ServerTiming       map[string]*TimingStats      `json:"serverTiming,omitempty"` // durations the server reported, per metric
+	Metadata           *RunMetadata                 `json:"metadata"`
+	Stream             *StreamStats                 `json:"stream,omitempty"`
+	QueueWait          *TimingStats                 `json:"queueWait,omitempty"`     // waits for a free concurrency slot
+	BuildOverhead      *TimingStats                 `json:"buildOverhead,omitempty"` // client-side time building each request
+	RecentLatency      *TimingStats                 `json:"recentLatency,omitempty"` // over the last -max-latency-samples responses
+	TTFB               *TTFBStats                   `json:"ttfb,omitempty"`
+	RecentSamples      int                          `json:"recentSamples,omitempty"`
+	DigestPercentiles  bool                         `json:"digestPercentiles,omitempty"` // lifetime percentiles estimated by the -max-latency-samples digest
+	ConnectionResets   *ResetStats                  `json:"connectionResets,omitempty"`
+	Timeouts           map[string]int64             `json:"timeouts,omitempty"` // by phase: connect-, header-, body- or total-timeout
+}
+
+// ConfidenceInterval is a 95% confidence interval for a percentile estimate, and
+// the width -until-ci-width asked for.
+type ConfidenceInterval struct {
+	Lower  float64 `json:"lower"`
+	Upper  float64 `json:"upper"`
+	Width  float64 `json:"width"`
+	Target float64 `json:"target"`
+}
+
+// ClampStats describes the responses slower than -clamp-max-latency, which the
+// latency distribution records at the ceiling instead of their true time.
+type ClampStats struct {
+	Ceiling float64 `json:"ceiling"`
+	Count   int64   `json:"count"`
+	TrueMax float64 `json:"trueMax"`
+}
+
+// ThroughputStats compares all response bytes received (throughput) with the bytes
+// of successful responses only (goodput), which is the capacity actually useful.
+type ThroughputStats struct {
+	TotalBytes       int64   `json:"totalBytes"`
+	GoodBytes        int64   `json:"goodBytes"`
+	BytesPerSecond   float64 `json:"bytesPerSecond"`
+	GoodputPerSecond float64 `json:"goodputPerSecond"`
+}
+
+// TTFBStats describes how stable the time to first byte was. StdDev is the
+// jitter; Max is the worst spike and MaxAtSecond the second of the run, matching
+// the RPS and reset series, in which it occurred.
+type TTFBStats struct {
+	Avg         float64 `json:"avg"`
+	StdDev      float64 `json:"stdDev"`
+	P99         float64 `json:"p99"`
+	Max         float64 `json:"max"`
+	MaxAtSecond int     `json:"maxAtSecond"`
+}
+
+// contentTypeSamples collects the responses of one Content-Type for
+// -split-by-content-type.
+type contentTypeSamples struct {
+	Times timingSample
+	Bytes int64
+}
+
+// backendSamples collects the requests sent to one -backends-file address.
+// Times only covers requests that got a response.
+type backendSamples struct {
+	Requests int64
+	Failures int64
+	Times    timingSample
+}
+
+// BackendStats summarizes the requests sent to one backend.
+type BackendStats struct {
+	Requests int64        `json:"requests"`
+	Failures int64        `json:"failures"`
+	Latency  *TimingStats `json:"latency"`
+}
+
+// ContentTypeStats summarizes the responses of one Content-Type.
+type ContentTypeStats struct {
+	Responses  int          `json:"responses"`
+	Latency    *TimingStats `json:"latency"`
+	TotalBytes int64        `json:"totalBytes"`
+	AvgBytes   float64      `json:"avgBytes"`
+}
+
+// normalizeContentType reduces a Content-Type header to its lower-case media
+// type, so "text/html; charset=UTF-8" and "text/html" are counted together.
+func normalizeContentType(value string) string {
+	mediaType, _, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return "(none)"
+	}
+	return mediaType
+}
+
+// maxServerTimingNames caps the distinct Server-Timing metric names tracked, so a
+// server putting IDs or other unbounded values in the names cannot grow memory
+// without limit. Names first seen after the cap is reached are ignored.
+const maxServerTimingNames = 32
+
+// parseServerTiming returns the durations, in seconds, of the metrics in
+// Server-Timing header values such as `db;dur=53, app;desc="App, total";dur=47.2`.
+// Metrics without a dur parameter are skipped.
+func parseServerTiming(values []string) map[string]float64 {
+	durations := make(map[string]float64)
+	for _, value := range values {
+		for _, metric := range splitUnquoted(value, ',') {
+			params := splitUnquoted(metric, ';')
+			name := strings.TrimSpace(params[0])
+			if name == "" {
+				continue
+			}
+			for _, param := range params[1:] {
+				key, val, _ := strings.Cut(param, "=")
+				if !strings.EqualFold(strings.TrimSpace(key), "dur") {
+					continue
+				}
+				if ms, err := strconv.ParseFloat(strings.Trim(strings.TrimSpace(val), `"`), 64); err == nil && ms >= 0 {
+					durations[name] += ms / 1000
+				}
+			}
+		}
+	}
+	return durations
+}
+
+// splitUnquoted splits s at each sep that is not inside a quoted string.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// CacheStats aggregates the Cache-Control headers of responses, to show how
+// cacheable a target's responses are. MaxAge counts responses by their max-age
+// value in seconds.
+type CacheStats struct {
+	Responses        int64          `json:"responses"`
+	WithCacheControl int64          `json:"withCacheControl"`
+	NoStore          int64          `json:"noStore"`
+	NoCache          int64          `json:"noCache"`
+	Private          int64          `json:"private"`
+	MaxAge           map[string]int `json:"maxAge"`
+}
+
+func newCacheStats() *CacheStats {
+	return &CacheStats{MaxAge: make(map[string]int)}
+}
+
+// record adds one response's headers to the counts.
+func (c *CacheStats) record(header http.Header) {
+	c.Responses++
+	values := header.Values("Cache-Control")
+	if len(values) == 0 {
+		return
+	}
+	c.WithCacheControl++
+	for _, directive := range strings.Split(strings.Join(values, ","), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store":
+			c.NoStore++
+		case "no-cache":
+			c.NoCache++
+		case "private":
+			c.Private++
+		case "max-age":
+			c.MaxAge[strings.Trim(value, `"`)]++
+		}
+	}
+}
+
+func (c *CacheStats) copy() *CacheStats {
+	out := *c
+	out.MaxAge = make(map[string]int, len(c.MaxAge))
+	for value, count := range c.MaxAge {
+		out.MaxAge[value] = count
+	}
+	return &out
+}
+
+// TimingStats summarizes a set of durations, in seconds, spent in one phase of
+// sending requests.
+type TimingStats struct {
+	Avg float64 `json:"avg"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// newTimingStats summarizes times without reordering the caller's slice.
+func newTimingStats(times []float64) *TimingStats {
+	sorted := make([]float64, len(times))
+	copy(sorted, times)
+	sort.Float64s(sorted)
+	return &TimingStats{
+		Avg: average(sorted),
+		P50: percentile(sorted, 50),
+		P90: percentile(sorted, 90),
+		P99: percentile(sorted, 99),
+		Max: max(sorted),
+	}
+}
+
+// latencyRing keeps the most recent response times in a fixed amount of memory,
+// overwriting the oldest once it is full.
+type latencyRing struct {
+	samples []float64
+	next    int
+	full    bool
+}
+
+func newLatencyRing(size int) *latencyRing {
+	return &latencyRing{samples: make([]float64, size)}
+}
+
+func (r *latencyRing) add(v float64) {
+	r.samples[r.next] = v
+	r.next++
+	if r.next == len(r.samples) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// recordTime records a response time. Without -max-latency-samples every time
+// is kept in ResponseTimes. With it, the most recent times are kept in the ring
+// and the lifetime percentiles come from a latencyDigest, so memory stays
+// bounded over long runs; the count, sum, min and max stay exact. The caller
+// must hold metrics.Lock.
+func (m *Metrics) recordTime(t float64) {
+	m.TimedCount++
+	m.TimeSum += t
+	if m.TimedCount == 1 || t < m.TimeMin {
+		m.TimeMin = t
+	}
+	if t > m.TimeMax {
+		m.TimeMax = t
+	}
+	if m.RecentTimes == nil {
+		m.ResponseTimes = append(m.ResponseTimes, t)
+		return
+	}
+	m.RecentTimes.add(t)
+	m.Digest.add(t)
+}
+
+// values returns the retained samples, oldest first.
+func (r *latencyRing) values() []float64 {
+	if !r.full {
+		return append([]float64(nil), r.samples[:r.next]...)
+	}
+	return append(append([]float64(nil), r.samples[r.next:]...), r.samples[:r.next]...)
+}
+
+// Bounds of the latencyDigest buckets: the first holds every time up to
+// digestMinTime, and each later bucket's upper bound is digestGrowth times the
+// one before, so 1µs to a day takes about 1300 buckets.
+const (
+	digestMinTime = 1e-6
+	digestGrowth  = 1.02
+)
+
+// latencyDigest estimates quantiles of a stream of response times in bounded
+// memory, in the manner of an HDR histogram: times are counted in buckets whose
+// bounds grow geometrically, and a quantile is reported as the geometric middle
+// of the bucket it falls in, within 1% of the true value. The zero value is ready
+// to use.
+type latencyDigest struct {
+	counts []int64
+	total  int64
+	min    float64
+	max    float64
+}
+
+func digestBucket(t float64) int {
+	if t <= digestMinTime {
+		return 0
+	}
+	return int(math.Ceil(math.Log(t/digestMinTime) / math.Log(digestGrowth)))
+}
+
+func (d *latencyDigest) add(t float64) {
+	i := digestBucket(t)
+	for len(d.counts) <= i {
+		d.counts = append(d.counts, 0)
+	}
+	d.counts[i]++
+	d.total++
+	if d.total == 1 || t < d.min {
+		d.min = t
+	}
+	if t > d.max {
+		d.max = t
+	}
+}
+
+// quantile estimates the p-th percentile, ranking times as percentile does. The
+// estimate is kept within the smallest and largest times seen.
+func (d *latencyDigest) quantile(p float64) float64 {
+	if d.total == 0 {
+		return 0
+	}
+	rank := int64(float64(d.total) * (p / 100.0))
+	if rank >= d.total-1 {
+		// The largest time is tracked exactly.
+		return d.max
+	}
+	var seen int64
+	for i, count := range d.counts {
+		seen += count
+		if seen > rank {
+			estimate := digestMinTime * math.Pow(digestGrowth, float64(i)-0.5)
+			return math.Min(math.Max(estimate, d.min), d.max)
+		}
+	}
+	return d.max
+}
+
+// countAbove estimates how many times exceeded limit by counting the buckets
+// above limit's own, so times over limit by less than 2% may be missed.
+func (d *latencyDigest) countAbove(limit float64) int64 {
+	var above int64
+	for i := len(d.counts) - 1; i > digestBucket(limit); i-- {
+		above += d.counts[i]
+	}
+	return above
+}
+
+// errorExamples keeps, for -errors-per-category, a count of the errors in each
+// category and up to limit distinct messages as examples.
+type errorExamples struct {
+	limit    int
+	counts   map[string]int64
+	messages map[string][]string
+}
+
+func newErrorExamples(limit int) *errorExamples {
+	return &errorExamples{limit: limit, counts: make(map[string]int64), messages: make(map[string][]string)}
+}
+
+// categories copies the counts and examples for the summary. It returns nil for a
+// nil receiver, so callers need not check whether -errors-per-category is set.
+func (e *errorExamples) categories() map[string]*ErrorCategory {
+	if e == nil {
+		return nil
+	}
+	out := make(map[string]*ErrorCategory, len(e.counts))
+	for category, count := range e.counts {
+		out[category] = &ErrorCategory{Count: count, Examples: append([]string(nil), e.messages[category]...)}
+	}
+	return out
+}
+
+func (e *errorExamples) add(category, message string) {
+	e.counts[category]++
+	kept := e.messages[category]
+	if len(kept) >= e.limit {
+		return
+	}
+	for _, m := range kept {
+		if m == message {
+			return
+		}
+	}
+	e.messages[category] = append(kept, message)
+}
+
+// ErrorCategory counts the errors of one kind and gives examples of them.
+type ErrorCategory struct {
+	Count    int64    `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// ResetStats describes requests that failed because the server dropped the
+// connection, which often signals overload. Series counts them per second.
+type ResetStats struct {
+	Count  int64   `json:"count"`
+	Rate   float64 `json:"rate"`
+	Series []int   `json:"series"`
+}
+
+// ConcurrencyStats describes how many requests were actually in flight, which
+// can fall short of -concurrency when requests are paced or the client is the
+// bottleneck. Avg and Series come from the samples taken by sampleInFlight.
+type ConcurrencyStats struct {
+	Limit  int     `json:"limit"`
+	Avg    float64 `json:"avg"`
+	Max    int64   `json:"max"`
+	Series []int   `json:"series"` // average in flight for each second of the run
+}
+
+// CDFPoint is one point of the cumulative latency distribution: Fraction percent of
+// requests completed in Latency seconds or less.
+type CDFPoint struct {
+	Fraction float64 `json:"fraction"`
+	Latency  float64 `json:"latency"`
+}
+
+// cdfFractions are the percentile points reported in the summary's CDF section.
+var cdfFractions = []float64{10, 25, 50, 75, 90, 95, 99, 99.9, 100}
+
+// StreamStats describes how streamed response bodies were read with -stream-response.
+// In that mode the response time metrics measure time to first byte.
+type StreamStats struct {
+	BytesRead    int64   `json:"bytesRead"`
+	AvgReadTime  float64 `json:"avgReadTime"`
+	Percentile99 float64 `json:"percentile99ReadTime"`
+	MaxReadTime  float64 `json:"maxReadTime"`
+}
+
+// RunMetadata records settings that shaped the run.
+type RunMetadata struct {
+	TCPNoDelay   bool              `json:"tcpNoDelay"`
+	TCPKeepAlive string            `json:"tcpKeepAlive"`
+	LatencyMode  string            `json:"latencyMode"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// urlSchemePattern matches a URL that starts with a scheme, such as "http://".
+var urlSchemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// validateURL rejects target URLs that cannot be requested, so mistakes surface
+// before the run rather than as a summary full of identical errors.
+func validateURL(rawURL string, webSocket bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	allowed := map[string]bool{"http": true, "https": true}
+	if webSocket {
+		allowed = map[string]bool{"ws": true, "wss": true}
+	}
+	if !allowed[u.Scheme] {
+		return fmt.Errorf("unsupported scheme %q in %q", u.Scheme, rawURL)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("no host in %q", rawURL)
+	}
+	return nil
+}
+
+// defaultScheme picks the scheme for a URL given without one. Local targets
+// (localhost, loopback and private addresses) rarely serve TLS, so they get http;
+// anything else gets https.
+func defaultScheme(rawURL string) string {
+	host := rawURL
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return "http"
+	}
+	if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || ip.IsPrivate()) {
+		return "http"
+	}
+	return "https"
+}
+
+// expandOutputPath substitutes the {timestamp}, {target} and {concurrency}
+// placeholders in an -output path so that repeated runs write distinct files.
+func expandOutputPath(path string, cfg *Config, now time.Time) string {
+	target := "unknown"
+	if u, err := url.Parse(cfg.URL); err == nil && u.Host != "" {
+		target = unsafePathChars.ReplaceAllString(u.Host, "_")
+	}
+	return strings.NewReplacer(
+		"{timestamp}", now.Format("20060102T150405"),
+		"{target}", target,
+		"{concurrency}", strconv.Itoa(cfg.Concurrency),
+	).Replace(path)
+}
+
+// unsafePathChars matches characters kept out of file names built from a URL.
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// newRunMetadata describes the effective settings of a run.
+func newRunMetadata(cfg *Config) *RunMetadata {
+	keepAlive := cfg.TCPKeepAlive.String()
+	switch {
+	case cfg.TCPKeepAlive == 0:
+		keepAlive = "15s" // net.Dialer's default
+	case cfg.TCPKeepAlive < 0:
+		keepAlive = "disabled"
+	}
+	latencyMode := cfg.LatencyMode
+	if cfg.StreamResponse {
+		latencyMode = "ttfb"
+	}
+	return &RunMetadata{
+		TCPNoDelay:   cfg.TCPNoDelay,
+		TCPKeepAlive: keepAlive,
+		LatencyMode:  latencyMode,
+		Labels:       cfg.Labels.toMap(),
+	}
+}
+
+// RPSStats describes how request throughput varied from one second to the next.
+type RPSStats struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	StdDev float64 `json:"stdDev"`
+	// Percentiles of the per-second rates; a low P10 next to a steady average
+	// means some seconds fell well short.
+	P10    float64 `json:"p10"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	Series []int   `json:"series"`
+}
+
+// SLOBudget describes how much of the latency and error budgets a run has consumed.
+// Consumption is expressed as a percentage of the allowed budget and may exceed 100.
+type SLOBudget struct {
+	LatencyBreaches       int64   `json:"latencyBreaches"`
+	LatencyBreachRate     float64 `json:"latencyBreachRate"`
+	LatencyBudgetConsumed float64 `json:"latencyBudgetConsumed"`
+	ErrorBudgetConsumed   float64 `json:"errorBudgetConsumed"`
+	BudgetRemaining       float64 `json:"budgetRemaining"`
 }
 
 // customHeaders is a custom flag type for handling multiple header flags.
@@ -75,366 +755,3627 @@ func (h *customHeaders) Set(value string) error {
 	return nil
 }
 
+// loadHeadersFile reads 'Key: Value' headers from path, one per line. Blank lines
+// and lines starting with # are skipped, and ${VAR} or $VAR in values is replaced
+// from the environment so secrets need not be written to the file.
+func loadHeadersFile(path string) (customHeaders, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var headers customHeaders
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%s:%d: expected 'Key: Value', got %q", path, i+1, line)
+		}
+		headers = append(headers, parts[0]+":"+os.ExpandEnv(parts[1]))
+	}
+	return headers, nil
+}
+
+// userAgentPool hands out User-Agent strings from -user-agent-file, in order or at random.
+type userAgentPool struct {
+	agents []string
+	random bool
+	next   uint64
+}
+
+func (p *userAgentPool) pick() string {
+	if p.random {
+		return p.agents[mrand.Intn(len(p.agents))]
+	}
+	i := atomic.AddUint64(&p.next, 1) - 1
+	return p.agents[i%uint64(len(p.agents))]
+}
+
+// loadUserAgents reads one User-Agent per line from path, skipping blank lines
+// and # comments.
+func loadUserAgents(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var agents []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			agents = append(agents, line)
+		}
+	}
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("no user agents in %s", path)
+	}
+	return agents, nil
+}
+
+// trailerAssertion checks that a response trailer has an expected value.
+type trailerAssertion struct {
+	Name  string
+	Value string
+}
+
+// trailerAssertions is a custom flag type for handling multiple -assert-trailer flags.
+type trailerAssertions []trailerAssertion
+
+func (a *trailerAssertions) String() string {
+	parts := make([]string, len(*a))
+	for i, assertion := range *a {
+		parts[i] = assertion.Name + "=" + assertion.Value
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (a *trailerAssertions) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("expected format 'name=value', got %q", value)
+	}
+	*a = append(*a, trailerAssertion{Name: http.CanonicalHeaderKey(strings.TrimSpace(parts[0])), Value: strings.TrimSpace(parts[1])})
+	return nil
+}
+
+// runLabels is a custom flag type for handling multiple -label flags. Labels tag the
+// run in exported metrics and the summary metadata.
+type runLabels []runLabel
+
+// runLabel is one key=value pair given with -label.
+type runLabel struct {
+	Name  string
+	Value string
+}
+
+func (l *runLabels) String() string {
+	parts := make([]string, len(*l))
+	for i, label := range *l {
+		parts[i] = label.Name + "=" + label.Value
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (l *runLabels) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || !labelNamePattern.MatchString(parts[0]) {
+		return fmt.Errorf("expected format 'key=value' with key matching %s, got %q", labelNamePattern, value)
+	}
+	*l = append(*l, runLabel{Name: parts[0], Value: parts[1]})
+	return nil
+}
+
+// toMap returns the labels keyed by name; a repeated name keeps its last value.
+func (l runLabels) toMap() map[string]string {
+	if len(l) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(l))
+	for _, label := range l {
+		labels[label.Name] = label.Value
+	}
+	return labels
+}
+
+// labelNamePattern matches label names every metrics backend accepts.
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Config holds the options a load test is run with.
+type Config struct {
+	URL                  string
+	Requests             int
+	Concurrency          int
+	Duration             time.Duration
+	Method               string
+	Body                 string
+	BodyFile             string
+	BodiesDir            string
+	BodySize             string
+	BodyFill             string
+	BodyCommand          string
+	BodyCommandPerReq    bool
+	WebSocket            bool
+	WSMessage            string
+	WSInterval           time.Duration
+	RepeatBody           int
+	Chunked              bool
+	AbortOnP99           float64
+	AbortWindow          time.Duration
+	UntilCIWidth         float64
+	MaxRequests          int
+	MaxRuntime           time.Duration
+	MaxLatencySamples    int
+	ErrorsPerCategory    int
+	TopSlowest           int
+	DNSFailureThreshold  int
+	MaxAcceptableLatency float64
+	Connections          int
+	connLimit            *connLimit // enforces Connections across every host
+	LatencyMode          string
+	UserAgentFile        string
+	UserAgentRandom      bool
+	userAgents           *userAgentPool // loaded from UserAgentFile
+	BackendsFile         string
+	backends             *backendPool // loaded from BackendsFile
+	ReportInterval       time.Duration
+	ProgressBar          bool
+	RollingWindow        time.Duration
+	OutputFile           string
+	OpenMetrics          bool
+	openMetricsOut       *os.File // the real stdout when OpenMetrics is set
+	TimeseriesCSV        string
+	RawLatencies         string
+	RawLatenciesFormat   string
+	RequestsCSV          string
+	requestLog           *requestLog // opened from RequestsCSV
+	RequestIDHeader      string
+	InjectLatency        time.Duration
+	InjectJitter         time.Duration
+	PollUntilStatus      int
+	PollInterval         time.Duration
+	PollTimeout          time.Duration
+	JSONPathAsserts      jsonPathAssertions
+	ExpectSHA256         string
+	CPUProfile           string
+	MemProfile           string
+	Inspect              bool
+	SuccessExpr          successExpr
+	TrailerAsserts       trailerAssertions
+	Baseline             string
+	JUnitFile            string
+	AnalyzeCache         bool
+	Wizard               bool
+	CacheBust            bool
+	DumpOnError          string
+	DigestAuth           string
+	SplitByContentType   bool
+	WarmTLS              bool
+	ClampMaxLatency      float64
+	SoftDeadline         float64
+	RegressionPolicy     string
+	RegressionThreshold  float64
+	SummaryOnlyOnChange  bool
+	TCPNoDelay           bool
+	TCPKeepAlive         time.Duration
+	StreamResponse       bool
+	ReadBytes            int64
+	ReadDuration         time.Duration
+	Preflight            bool
+	Force                bool
+	PerWorkerRPS         float64
+	Labels               runLabels
+	LatencyUnit          latencyUnit
+	Headers              customHeaders
+	ChainHeaders         chainHeaders
+	InsecureHosts        insecureHosts
+	HeadersFile          string
+	NoAutoScheme         bool
+	DefaultScheme        string
+	URLsFile             string
+	SampleURLs           int
+	StreamURLs           bool
+	SLOP99               float64
+	SLOErrorRate         float64
+}
+
+// sloEnabled reports whether any SLO has been configured.
+func (c *Config) sloEnabled() bool {
+	return c.SLOP99 > 0 || c.SLOErrorRate > 0
+}
+
 var (
 	metrics          *Metrics
 	histogramBuckets = []float64{0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0}
 )
 
+// logError records err in the error log, tagged with the request ID if there is one.
+// The caller must hold m.Lock.
+func (m *Metrics) logError(category, requestID string, err error) {
+	if m.ErrorExamples != nil {
+		m.ErrorExamples.add(category, err.Error())
+	}
+	if len(m.ErrorLog) >= 100 {
+		return
+	}
+	errMsg := err.Error()
+	if requestID != "" {
+		errMsg = fmt.Sprintf("[%s] %s", requestID, errMsg)
+	}
+	m.ErrorLog = append(m.ErrorLog, errMsg)
+}
+
+// recordInterval adds a completed request to the per-second timeline.
+// The caller must hold m.Lock.
+func (m *Metrics) recordInterval(completedAt time.Time, responseTime float64, failed, reset bool) {
+	interval := m.intervalAt(completedAt)
+	interval.Requests++
+	interval.ResponseTimes = append(interval.ResponseTimes, responseTime)
+	if failed {
+		interval.Failures++
+	}
+	if reset {
+		interval.Resets++
+	}
+}
+
+// intervalAt returns the timeline interval covering t, extending the timeline if
+// needed. The caller must hold m.Lock.
+func (m *Metrics) intervalAt(t time.Time) *TimelineInterval {
+	second := int(t.Sub(m.StartTime) / time.Second)
+	for len(m.Timeline) <= second {
+		m.Timeline = append(m.Timeline, &TimelineInterval{Second: len(m.Timeline)})
+		// A -timeseries-csv row closes when the second after it starts; its
+		// percentiles are computed then, while its times are still kept.
+		if step := m.TimeseriesStep; step > 0 && (len(m.Timeline)-1)%step == 0 && len(m.Timeline) > 1 {
+			m.TimeseriesRows = append(m.TimeseriesRows, newTimingStats(intervalTimes(m.Timeline[len(m.Timeline)-1-step:len(m.Timeline)-1])))
+		}
+		// Only the rolling window and the open row need the times of recent
+		// intervals; drop them once an interval falls out of both.
+		if kept := m.TimesKept; len(m.Timeline) > kept {
+			m.Timeline[len(m.Timeline)-1-kept].ResponseTimes = nil
+		}
+	}
+	return m.Timeline[second]
+}
+
+// intervalTimes returns the response times recorded in the given intervals.
+func intervalTimes(intervals []*TimelineInterval) []float64 {
+	var times []float64
+	for _, interval := range intervals {
+		times = append(times, interval.ResponseTimes...)
+	}
+	return times
+}
+
+// timeseriesStep returns the seconds per -timeseries-csv row for a report
+// interval: the timeline is kept per second, so it is rounded up.
+func timeseriesStep(interval time.Duration) int {
+	step := int(math.Ceil(interval.Seconds()))
+	if step < 1 {
+		step = 1
+	}
+	return step
+}
+
+// WindowStats summarizes the requests that completed within a recent window.
+type WindowStats struct {
+	Requests  int
+	Failures  int
+	ErrorRate float64
+	P99       float64
+}
+
+// rollingWindow aggregates the timeline intervals from the last `seconds` seconds up
+// to and including the interval for `now`, so recent spikes are not diluted by the
+// lifetime totals. The caller must hold m.Lock.
+func (m *Metrics) rollingWindow(now time.Time, seconds int) WindowStats {
+	current := int(now.Sub(m.StartTime) / time.Second)
+	var stats WindowStats
+	var times []float64
+	for i := len(m.Timeline) - 1; i >= 0; i-- {
+		interval := m.Timeline[i]
+		if interval.Second <= current-seconds {
+			break
+		}
+		stats.Requests += interval.Requests
+		stats.Failures += interval.Failures
+		times = append(times, interval.ResponseTimes...)
+	}
+	if stats.Requests > 0 {
+		stats.ErrorRate = float64(stats.Failures) / float64(stats.Requests) * 100
+	}
+	sort.Float64s(times)
+	stats.P99 = percentile(times, 99)
+	return stats
+}
+
 func initializeMetrics() {
 	metrics = &Metrics{
 		StatusCodeCount: make(map[int]int),
 		ResponseTimes:   make([]float64, 0),
 		ErrorLog:        make([]string, 0),
+		BodyUsage:       make(map[string]int),
+		TrailerValues:   make(map[string]map[string]int),
+		ServerTiming:    make(map[string]*timingSample),
+		Backends:        make(map[string]*backendSamples),
+		Timeouts:        make(map[string]int64),
 		Histogram:       make([]*HistogramBucket, len(histogramBuckets)+1),
 	}
 	for i, mark := range histogramBuckets {
 		metrics.Histogram[i] = &HistogramBucket{Mark: mark}
 	}
-	metrics.Histogram[len(histogramBuckets)] = &HistogramBucket{Mark: math.Inf(1)}
+	metrics.Histogram[len(histogramBuckets)] = &HistogramBucket{Mark: math.Inf(1)}
+
+	// Disable colors on Windows
+	if runtime.GOOS == "windows" {
+		disableColors()
+	}
+}
+
+// disableColors clears the ANSI color codes, so output goes out as plain text.
+func disableColors() {
+	ColorReset = ""
+	ColorRed = ""
+	ColorGreen = ""
+	ColorYellow = ""
+	ColorCyan = ""
+}
+
+func main() {
+	initializeMetrics()
+
+	// --- Command-Line Flags ---
+	cfg := &Config{}
+	flag.StringVar(&cfg.URL, "url", "", "The target URL to test. (Required unless -urls-file is given)")
+	flag.StringVar(&cfg.URLsFile, "urls-file", "", "File of target URLs, one per line, to rotate through instead of -url.")
+	flag.IntVar(&cfg.SampleURLs, "sample-urls", 0, "With -urls-file, use a random sample of this many URLs from the file.")
+	flag.BoolVar(&cfg.StreamURLs, "stream-urls", false, "With -urls-file, read URLs from the file as they are needed instead of loading it into memory.")
+	flag.IntVar(&cfg.Requests, "requests", 0, "Total number of requests to send. Combined with -duration, the run stops at whichever limit is reached first.")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 10, "Number of concurrent requests to send.")
+	flag.DurationVar(&cfg.Duration, "duration", 0, "Duration of the test (e.g., '60s', '5m'). Combined with -requests, the run stops at whichever limit is reached first.")
+	flag.StringVar(&cfg.Method, "method", "GET", "HTTP method to use (e.g., GET, POST).")
+	flag.StringVar(&cfg.Body, "body", "", "Request body for POST, PUT, etc. Incompatible with -body-file.")
+	flag.StringVar(&cfg.BodyFile, "body-file", "", "Path to a file containing the request body. Incompatible with -body.")
+	flag.BoolVar(&cfg.WebSocket, "websocket", false, "Load test a WebSocket endpoint: hold -concurrency connections open for -duration instead of sending HTTP requests.")
+	flag.StringVar(&cfg.WSMessage, "ws-message", "", "With -websocket, message each connection sends every -ws-interval; the reply's round trip is timed.")
+	flag.DurationVar(&cfg.WSInterval, "ws-interval", time.Second, "With -websocket and -ws-message, how often each connection sends the message.")
+	flag.StringVar(&cfg.BodyCommand, "body-command", "", "Command whose stdout is used as the request body, split on whitespace and run without a shell. WARNING: runs an arbitrary program.")
+	flag.BoolVar(&cfg.BodyCommandPerReq, "body-command-per-request", false, "Run -body-command for every request instead of once at startup.")
+	flag.StringVar(&cfg.BodiesDir, "bodies-dir", "", "Directory of request body files to rotate through per request. Incompatible with -body and -body-file.")
+	flag.StringVar(&cfg.BodySize, "body-size", "", "Generate a request body of this size, e.g. 512, 1KB or 5MB (binary units: 1KB = 1024 bytes), at most 1GiB. Incompatible with the other body flags.")
+	flag.StringVar(&cfg.BodyFill, "body-fill", "random", "Content of the -body-size body: random or zero.")
+	flag.IntVar(&cfg.RepeatBody, "repeat-body", 1, "Number of times to concatenate the request body to form the payload that is sent.")
+	flag.BoolVar(&cfg.Chunked, "chunked-request", false, "Send the request body with chunked transfer encoding instead of a fixed Content-Length.")
+	flag.Float64Var(&cfg.AbortOnP99, "abort-on-p99", 0, "Abort the run when the p99 response time within -abort-window exceeds this many seconds.")
+	flag.StringVar(&cfg.BackendsFile, "backends-file", "", "File of backend ip:port addresses, one per line; requests to -url are sent to each in turn while keeping its Host header and TLS server name.")
+	flag.StringVar(&cfg.UserAgentFile, "user-agent-file", "", "File of User-Agent strings, one per line, to rotate through per request. -header 'User-Agent: ...' still takes precedence.")
+	flag.BoolVar(&cfg.UserAgentRandom, "user-agent-random", false, "With -user-agent-file, pick a random User-Agent for each request instead of rotating in order.")
+	flag.StringVar(&cfg.LatencyMode, "latency-mode", "headers", "What a request's latency measures: 'headers' (until the response headers arrive), 'ttfb' (until the first response byte) or 'total' (until the body is fully read).")
+	flag.IntVar(&cfg.Connections, "connections", 0, "Maximum number of connections open at once, across all hosts and backends, independent of -concurrency; with HTTP/2, requests are multiplexed over them (0 means no limit).")
+	flag.Float64Var(&cfg.MaxAcceptableLatency, "max-acceptable-latency", 0, "Count otherwise successful responses slower than this many seconds as too-slow failures.")
+	flag.IntVar(&cfg.DNSFailureThreshold, "dns-failure-threshold", 10, "Abort the run if this many requests fail DNS resolution before any request resolves the target (0 disables).")
+	flag.IntVar(&cfg.TopSlowest, "top-slowest", 0, "List the N slowest requests in the summary with their URL, status, latency and start time.")
+	flag.IntVar(&cfg.ErrorsPerCategory, "errors-per-category", 0, "Keep up to K distinct error messages for each kind of failure (timeouts, DNS, resets, assertions, HTTP statuses, ...) and report them by category, so rare errors are not crowded out.")
+	flag.IntVar(&cfg.MaxLatencySamples, "max-latency-samples", 0, "Bound latency memory to N samples: report exact percentiles over the most recent N response times, kept in a fixed-size ring, and estimate lifetime percentiles to within 1% with a streaming digest.")
+	flag.DurationVar(&cfg.MaxRuntime, "max-runtime", 0, "Hard cap on the whole run in any mode; in-flight requests are abandoned once it elapses (e.g., 5m).")
+	flag.Float64Var(&cfg.UntilCIWidth, "until-ci-width", 0, "Instead of a fixed count, keep sending requests until the 95% confidence interval of p99 is at most this many seconds wide, or -max-requests is reached.")
+	flag.IntVar(&cfg.MaxRequests, "max-requests", 100000, "With -until-ci-width, the most requests to send if the confidence interval never gets narrow enough.")
+	flag.DurationVar(&cfg.AbortWindow, "abort-window", 10*time.Second, "Window over which the p99 for -abort-on-p99 is evaluated.")
+	flag.BoolVar(&cfg.ProgressBar, "progress-bar", false, "With -requests, show a progress bar with percent complete and estimated time remaining instead of the spinner.")
+	flag.DurationVar(&cfg.ReportInterval, "report-interval", 0, "How often live metrics are printed (default 100ms on a terminal, 5s otherwise).")
+	flag.DurationVar(&cfg.RollingWindow, "rolling-window", 5*time.Second, "Window for the recent error rate and p99 shown in the live metrics.")
+	flag.StringVar(&cfg.OutputFile, "output", "", "Path to save the summary report as a JSON file. {timestamp}, {target} and {concurrency} in the path are replaced per run.")
+	flag.StringVar(&cfg.RequestsCSV, "requests-csv", "", "Path to write one CSV row per request with its wall-clock start and end (UTC), latency, status, error and -request-id-header ID, for lining up with server logs.")
+	flag.StringVar(&cfg.RawLatencies, "raw-latencies", "", "Path to write every recorded response time, sorted, in seconds, for offline analysis.")
+	flag.StringVar(&cfg.RawLatenciesFormat, "raw-latencies-format", "text", "Format of -raw-latencies: text (one value per line) or binary (little-endian float64 array).")
+	flag.StringVar(&cfg.TimeseriesCSV, "timeseries-csv", "", "Path to write throughput and latency percentiles per -report-interval (in whole seconds) as CSV for plotting.")
+	flag.BoolVar(&cfg.OpenMetrics, "openmetrics", false, "Print the summary in OpenMetrics text format to stdout when the run completes; the rest of the output goes to stderr.")
+	flag.StringVar(&cfg.RequestIDHeader, "request-id-header", "", "Header name (e.g., X-Request-ID) to carry a unique UUID per request; the ID is included in logged errors and the -requests-csv log.")
+	flag.DurationVar(&cfg.InjectLatency, "inject-latency", 0, "TESTING ONLY: add this much artificial delay to every measured request, to validate the tool itself.")
+	flag.DurationVar(&cfg.InjectJitter, "inject-jitter", 0, "TESTING ONLY: add up to this much random delay on top of -inject-latency.")
+	flag.IntVar(&cfg.PollUntilStatus, "poll-until-status", 0, "On a 202 response, poll its Location URL until this status is returned; latency covers the whole workflow.")
+	flag.DurationVar(&cfg.PollInterval, "poll-interval", time.Second, "Delay between polls of a Location URL when -poll-until-status is set.")
+	flag.DurationVar(&cfg.PollTimeout, "poll-timeout", 30*time.Second, "Give up polling a Location URL after this long; the request then counts as failed.")
+	flag.Var(&cfg.InsecureHosts, "insecure-host", "Host whose TLS certificate is not verified (can be specified multiple times); all other hosts are still verified.")
+	flag.Var(&cfg.ChainHeaders, "chain-header", "Header set from the worker's previous JSON response (can be specified multiple times). Format: 'Name=template', e.g. 'Authorization=Bearer {{.PrevBody.token}}'. Each worker sends its requests one after another, so the previous response is its own.")
+	flag.StringVar(&cfg.ExpectSHA256, "expect-sha256", "", "Hex SHA-256 every response body must have; a mismatch fails the request as an integrity failure. Reads each body in full.")
+	flag.Var(&cfg.JSONPathAsserts, "assert-jsonpath", "Assertion on the JSON response body (can be specified multiple times). Format: '$.path=expected'")
+	flag.StringVar(&cfg.CPUProfile, "cpuprofile", "", "Write a pprof CPU profile of the tool itself to this file.")
+	flag.StringVar(&cfg.MemProfile, "memprofile", "", "Write a pprof heap profile of the tool itself to this file when the run ends.")
+	flag.BoolVar(&cfg.Inspect, "inspect", false, "Send a single request and print the full exchange with a timing breakdown instead of running a load test.")
+	flag.Var(&cfg.SuccessExpr, "success-expr", "Boolean expression over status, latency (seconds) and body deciding which responses succeed, e.g. 'status == 200 && latency < 0.5'.")
+	flag.Var(&cfg.TrailerAsserts, "assert-trailer", "Assertion on a response trailer such as grpc-status (can be specified multiple times). Format: 'name=value'")
+	flag.BoolVar(&cfg.Wizard, "wizard", false, "Prompt for the URL, method, concurrency, duration or request count and headers instead of reading flags. Ignored if any other flag is given.")
+	flag.StringVar(&cfg.DigestAuth, "digest-auth", "", "Credentials for HTTP Digest authentication, answering the server's 401 challenge transparently. Format: 'user:password'")
+	flag.StringVar(&cfg.DumpOnError, "dump-on-error", "", "Directory to write the full request and response of each failed request to, one file per request (first 100 only).")
+	flag.BoolVar(&cfg.CacheBust, "cache-bust", false, "Append a unique _cb query parameter to every request URL so caches between the tool and the target cannot serve repeats.")
+	flag.Float64Var(&cfg.SoftDeadline, "soft-deadline", 0, "Cancel requests still running after this many seconds, freeing their slot; they are counted as cancelled-slow instead of as failures.")
+	flag.Float64Var(&cfg.ClampMaxLatency, "clamp-max-latency", 0, "Clamp response times above this many seconds to this ceiling in the percentiles, histogram and cumulative distribution; such outliers are counted and their true maximum reported separately. The average, maximum and SLO budget still use the true times.")
+	flag.BoolVar(&cfg.WarmTLS, "warm-tls", false, "Before the run, resolve the target's host and complete a TLS handshake so measured requests can resume the cached TLS session.")
+	flag.BoolVar(&cfg.SplitByContentType, "split-by-content-type", false, "Break response counts, latency and sizes down by response Content-Type.")
+	flag.BoolVar(&cfg.AnalyzeCache, "analyze-cache", false, "Summarize the responses' Cache-Control headers: how many had one, max-age values and no-store/no-cache/private counts.")
+	flag.StringVar(&cfg.JUnitFile, "junit", "", "Path to write a JUnit XML report in which each SLO, assertion and baseline check is a test case.")
+	flag.BoolVar(&cfg.SummaryOnlyOnChange, "summary-only-on-change", false, "With -baseline, print nothing unless the run regressed against it; then print the full output and exit non-zero. For monitoring from cron.")
+	flag.StringVar(&cfg.Baseline, "baseline", "", "Path to a JSON summary from an earlier run (see -output) to check this run against for regressions.")
+	flag.StringVar(&cfg.RegressionPolicy, "regression-policy", "", "JSON file of per-metric tolerances for -baseline, e.g. {\"p99\": 5, \"failureRate\": 0}; metrics not listed use -regression-threshold.")
+	flag.Float64Var(&cfg.RegressionThreshold, "regression-threshold", 10, "Percentage by which a metric may worsen relative to -baseline before it counts as a regression.")
+	flag.BoolVar(&cfg.TCPNoDelay, "tcp-nodelay", true, "Disable Nagle's algorithm on connections (use -tcp-nodelay=false to enable Nagle).")
+	flag.DurationVar(&cfg.TCPKeepAlive, "tcp-keepalive", 0, "TCP keep-alive probe interval for connections (0 uses Go's default of 15s, negative disables keep-alives).")
+	flag.BoolVar(&cfg.StreamResponse, "stream-response", false, "Treat responses as streams (e.g., SSE): record time to first byte as the latency and read the body separately.")
+	flag.Int64Var(&cfg.ReadBytes, "read-bytes", 0, "With -stream-response, stop reading a response after this many bytes.")
+	flag.DurationVar(&cfg.ReadDuration, "read-duration", 0, "With -stream-response, stop reading a response after this long.")
+	flag.BoolVar(&cfg.Preflight, "preflight", false, "Send one request before the load starts and abort if it does not succeed.")
+	flag.BoolVar(&cfg.Force, "force", false, "With -preflight, start the load test even if the preflight request fails.")
+	flag.Float64Var(&cfg.PerWorkerRPS, "per-worker-rps", 0, "Pace each of the -concurrency workers to at most this many requests per second, modelling users acting at a fixed rate.")
+	cfg.LatencyUnit = "s"
+	flag.Var(&cfg.LatencyUnit, "latency-unit", "Unit for latencies in the console output: s, ms, us or auto. JSON output always uses seconds.")
+	flag.Var(&cfg.Labels, "label", "Label attached to exported metrics and the JSON summary metadata (can be specified multiple times). Format: 'key=value'")
+	flag.BoolVar(&cfg.NoAutoScheme, "no-auto-scheme", false, "Reject a -url without a scheme instead of assuming one.")
+	flag.StringVar(&cfg.DefaultScheme, "default-scheme", "", "Scheme assumed for a -url without one: 'http' or 'https'. By default localhost and private or loopback IPs get http, other hosts https.")
+	flag.StringVar(&cfg.HeadersFile, "headers-file", "", "File of 'Key: Value' headers, one per line, with ${VAR} expanded from the environment. -header flags override its entries.")
+	flag.Var(&cfg.Headers, "header", "Custom header(s) to send with requests (can be specified multiple times). Format: 'Key:Value'")
+	flag.Float64Var(&cfg.SLOP99, "slo-p99", 0, "Latency SLO in seconds: 99% of requests should complete within this time.")
+	flag.Float64Var(&cfg.SLOErrorRate, "slo-error-rate", 0, "Error-rate SLO as a percentage of requests allowed to fail (e.g., 1 for 1%).")
+
+	flag.Parse()
+
+	if cfg.Wizard {
+		if flag.NFlag() > 1 {
+			fmt.Println("Ignoring -wizard because other flags were given.")
+		} else if err := runWizard(os.Stdin, os.Stdout, cfg); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// With -openmetrics, stdout carries only the OpenMetrics text so it can be
+	// piped straight into a parser; everything else is printed to stderr.
+	if cfg.OpenMetrics {
+		cfg.openMetricsOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
+	// Escape codes would only clutter log files and pipes.
+	if !isTerminal(os.Stdout) {
+		disableColors()
+	}
+
+	// --- Input Validation ---
+	if cfg.URL == "" && cfg.URLsFile == "" {
+		fmt.Println("Error: -url or -urls-file is required.")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if cfg.URL != "" && cfg.URLsFile != "" {
+		fmt.Println("Error: -url and -urls-file are mutually exclusive. Please choose one.")
+		os.Exit(1)
+	}
+	if cfg.URLsFile == "" && (cfg.SampleURLs != 0 || cfg.StreamURLs) {
+		fmt.Println("Error: -sample-urls and -stream-urls require -urls-file.")
+		os.Exit(1)
+	}
+	if cfg.SampleURLs < 0 {
+		fmt.Println("Error: -sample-urls must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.SampleURLs > 0 && cfg.StreamURLs {
+		fmt.Println("Error: -sample-urls and -stream-urls are mutually exclusive. Please choose one.")
+		os.Exit(1)
+	}
+	if cfg.URLsFile != "" && cfg.WebSocket {
+		fmt.Println("Error: -urls-file is not supported with -websocket.")
+		os.Exit(1)
+	}
+
+	if cfg.DefaultScheme != "" && cfg.DefaultScheme != "http" && cfg.DefaultScheme != "https" {
+		fmt.Println("Error: -default-scheme must be 'http' or 'https'.")
+		os.Exit(1)
+	}
+
+	if cfg.URL != "" {
+		// Prepend a scheme if none is provided; -websocket maps it to ws:// or wss:// below
+		if !urlSchemePattern.MatchString(cfg.URL) {
+			if cfg.NoAutoScheme {
+				schemes := "http:// or https://"
+				if cfg.WebSocket {
+					schemes = "ws:// or wss://"
+				}
+				fmt.Printf("Error: -url %q has no scheme. Add %s, or drop -no-auto-scheme.\n", cfg.URL, schemes)
+				os.Exit(1)
+			}
+			scheme := cfg.DefaultScheme
+			if scheme == "" {
+				scheme = defaultScheme(cfg.URL)
+			}
+			cfg.URL = scheme + "://" + cfg.URL
+		}
+		if cfg.WebSocket {
+			if strings.HasPrefix(cfg.URL, "http://") {
+				cfg.URL = "ws://" + strings.TrimPrefix(cfg.URL, "http://")
+			} else if strings.HasPrefix(cfg.URL, "https://") {
+				cfg.URL = "wss://" + strings.TrimPrefix(cfg.URL, "https://")
+			}
+		}
+		if err := validateURL(cfg.URL, cfg.WebSocket); err != nil {
+			fmt.Printf("Error: invalid -url: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.RawLatenciesFormat != "text" && cfg.RawLatenciesFormat != "binary" {
+		fmt.Println("Error: -raw-latencies-format must be text or binary.")
+		os.Exit(1)
+	}
+	if cfg.MaxLatencySamples > 0 && (cfg.RawLatencies != "" || cfg.UntilCIWidth > 0) {
+		fmt.Println("Error: -max-latency-samples does not keep every response time; -raw-latencies and -until-ci-width need all of them.")
+		os.Exit(1)
+	}
+	if cfg.UntilCIWidth < 0 {
+		fmt.Println("Error: -until-ci-width must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.UntilCIWidth > 0 {
+		if cfg.Requests > 0 || cfg.WebSocket {
+			fmt.Println("Error: -until-ci-width decides the request count itself; use -max-requests to cap it, and do not combine it with -requests or -websocket.")
+			os.Exit(1)
+		}
+		if cfg.MaxRequests < 1 {
+			fmt.Println("Error: -max-requests must be at least 1.")
+			os.Exit(1)
+		}
+		// The cap is run as a fixed request count that watchCIWidth may end early.
+		cfg.Requests = cfg.MaxRequests
+	}
+	if cfg.Requests == 0 && cfg.Duration == 0 && !cfg.Inspect {
+		fmt.Println("Error: Either -requests or -duration must be specified.")
+		os.Exit(1)
+	}
+	if cfg.WebSocket && cfg.Duration == 0 {
+		fmt.Println("Error: -websocket requires -duration.")
+		os.Exit(1)
+	}
+	if cfg.WebSocket && cfg.WSInterval <= 0 {
+		fmt.Println("Error: -ws-interval must be positive.")
+		os.Exit(1)
+	}
+	if cfg.SLOP99 < 0 {
+		fmt.Println("Error: -slo-p99 must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.SLOErrorRate < 0 || cfg.SLOErrorRate > 100 {
+		fmt.Println("Error: -slo-error-rate must be between 0 and 100.")
+		os.Exit(1)
+	}
+	if cfg.AbortOnP99 > 0 && cfg.AbortWindow <= 0 {
+		fmt.Println("Error: -abort-window must be positive.")
+		os.Exit(1)
+	}
+	switch cfg.LatencyMode {
+	case "headers", "ttfb", "total":
+	default:
+		fmt.Println("Error: -latency-mode must be headers, ttfb or total.")
+		os.Exit(1)
+	}
+	if cfg.StreamResponse && cfg.LatencyMode == "total" {
+		fmt.Println("Error: -latency-mode total cannot be used with -stream-response, which times the stream separately.")
+		os.Exit(1)
+	}
+	if cfg.Connections < 0 {
+		fmt.Println("Error: -connections must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.Connections > 0 {
+		cfg.connLimit = newConnLimit(cfg.Connections)
+	}
+	if cfg.MaxAcceptableLatency < 0 {
+		fmt.Println("Error: -max-acceptable-latency must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.DNSFailureThreshold < 0 {
+		fmt.Println("Error: -dns-failure-threshold must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.MaxRuntime < 0 {
+		fmt.Println("Error: -max-runtime must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.SoftDeadline < 0 {
+		fmt.Println("Error: -soft-deadline must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.ClampMaxLatency < 0 {
+		fmt.Println("Error: -clamp-max-latency must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.MaxLatencySamples < 0 {
+		fmt.Println("Error: -max-latency-samples must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.ErrorsPerCategory < 0 {
+		fmt.Println("Error: -errors-per-category must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.TopSlowest < 0 {
+		fmt.Println("Error: -top-slowest must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.AnalyzeCache {
+		metrics.Cache = newCacheStats()
+	}
+	if cfg.SplitByContentType {
+		metrics.ContentTypes = make(map[string]*contentTypeSamples)
+	}
+	if cfg.DigestAuth != "" && !strings.Contains(cfg.DigestAuth, ":") {
+		fmt.Println("Error: -digest-auth must be in the form 'user:password'.")
+		os.Exit(1)
+	}
+	if cfg.DumpOnError != "" {
+		if err := os.MkdirAll(cfg.DumpOnError, 0755); err != nil {
+			fmt.Printf("Error: cannot create -dump-on-error directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.MaxLatencySamples > 0 {
+		metrics.RecentTimes = newLatencyRing(cfg.MaxLatencySamples)
+		metrics.Digest = &latencyDigest{}
+	}
+	if cfg.ErrorsPerCategory > 0 {
+		metrics.ErrorExamples = newErrorExamples(cfg.ErrorsPerCategory)
+	}
+	if cfg.TopSlowest > 0 {
+		metrics.Slowest = newSlowestRequests(cfg.TopSlowest)
+	}
+	if cfg.InjectLatency < 0 || cfg.InjectJitter < 0 {
+		fmt.Println("Error: -inject-latency and -inject-jitter must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.InjectLatency > 0 || cfg.InjectJitter > 0 {
+		fmt.Printf("%sWarning: artificial latency injection is enabled; measured response times are NOT those of the target.%s\n", ColorYellow, ColorReset)
+	}
+	if len(cfg.InsecureHosts) > 0 {
+		fmt.Printf("%sWarning: TLS certificates are not verified for %s.%s\n", ColorYellow, cfg.InsecureHosts.String(), ColorReset)
+	}
+	if cfg.PollUntilStatus > 0 && (cfg.PollInterval <= 0 || cfg.PollTimeout <= 0) {
+		fmt.Println("Error: -poll-interval and -poll-timeout must be positive.")
+		os.Exit(1)
+	}
+	if cfg.RollingWindow < time.Second {
+		fmt.Println("Error: -rolling-window must be at least 1s.")
+		os.Exit(1)
+	}
+	metrics.TimesKept = int(math.Ceil(cfg.RollingWindow.Seconds()))
+	if cfg.TimeseriesCSV != "" {
+		metrics.TimeseriesStep = timeseriesStep(cfg.ReportInterval)
+		if metrics.TimesKept < metrics.TimeseriesStep {
+			metrics.TimesKept = metrics.TimeseriesStep
+		}
+	}
+	if (cfg.ReadBytes != 0 || cfg.ReadDuration != 0) && !cfg.StreamResponse {
+		fmt.Println("Error: -read-bytes and -read-duration require -stream-response.")
+		os.Exit(1)
+	}
+	if cfg.ReadBytes < 0 || cfg.ReadDuration < 0 {
+		fmt.Println("Error: -read-bytes and -read-duration must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.StreamResponse && (len(cfg.JSONPathAsserts) > 0 || len(cfg.TrailerAsserts) > 0 || cfg.SuccessExpr.usesBody()) {
+		fmt.Println("Error: -stream-response cannot be combined with body or trailer assertions.")
+		os.Exit(1)
+	}
+	if cfg.StreamResponse && len(cfg.ChainHeaders) > 0 {
+		fmt.Println("Error: -stream-response cannot be combined with -chain-header.")
+		os.Exit(1)
+	}
+	if cfg.ExpectSHA256 != "" {
+		if sum, err := hex.DecodeString(cfg.ExpectSHA256); err != nil || len(sum) != sha256.Size {
+			fmt.Println("Error: -expect-sha256 must be 64 hexadecimal digits.")
+			os.Exit(1)
+		}
+		cfg.ExpectSHA256 = strings.ToLower(cfg.ExpectSHA256)
+		if cfg.StreamResponse {
+			fmt.Println("Error: -stream-response cannot be combined with -expect-sha256.")
+			os.Exit(1)
+		}
+	}
+	if cfg.PerWorkerRPS < 0 {
+		fmt.Println("Error: -per-worker-rps must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.RegressionThreshold < 0 {
+		fmt.Println("Error: -regression-threshold must not be negative.")
+		os.Exit(1)
+	}
+	cfg.OutputFile = expandOutputPath(cfg.OutputFile, cfg, time.Now())
+	if cfg.HeadersFile != "" {
+		fileHeaders, err := loadHeadersFile(cfg.HeadersFile)
+		if err != nil {
+			fmt.Printf("Error reading headers file: %v\n", err)
+			os.Exit(1)
+		}
+		// Headers are applied in order, so -header flags listed last win.
+		cfg.Headers = append(fileHeaders, cfg.Headers...)
+	}
+	if cfg.UserAgentFile != "" {
+		agents, err := loadUserAgents(cfg.UserAgentFile)
+		if err != nil {
+			fmt.Printf("Error reading user agent file: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.userAgents = &userAgentPool{agents: agents, random: cfg.UserAgentRandom}
+	} else if cfg.UserAgentRandom {
+		fmt.Println("Error: -user-agent-random requires -user-agent-file.")
+		os.Exit(1)
+	}
+	if cfg.BackendsFile != "" {
+		if cfg.URL == "" || cfg.WebSocket {
+			fmt.Println("Error: -backends-file requires -url and is not supported with -websocket.")
+			os.Exit(1)
+		}
+		if cfg.WarmTLS {
+			fmt.Println("Error: -warm-tls is not supported with -backends-file.")
+			os.Exit(1)
+		}
+		addrs, err := loadBackends(cfg.BackendsFile)
+		if err != nil {
+			fmt.Printf("Error reading backends file: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.backends = &backendPool{addrs: addrs}
+	}
+	if cfg.RequestsCSV != "" && cfg.WebSocket {
+		fmt.Println("Error: -requests-csv is not supported with -websocket.")
+		os.Exit(1)
+	}
+	// Load the baseline up front so a bad path doesn't waste a whole run.
+	var baseline *Summary
+	if cfg.Baseline != "" {
+		var err error
+		if baseline, err = loadSummary(cfg.Baseline); err != nil {
+			fmt.Printf("Error loading baseline: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.SummaryOnlyOnChange && cfg.Baseline == "" {
+		fmt.Println("Error: -summary-only-on-change requires -baseline.")
+		os.Exit(1)
+	}
+	var policy regressionPolicy
+	if cfg.RegressionPolicy != "" {
+		if cfg.Baseline == "" {
+			fmt.Println("Error: -regression-policy requires -baseline.")
+			os.Exit(1)
+		}
+		var err error
+		if policy, err = loadPolicy(cfg.RegressionPolicy); err != nil {
+			fmt.Printf("Error loading regression policy: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.ReportInterval < 0 {
+		fmt.Println("Error: -report-interval must not be negative.")
+		os.Exit(1)
+	}
+	if cfg.ReportInterval == 0 {
+		cfg.ReportInterval = defaultReportInterval(isTerminal(os.Stdout))
+	}
+
+	// Exit with exitCode once every other deferred call, such as profile flushing,
+	// has run.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	// --- Profiling of the tool itself ---
+	// Deferred so profiles are flushed whenever main returns, including after an interrupt.
+	if cfg.CPUProfile != "" {
+		stopCPUProfile, err := startCPUProfile(cfg.CPUProfile)
+		if err != nil {
+			fmt.Printf("Error starting CPU profile: %v\n", err)
+			os.Exit(1)
+		}
+		defer stopCPUProfile()
+	}
+	if cfg.MemProfile != "" {
+		defer writeMemProfile(cfg.MemProfile)
+	}
+
+	// --- Setup Context for Graceful Shutdown ---
+	// runCtx is cancelled on interrupt or abort, which also abandons requests that are
+	// in flight. dispatchCtx additionally ends when -duration elapses; that only stops
+	// new requests from being sent, so requests started near the end still get their
+	// full per-request timeout instead of failing against the run deadline.
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dispatchCtx := runCtx
+	if cfg.Duration > 0 {
+		var stopDispatch context.CancelFunc
+		dispatchCtx, stopDispatch = context.WithTimeout(runCtx, cfg.Duration)
+		defer stopDispatch()
+	}
+	var stopAtCI context.CancelFunc
+	if cfg.UntilCIWidth > 0 {
+		dispatchCtx, stopAtCI = context.WithCancel(dispatchCtx)
+		defer stopAtCI()
+	}
+
+	// Listen for interrupt signals (Ctrl+C)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		fmt.Printf("\n%sInterrupt signal received. Shutting down gracefully...%s\n", ColorYellow, ColorReset)
+		cancel()
+	}()
+
+	if cfg.WebSocket {
+		printWebSocketSummary(runWebSocket(dispatchCtx, cfg), cfg)
+		return
+	}
+
+	// --- Test Execution ---
+	bodySources := 0
+	for _, set := range []bool{cfg.Body != "", cfg.BodyFile != "", cfg.BodiesDir != "", cfg.BodyCommand != "", cfg.BodySize != ""} {
+		if set {
+			bodySources++
+		}
+	}
+	if bodySources > 1 {
+		fmt.Println("Error: -body, -body-file, -bodies-dir, -body-command and -body-size are mutually exclusive. Please choose one.")
+		os.Exit(1)
+	}
+	if cfg.BodyCommandPerReq && cfg.BodyCommand == "" {
+		fmt.Println("Error: -body-command-per-request requires -body-command.")
+		os.Exit(1)
+	}
+	if cfg.BodyFill != "random" && cfg.BodyFill != "zero" {
+		fmt.Println("Error: -body-fill must be random or zero.")
+		os.Exit(1)
+	}
+	if cfg.RepeatBody < 1 {
+		fmt.Println("Error: -repeat-body must be at least 1.")
+		os.Exit(1)
+	}
+
+	var variants []bodyVariant
+	if cfg.BodyFile != "" {
+		bodyBytes, err := ioutil.ReadFile(cfg.BodyFile)
+		if err != nil {
+			fmt.Printf("Error reading body file: %v\n", err)
+			os.Exit(1)
+		}
+		variants = []bodyVariant{{Data: bodyBytes}}
+	} else if cfg.BodiesDir != "" {
+		var err error
+		variants, err = loadBodiesDir(cfg.BodiesDir)
+		if err != nil {
+			fmt.Printf("Error reading bodies directory: %v\n", err)
+			os.Exit(1)
+		}
+	} else if cfg.BodyCommand != "" {
+		command := strings.Fields(cfg.BodyCommand)
+		if cfg.BodyCommandPerReq {
+			variants = []bodyVariant{{Command: command}}
+		} else {
+			data, err := runBodyCommand(command)
+			if err != nil {
+				fmt.Printf("Error running body command: %v\n", err)
+				os.Exit(1)
+			}
+			variants = []bodyVariant{{Data: data}}
+		}
+	} else if cfg.BodySize != "" {
+		size, err := parseByteSize(cfg.BodySize)
+		if err != nil {
+			fmt.Printf("Error: invalid -body-size: %v\n", err)
+			os.Exit(1)
+		}
+		if size > maxBodySize {
+			fmt.Println("Error: -body-size must be at most 1GiB; the body is held in memory.")
+			os.Exit(1)
+		}
+		data := make([]byte, size)
+		if cfg.BodyFill == "random" {
+			rand.Read(data)
+		}
+		variants = []bodyVariant{{Data: data}}
+	} else {
+		variants = []bodyVariant{{Data: []byte(cfg.Body)}}
+	}
+	for i := range variants {
+		variants[i].Data = bytes.Repeat(variants[i].Data, cfg.RepeatBody)
+	}
+	bodies := &bodyPool{variants: variants}
+
+	var targets targetSource = &urlPool{urls: []string{cfg.URL}}
+	if cfg.StreamURLs {
+		stream, err := openURLStream(cfg.URLsFile)
+		if err != nil {
+			fmt.Printf("Error reading URLs file: %v\n", err)
+			os.Exit(1)
+		}
+		defer stream.file.Close()
+		targets = stream
+	} else if cfg.URLsFile != "" {
+		urls, err := loadURLs(cfg.URLsFile, cfg.SampleURLs)
+		if err != nil {
+			fmt.Printf("Error reading URLs file: %v\n", err)
+			os.Exit(1)
+		}
+		targets = &urlPool{urls: urls}
+	}
+
+	baseTransport := newTransport(cfg, "")
+	var transport http.RoundTripper = baseTransport
+	if cfg.backends != nil {
+		transport = newBackendTransport(cfg, cfg.backends)
+	}
+	if cfg.DigestAuth != "" {
+		username, password, _ := strings.Cut(cfg.DigestAuth, ":")
+		transport = &digestTransport{base: transport, username: username, password: password}
+	}
+	client := &http.Client{
+		Timeout:   60 * time.Second,
+		Transport: transport,
+	}
+
+	if cfg.Inspect {
+		if err := inspectRequest(os.Stdout, client, cfg, targets, bodies.pick()); err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.WarmTLS {
+		target := cfg.URL
+		if target == "" {
+			var err error
+			if target, err = targets.pick(); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := warmUp(runCtx, target, baseTransport.TLSClientConfig, cfg.InsecureHosts); err != nil {
+			fmt.Printf("%sWarm-up failed: %v%s\n", ColorYellow, err, ColorReset)
+		}
+	}
+
+	if cfg.Preflight && !runPreflight(client, cfg, targets, bodies.pick()) {
+		if !cfg.Force {
+			fmt.Printf("%sAborting: the preflight request failed, so the load test was not started (use -force to run anyway).%s\n", ColorRed, ColorReset)
+			exitCode = 1
+			return
+		}
+		fmt.Printf("%sPreflight failed; continuing because -force was given.%s\n", ColorYellow, ColorReset)
+	}
+
+	if cfg.RequestsCSV != "" {
+		log, err := openRequestLog(cfg.RequestsCSV)
+		if err != nil {
+			fmt.Printf("Error creating requests CSV: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.requestLog = log
+	}
+
+	// Everything printed from here on is held back until the run is compared
+	// with the baseline.
+	var releaseOutput func(replay bool)
+	if cfg.SummaryOnlyOnChange {
+		var err error
+		if releaseOutput, err = captureOutput(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	startTime := time.Now()
+	metrics.StartTime = startTime
+	if cfg.MaxRuntime > 0 {
+		// Unlike -duration, the cap also cuts short requests that are still in flight.
+		maxRuntime := time.AfterFunc(cfg.MaxRuntime, func() {
+			reason := fmt.Sprintf("max runtime of %s reached", cfg.MaxRuntime)
+			metrics.Lock.Lock()
+			metrics.AbortReason = reason
+			metrics.Lock.Unlock()
+			fmt.Printf("\n%sStopping: %s.%s\n", ColorYellow, reason, ColorReset)
+			cancel()
+		})
+		defer maxRuntime.Stop()
+	}
+	var wg sync.WaitGroup
+	// Each slot is one unit of concurrency; a request must hold a slot while in flight.
+	slots := make(chan *workerSlot, cfg.Concurrency)
+	for i := 0; i < cfg.Concurrency; i++ {
+		slots <- &workerSlot{}
+	}
+	var paceInterval time.Duration
+	if cfg.PerWorkerRPS > 0 {
+		paceInterval = time.Duration(float64(time.Second) / cfg.PerWorkerRPS)
+	}
+
+	interim := make(chan os.Signal, 1)
+	notifyInterimSummary(interim)
+	notices := make(chan string, 1)
+	if !cfg.SummaryOnlyOnChange {
+		go printLiveMetrics(dispatchCtx, startTime, cfg, interim, notices)
+	} else {
+		// There is no live line, but SIGUSR1 still gets its interim summary,
+		// held back with the rest of the output.
+		go func() {
+			for {
+				select {
+				case <-dispatchCtx.Done():
+					return
+				case <-interim:
+					printInterimSummary(startTime, cfg)
+				}
+			}
+		}()
+	}
+	go sampleInFlight(dispatchCtx)
+	if cfg.AbortOnP99 > 0 {
+		go watchTailLatency(dispatchCtx, cfg, cancel)
+	}
+	if cfg.UntilCIWidth > 0 {
+		go watchCIWidth(dispatchCtx, cfg, stopAtCI, notices)
+	}
+	if cfg.DNSFailureThreshold > 0 {
+		go watchDNSFailures(dispatchCtx, cfg, cancel)
+	}
+
+	worker := func(slot *workerSlot, queued time.Duration) {
+		defer wg.Done()
+		defer func() { slots <- slot }()
+		metrics.Lock.Lock()
+		metrics.QueueTimes.add(queued.Seconds())
+		metrics.Lock.Unlock()
+		if !slot.pace(dispatchCtx, paceInterval) {
+			return
+		}
+		inFlight := atomic.AddInt64(&metrics.InFlight, 1)
+		for peak := atomic.LoadInt64(&metrics.PeakInFlight); inFlight > peak; peak = atomic.LoadInt64(&metrics.PeakInFlight) {
+			if atomic.CompareAndSwapInt64(&metrics.PeakInFlight, peak, inFlight) {
+				break
+			}
+		}
+		sendRequest(runCtx, client, cfg, slot, targets, bodies.pick())
+		atomic.AddInt64(&metrics.InFlight, -1)
+	}
+
+	// When both -requests and -duration are set, the duration's context deadline
+	// cuts the fixed-count loop short if it is reached first.
+	if cfg.Requests > 0 { // Fixed number of requests
+	dispatch:
+		for i := 0; i < cfg.Requests; i++ {
+			select {
+			case <-dispatchCtx.Done():
+				break dispatch
+			default:
+				wg.Add(1)
+				queuedAt := time.Now()
+				slot := <-slots
+				go worker(slot, time.Since(queuedAt))
+			}
+		}
+	} else { // Duration-based test
+	run:
+		for {
+			select {
+			case <-dispatchCtx.Done():
+				break run
+			default:
+				wg.Add(1)
+				queuedAt := time.Now()
+				slot := <-slots
+				go worker(slot, time.Since(queuedAt))
+			}
+		}
+	}
+
+	wg.Wait()
+	if cfg.requestLog != nil {
+		if err := cfg.requestLog.Close(); err != nil {
+			fmt.Printf("\nError writing requests CSV '%s': %v\n", cfg.RequestsCSV, err)
+		}
+	}
+	summary := printSummary(startTime, cfg)
+	if cfg.RawLatencies != "" && summary != nil {
+		if err := writeRawLatencies(cfg.RawLatencies, cfg.RawLatenciesFormat == "binary"); err != nil {
+			fmt.Printf("\nError writing raw latencies to '%s': %v\n", cfg.RawLatencies, err)
+		} else {
+			fmt.Printf("\nRaw latencies saved to %s\n", cfg.RawLatencies)
+		}
+	}
+	if cfg.TimeseriesCSV != "" && summary != nil {
+		if err := writeTimeseriesCSV(cfg.TimeseriesCSV); err != nil {
+			fmt.Printf("\nError writing time series to '%s': %v\n", cfg.TimeseriesCSV, err)
+		} else {
+			fmt.Printf("\nTime series saved to %s\n", cfg.TimeseriesCSV)
+		}
+	}
+
+	var comparisons []Comparison
+	if baseline != nil && summary != nil {
+		comparisons = compareSummaries(baseline, summary, cfg.RegressionThreshold, policy)
+		printComparisons(cfg.Baseline, comparisons)
+		if len(regressions(comparisons)) > 0 {
+			exitCode = exitCodeRegression
+		}
+	}
+	if cfg.JUnitFile != "" && summary != nil {
+		if err := writeJUnit(cfg.JUnitFile, summary, cfg, comparisons); err != nil {
+			fmt.Printf("\nError writing JUnit report to '%s': %v\n", cfg.JUnitFile, err)
+		} else {
+			fmt.Printf("\nJUnit report saved to %s\n", cfg.JUnitFile)
+		}
+	}
+	if releaseOutput != nil {
+		releaseOutput(exitCode != 0 || summary == nil)
+	}
+}
+
+// captureOutput sends everything printed to stdout to a temporary file until the
+// returned function is called. That restores stdout and, if replay is true,
+// prints what was captured.
+func captureOutput() (func(replay bool), error) {
+	file, err := os.CreateTemp("", "httptest-output-*")
+	if err != nil {
+		return nil, fmt.Errorf("cannot hold back output: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = file
+	return func(replay bool) {
+		os.Stdout = stdout
+		if replay {
+			file.Seek(0, io.SeekStart)
+			io.Copy(stdout, file)
+		}
+		file.Close()
+		os.Remove(file.Name())
+	}, nil
+}
+
+// bodyVariant is one request body a run can send. Name identifies bodies loaded
+// from -bodies-dir and is empty otherwise. Data is kept as raw bytes so binary
+// payloads such as protobuf are sent exactly as read. When Command is set, the
+// body is instead generated by running it for each request.
+type bodyVariant struct {
+	Name    string
+	Data    []byte
+	Command []string
+}
+
+// runBodyCommand runs a -body-command and returns its stdout. A failing command's
+// stderr is included in the error.
+func runBodyCommand(command []string) ([]byte, error) {
+	out, err := exec.Command(command[0], command[1:]...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// bodyPool hands out request bodies, rotating through its variants per request.
+type bodyPool struct {
+	variants []bodyVariant
+	next     uint64
+}
+
+func (p *bodyPool) pick() bodyVariant {
+	i := atomic.AddUint64(&p.next, 1) - 1
+	return p.variants[i%uint64(len(p.variants))]
+}
+
+// loadBodiesDir reads every regular file in dir, in name order, as a body variant.
+func loadBodiesDir(dir string) ([]bodyVariant, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var variants []bodyVariant
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, bodyVariant{Name: entry.Name(), Data: data})
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("no files found in %s", dir)
+	}
+	return variants, nil
+}
+
+// timingSampleSize bounds the values a timingSample keeps for percentiles.
+const timingSampleSize = 10000
+
+// timingSample summarizes durations recorded for every request in bounded
+// memory. The count, sum and max are exact; the percentiles come from a uniform
+// sample of at most timingSampleSize values, kept by reservoir sampling. The
+// zero value is ready to use.
+type timingSample struct {
+	count  int64
+	sum    float64
+	sumSq  float64
+	max    float64
+	sample []float64
+}
+
+func (s *timingSample) add(v float64) {
+	s.count++
+	s.sum += v
+	s.sumSq += v * v
+	if v > s.max {
+		s.max = v
+	}
+	if len(s.sample) < timingSampleSize {
+		s.sample = append(s.sample, v)
+	} else if j := mrand.Int63n(s.count); j < timingSampleSize {
+		s.sample[j] = v
+	}
+}
+
+func (s *timingSample) avg() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
+
+// stdDev returns the population standard deviation, as the stdDev function does.
+func (s *timingSample) stdDev() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	mean := s.avg()
+	return math.Sqrt(math.Max(0, s.sumSq/float64(s.count)-mean*mean))
+}
+
+// sorted returns a sorted copy of the sample, for percentiles.
+func (s *timingSample) sorted() []float64 {
+	sorted := append([]float64(nil), s.sample...)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+func (s *timingSample) stats() *TimingStats {
+	sorted := s.sorted()
+	return &TimingStats{
+		Avg: s.avg(),
+		P50: percentile(sorted, 50),
+		P90: percentile(sorted, 90),
+		P99: percentile(sorted, 99),
+		Max: s.max,
+	}
+}
+
+// workerSlot is one unit of concurrency. Slots are handed from request to request,
+// so each models one virtual user and carries that user's pacing state.
+type workerSlot struct {
+	nextSend time.Time
+	// chained holds the -chain-header values rendered from this slot's latest
+	// response. A value is kept until a later response renders it again, so a
+	// token from a login response carries over requests that do not return one.
+	chained map[string]string
+}
+
+// pace waits until the slot may send its next request at one request per interval,
+// and reports false if ctx ends first. A zero interval does not pace at all.
+func (w *workerSlot) pace(ctx context.Context, interval time.Duration) bool {
+	if interval <= 0 {
+		return true
+	}
+	now := time.Now()
+	if wait := w.nextSend.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
+		// Schedule from the planned send time so the rate doesn't drift.
+		now = w.nextSend
+	}
+	w.nextSend = now.Add(interval)
+	return true
+}
+
+// runPreflight sends a single request outside of the measured run, prints what came
+// back and reports whether it met the success criteria.
+func runPreflight(client *http.Client, cfg *Config, targets targetSource, body bodyVariant) bool {
+	req, _, err := newRequest(context.Background(), cfg, targets, body)
+	if err != nil {
+		fmt.Printf("%sPreflight:%s %serror creating request: %v%s\n", ColorYellow, ColorReset, ColorRed, err, ColorReset)
+		return false
+	}
+	fmt.Printf("%sPreflight:%s %s %s\n", ColorYellow, ColorReset, cfg.Method, req.URL)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("  %s%v%s\n", ColorRed, err, ColorReset)
+		return false
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	latency := time.Since(start).Seconds()
+	if err != nil {
+		fmt.Printf("  %serror reading response body: %v%s\n", ColorRed, err, ColorReset)
+		return false
+	}
+
+	success, reason := classifyResponse(cfg, resp, respBody, latency)
+	color := ColorGreen
+	if !success {
+		color = ColorRed
+	}
+	fmt.Printf("  Status: %s%s%s, Time: %.4fs, Content-Type: %q, Body: %d bytes\n", color, resp.Status, ColorReset, latency, resp.Header.Get("Content-Type"), len(respBody))
+	if success {
+		return true
+	}
+	if reason != nil {
+		fmt.Printf("  %sReason: %v%s\n", ColorRed, reason, ColorReset)
+	}
+	if len(respBody) > 0 {
+		snippet := respBody
+		if len(snippet) > 200 {
+			snippet = snippet[:200]
+		}
+		fmt.Printf("  Body: %s\n", snippet)
+	}
+	return false
+}
+
+// readStream reads a streaming response body until it ends, maxBytes have been read
+// or maxDuration has passed, whichever comes first, and returns the bytes read.
+// Zero limits are ignored. abandon must cancel the request the body belongs to.
+func readStream(body io.Reader, maxBytes int64, maxDuration time.Duration, abandon context.CancelFunc) (int64, error) {
+	var reader io.Reader = body
+	if maxBytes > 0 {
+		reader = io.LimitReader(body, maxBytes)
+	}
+	var expired atomic.Bool
+	if maxDuration > 0 {
+		// Cancelling the request is what interrupts a blocked read.
+		timer := time.AfterFunc(maxDuration, func() {
+			expired.Store(true)
+			abandon()
+		})
+		defer timer.Stop()
+	}
+	n, err := io.Copy(io.Discard, reader)
+	if err != nil && !expired.Load() {
+		return n, fmt.Errorf("reading response stream: %w", err)
+	}
+	return n, nil
+}
+
+// newTransport returns an HTTP transport whose connections are dialed with the
+// configured TCP options. A non-empty dialAddr replaces the address of every
+// connection it opens, for -backends-file.
+func newTransport(cfg *Config, dialAddr string) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: cfg.TCPKeepAlive,
+	}
+	// KeepAlive alone only sets the idle time before the first probe; the
+	// configuration also sets the interval between probes to match.
+	if cfg.TCPKeepAlive > 0 {
+		dialer.KeepAliveConfig = net.KeepAliveConfig{Enable: true, Idle: cfg.TCPKeepAlive, Interval: cfg.TCPKeepAlive, Count: -1}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if dialAddr != "" {
+			addr = dialAddr
+		}
+		if err := cfg.connLimit.acquire(ctx); err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			cfg.connLimit.release()
+			return nil, err
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			if err := tcpConn.SetNoDelay(cfg.TCPNoDelay); err != nil {
+				conn.Close()
+				cfg.connLimit.release()
+				return nil, err
+			}
+		}
+		metrics.Lock.Lock()
+		metrics.OpenConns++
+		if metrics.OpenConns > metrics.PeakConns {
+			metrics.PeakConns = metrics.OpenConns
+		}
+		metrics.Lock.Unlock()
+		return &trackedConn{Conn: conn, limit: cfg.connLimit}, nil
+	}
+	if cfg.WarmTLS {
+		transport.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	}
+	if len(cfg.InsecureHosts) > 0 {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		// Connections made through a proxy still use this configuration.
+		cfg.InsecureHosts.apply(transport.TLSClientConfig, "")
+		transport.DialTLSContext = cfg.InsecureHosts.dialTLS(transport)
+	}
+	if cfg.Connections > 0 {
+		transport.MaxConnsPerHost = cfg.Connections
+		transport.MaxIdleConnsPerHost = cfg.Connections
+		cfg.connLimit.register(transport)
+	}
+	return transport
+}
+
+// trackedConn keeps metrics.OpenConns up to date as connections close, and
+// returns the connection's -connections slot.
+type trackedConn struct {
+	net.Conn
+	limit     *connLimit
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		metrics.Lock.Lock()
+		metrics.OpenConns--
+		metrics.Lock.Unlock()
+		c.limit.release()
+	})
+	return c.Conn.Close()
+}
+
+// connLimit caps the connections open at once for -connections, counted across
+// every host and backend; the transport's MaxConnsPerHost only caps each host on
+// its own. A nil *connLimit imposes no limit.
+type connLimit struct {
+	slots      chan struct{}
+	mu         sync.Mutex
+	transports []*http.Transport
+}
+
+func newConnLimit(n int) *connLimit {
+	return &connLimit{slots: make(chan struct{}, n)}
+}
+
+// register adds a transport whose idle connections acquire may close.
+func (l *connLimit) register(t *http.Transport) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.transports = append(l.transports, t)
+}
+
+// connLimitRetry is how often acquire, while waiting for a slot, closes the idle
+// connections that may be holding one.
+const connLimitRetry = 10 * time.Millisecond
+
+// acquire takes a slot for a new connection, waiting for one to be released if
+// all are in use. A connection returned to another host's idle pool would keep
+// its slot for as long as that host goes on reusing it, so while waiting,
+// acquire keeps closing idle connections.
+func (l *connLimit) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		select {
+		case l.slots <- struct{}{}:
+			return nil
+		default:
+		}
+		l.mu.Lock()
+		for _, t := range l.transports {
+			t.CloseIdleConnections()
+		}
+		l.mu.Unlock()
+		select {
+		case l.slots <- struct{}{}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(connLimitRetry):
+		}
+	}
+}
+
+func (l *connLimit) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// newRequest builds a request for the configured target with the given body and
+// headers. It also returns the generated request ID, if -request-id-header is set.
+func newRequest(ctx context.Context, cfg *Config, targets targetSource, body bodyVariant) (*http.Request, string, error) {
+	target, err := targets.pick()
+	if err != nil {
+		return nil, "", err
+	}
+	data := body.Data
+	if body.Command != nil {
+		out, err := runBodyCommand(body.Command)
+		if err != nil {
+			return nil, "", fmt.Errorf("body command: %w", err)
+		}
+		data = bytes.Repeat(out, cfg.RepeatBody)
+	}
+	// Each request gets its own reader over the shared bytes, which net/http uses
+	// to set Content-Length and to rewind the body on redirects. An empty body is
+	// http.NoBody: a GET then carries no Content-Length and -chunked-request sends
+	// no empty chunked stream, while POST and PUT get Content-Length: 0.
+	var bodyReader io.Reader = http.NoBody
+	if len(data) > 0 {
+		bodyReader = bytes.NewReader(data)
+	}
+	if cfg.Chunked && len(data) > 0 {
+		// Hiding the reader's length stops net/http from setting Content-Length,
+		// so the transport falls back to chunked transfer encoding.
+		bodyReader = struct{ io.Reader }{bodyReader}
+	}
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, target, bodyReader)
+	if err != nil {
+		return nil, "", err
+	}
+	if req.GetBody == nil && len(data) > 0 {
+		// Lets a chunked body be replayed too, on redirects and by -dump-on-error.
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+
+	if cfg.CacheBust {
+		bustCache(req.URL)
+	}
+	applyHeaders(req.Header, cfg)
+
+	var requestID string
+	if cfg.RequestIDHeader != "" {
+		requestID = newUUID()
+		req.Header.Set(cfg.RequestIDHeader, requestID)
+	}
+	return req, requestID, nil
+}
+
+// cacheBustCounter numbers the requests sent with -cache-bust, and cacheBustRun
+// is a random prefix for this run, so a cache that kept responses from an
+// earlier run cannot match the same numbers again.
+var (
+	cacheBustCounter uint64
+	cacheBustRun     = newUUID()[:8]
+)
+
+// bustCache appends a _cb query parameter unique to this request, leaving any
+// existing query string as it was, so caches between the tool and the target
+// cannot serve a stored response.
+func bustCache(u *url.URL) {
+	param := "_cb=" + cacheBustRun + "-" + strconv.FormatUint(atomic.AddUint64(&cacheBustCounter, 1), 10)
+	if u.RawQuery == "" {
+		u.RawQuery = param
+	} else {
+		u.RawQuery += "&" + param
+	}
+}
+
+func sendRequest(ctx context.Context, client *http.Client, cfg *Config, slot *workerSlot, targets targetSource, body bodyVariant) {
+	reqCtx, cancelRequest := context.WithCancel(ctx)
+	defer cancelRequest()
+	if cfg.SoftDeadline > 0 {
+		var cancelSlow context.CancelFunc
+		reqCtx, cancelSlow = context.WithTimeoutCause(reqCtx, time.Duration(cfg.SoftDeadline*float64(time.Second)), errSoftDeadline)
+		defer cancelSlow()
+	}
+	var backend string
+	if cfg.backends != nil {
+		reqCtx, backend = withBackend(reqCtx, cfg.backends)
+	}
+	timings := &requestTimings{}
+	// Building is timed apart from the response time so that client-side work, such
+	// as running -body-command, shows up as overhead rather than as server latency.
+	buildStart := time.Now()
+	req, requestID, err := newRequest(httptrace.WithClientTrace(reqCtx, timings.trace()), cfg, targets, body)
+	buildTime := time.Since(buildStart).Seconds()
+	if err != nil {
+		metrics.Lock.Lock()
+		metrics.FailureCount++
+		metrics.logError("request-build", "", fmt.Errorf("error creating request: %w", err))
+		// Count the failure in the timeline, but with no response time to record.
+		interval := metrics.intervalAt(time.Now())
+		interval.Requests++
+		interval.Failures++
+		metrics.Lock.Unlock()
+		return
+	}
+	for name, value := range slot.chained {
+		req.Header.Set(name, value)
+	}
+
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	var polls int
+	var pollTimedOut bool
+	if err == nil && cfg.PollUntilStatus > 0 && resp.StatusCode == http.StatusAccepted {
+		resp, polls, err = pollLocation(req.Context(), client, cfg, resp)
+		pollTimedOut = errors.Is(err, errPollTimeout)
+	}
+	injectLatency(ctx, cfg)
+	elapsedTime := time.Since(startTime).Seconds()
+
+	var success bool
+	var classifyErr error
+	var streamed int64
+	var streamTime float64
+	var received int64
+	var respBody []byte
+	if err == nil {
+		defer resp.Body.Close()
+		body := &countingReader{r: resp.Body}
+		if cfg.StreamResponse {
+			// For streams the body may never finish, so the time to first byte is
+			// the latency that matters; the read is timed on its own.
+			if ttfb := phase(startTime, timings.firstByte); ttfb > 0 {
+				elapsedTime = ttfb.Seconds()
+			}
+			streamStart := time.Now()
+			streamed, classifyErr = readStream(body, cfg.ReadBytes, cfg.ReadDuration, cancelRequest)
+			streamTime = time.Since(streamStart).Seconds()
+		}
+		// Trailers only arrive once the body has been read to the end. With
+		// -dump-on-error the body is kept in case the request fails.
+		needBody := len(cfg.JSONPathAsserts) > 0 || len(cfg.TrailerAsserts) > 0 || cfg.SuccessExpr.usesBody() || cfg.DumpOnError != "" || len(cfg.ChainHeaders) > 0 || cfg.ExpectSHA256 != ""
+		if classifyErr == nil && needBody {
+			respBody, classifyErr = io.ReadAll(body)
+		}
+		switch cfg.LatencyMode {
+		case "ttfb":
+			if ttfb := phase(startTime, timings.firstByte); ttfb > 0 {
+				elapsedTime = ttfb.Seconds()
+			}
+		case "total":
+			if classifyErr == nil && !needBody {
+				_, classifyErr = io.Copy(io.Discard, body)
+			}
+			elapsedTime = time.Since(startTime).Seconds()
+		}
+		if classifyErr == nil {
+			success, classifyErr = classifyResponse(cfg, resp, respBody, elapsedTime)
+		}
+		// Drain what is left so the connection can be reused. Streams may never end,
+		// so they are only closed.
+		if !cfg.StreamResponse {
+			io.Copy(io.Discard, body)
+		}
+		received = body.n
+		if len(cfg.ChainHeaders) > 0 && respBody != nil {
+			for name, value := range cfg.ChainHeaders.chainValues(respBody) {
+				if slot.chained == nil {
+					slot.chained = make(map[string]string)
+				}
+				slot.chained[name] = value
+			}
+		}
+	}
+
+	endTime := time.Now()
+	if cfg.requestLog != nil {
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		failure := err
+		if failure == nil {
+			failure = classifyErr
+		}
+		cfg.requestLog.record(startTime, endTime, elapsedTime, status, success, failure, requestID)
+	}
+
+	// A request cut off by -soft-deadline was slow, not broken, so it is only counted.
+	if (err != nil || classifyErr != nil) && errors.Is(context.Cause(reqCtx), errSoftDeadline) {
+		metrics.Lock.Lock()
+		metrics.CancelledSlow++
+		metrics.Lock.Unlock()
+		return
+	}
+
+	if cfg.DumpOnError != "" && !success {
+		dumpExchange(cfg.DumpOnError, req, resp, respBody, err, classifyErr)
+	}
+	ttfb := phase(startTime, timings.firstByte).Seconds()
+
+	metrics.Lock.Lock()
+	defer metrics.Lock.Unlock()
+
+	// Most requests are not among the slowest, so the check comes before the
+	// URL and error are formatted.
+	if metrics.Slowest.wants(elapsedTime) {
+		slow := SlowRequest{URL: req.URL.String(), Latency: elapsedTime, StartedAt: startTime}
+		if err != nil {
+			slow.Error = err.Error()
+		} else {
+			slow.Status = resp.StatusCode
+		}
+		metrics.Slowest.add(slow)
+	}
+	// Times are recorded as measured; -clamp-max-latency only shapes the
+	// distribution buildSummary displays, so outliers still count against SLOs.
+	if cfg.ClampMaxLatency > 0 && elapsedTime > cfg.ClampMaxLatency {
+		metrics.ClampedCount++
+		if elapsedTime > metrics.ClampedMax {
+			metrics.ClampedMax = elapsedTime
+		}
+	}
+
+	if ttfb > 0 {
+		metrics.TTFBTimes.add(ttfb)
+		if ttfb > metrics.WorstTTFB {
+			metrics.WorstTTFB = ttfb
+			metrics.WorstTTFBSecond = int(timings.firstByte.Sub(metrics.StartTime) / time.Second)
+		}
+	}
+	if cfg.StreamResponse && err == nil {
+		metrics.StreamBytes += streamed
+		metrics.StreamReadTimes.add(streamTime)
+	}
+	metrics.PollCount += int64(polls)
+	if pollTimedOut {
+		metrics.PollTimeouts++
+	}
+
+	metrics.recordTime(elapsedTime)
+	metrics.BytesReceived += received
+	if success {
+		metrics.GoodBytes += received
+	}
+	metrics.BuildTimes.add(buildTime)
+	if body.Name != "" {
+		metrics.BodyUsage[body.Name]++
+	}
+
+	for _, bucket := range metrics.Histogram {
+		if elapsedTime <= bucket.Mark {
+			bucket.Count++
+			break
+		}
+	}
+
+	reset := isConnectionReset(err) || isConnectionReset(classifyErr)
+	if reset {
+		metrics.ConnResets++
+	}
+	if !timings.tlsDone.IsZero() {
+		metrics.TLSHandshakes++
+		if timings.resumed {
+			metrics.TLSResumed++
+		}
+	}
+	if category := timeoutCategory(err, classifyErr, timings); category != "" {
+		metrics.Timeouts[category]++
+	}
+	metrics.recordInterval(time.Now(), elapsedTime, !success, reset)
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if !metrics.TargetResolved {
+			metrics.EarlyDNSFailures++
+			metrics.LastDNSError = dnsErr.Error()
+		}
+	} else {
+		metrics.TargetResolved = true
+	}
+
+	if backend != "" {
+		samples := metrics.Backends[backend]
+		if samples == nil {
+			samples = &backendSamples{}
+			metrics.Backends[backend] = samples
+		}
+		samples.Requests++
+		if !success {
+			samples.Failures++
+		}
+	}
+
+	if err != nil {
+		metrics.FailureCount++
+		if isConnectFailure(err, timings) {
+			metrics.ConnectFailures++
+		}
+		metrics.StatusCodeCount[0]++ // Representing client-side errors
+		metrics.logError(errorCategory(err, nil, timings), requestID, err)
+	} else {
+		if success {
+			metrics.SuccessCount++
+		} else {
+			metrics.FailureCount++
+		}
+		for name, values := range resp.Trailer {
+			if metrics.TrailerValues[name] == nil {
+				metrics.TrailerValues[name] = make(map[string]int)
+			}
+			metrics.TrailerValues[name][strings.Join(values, ",")]++
+		}
+		if metrics.Cache != nil {
+			metrics.Cache.record(resp.Header)
+		}
+		for name, duration := range parseServerTiming(append(resp.Header.Values("Server-Timing"), resp.Trailer.Values("Server-Timing")...)) {
+			samples := metrics.ServerTiming[name]
+			if samples == nil {
+				if len(metrics.ServerTiming) >= maxServerTimingNames {
+					continue
+				}
+				samples = &timingSample{}
+				metrics.ServerTiming[name] = samples
+			}
+			samples.add(duration)
+		}
+		if backend != "" {
+			metrics.Backends[backend].Times.add(elapsedTime)
+		}
+		if metrics.ContentTypes != nil {
+			contentType := normalizeContentType(resp.Header.Get("Content-Type"))
+			samples := metrics.ContentTypes[contentType]
+			if samples == nil {
+				samples = &contentTypeSamples{}
+				metrics.ContentTypes[contentType] = samples
+			}
+			samples.Times.add(elapsedTime)
+			samples.Bytes += received
+		}
+		if errors.Is(classifyErr, errAssertionFailed) {
+			metrics.AssertFailures++
+		}
+		if errors.Is(classifyErr, errIntegrity) {
+			metrics.IntegrityFails++
+		}
+		if errors.Is(classifyErr, errTooSlow) {
+			metrics.TooSlow++
+		}
+		if classifyErr != nil {
+			metrics.logError(errorCategory(nil, classifyErr, timings), requestID, classifyErr)
+		} else if !success && metrics.ErrorExamples != nil {
+			// A failing status alone is not an error worth logging, but it is
+			// still a kind of failure.
+			metrics.ErrorExamples.add("http-status", "status "+resp.Status)
+		}
+		metrics.StatusCodeCount[resp.StatusCode]++
+	}
+}
+
+// startCPUProfile begins CPU profiling into path and returns a function that stops
+// profiling and closes the file.
+func startCPUProfile(path string) (func(), error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}, nil
+}
+
+// writeMemProfile writes a heap profile to path, reporting any failure.
+func writeMemProfile(path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Error creating memory profile: %v\n", err)
+		return
+	}
+	defer f.Close()
+	runtime.GC() // get up-to-date statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		fmt.Printf("Error writing memory profile: %v\n", err)
+	}
+}
+
+// watchDNSFailures aborts the run if the first -dns-failure-threshold requests all
+// fail to resolve the target, since every later request would fail the same way.
+// It stops watching once any request gets past DNS resolution.
+func watchDNSFailures(ctx context.Context, cfg *Config, abort context.CancelFunc) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.Lock.Lock()
+			resolved := metrics.TargetResolved
+			failures := metrics.EarlyDNSFailures
+			lastErr := metrics.LastDNSError
+			if !resolved && failures >= cfg.DNSFailureThreshold {
+				metrics.AbortReason = fmt.Sprintf("target appears unresolvable: the first %d requests failed DNS resolution (%s)", failures, lastErr)
+			}
+			reason := metrics.AbortReason
+			metrics.Lock.Unlock()
+
+			if resolved {
+				return
+			}
+			if failures >= cfg.DNSFailureThreshold {
+				fmt.Printf("\n%sAborting: %s.%s\n", ColorRed, reason, ColorReset)
+				abort()
+				return
+			}
+		}
+	}
+}
+
+// watchTailLatency aborts the run once the p99 of the responses recorded during the
+// most recent window exceeds the configured threshold.
+func watchTailLatency(ctx context.Context, cfg *Config, abort context.CancelFunc) {
+	ticker := time.NewTicker(cfg.AbortWindow)
+	defer ticker.Stop()
+
+	var seen int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.Lock.Lock()
+			var window []float64
+			if metrics.RecentTimes != nil {
+				// ResponseTimes is not kept; the newest times are in the ring, up
+				// to its size.
+				window = metrics.RecentTimes.values()
+				if fresh := metrics.TimedCount - seen; fresh < int64(len(window)) {
+					window = window[int64(len(window))-fresh:]
+				}
+			} else {
+				window = make([]float64, len(metrics.ResponseTimes)-int(seen))
+				copy(window, metrics.ResponseTimes[seen:])
+			}
+			seen = metrics.TimedCount
+			metrics.Lock.Unlock()
+
+			if len(window) == 0 {
+				continue
+			}
+			sort.Float64s(window)
+			p99 := percentile(window, 99)
+			if p99 <= cfg.AbortOnP99 {
+				continue
+			}
+
+			reason := fmt.Sprintf("p99 of %.4fs over the last %s exceeded the %.4fs threshold", p99, cfg.AbortWindow, cfg.AbortOnP99)
+			metrics.Lock.Lock()
+			metrics.AbortReason = reason
+			metrics.Lock.Unlock()
+			fmt.Printf("\n%sAborting: %s.%s\n", ColorRed, reason, ColorReset)
+			abort()
+			return
+		}
+	}
+}
+
+// ciCheckInterval is how often watchCIWidth recomputes the confidence interval.
+const ciCheckInterval = 500 * time.Millisecond
+
+// watchCIWidth stops sending new requests, by calling stop, once the 95%
+// confidence interval of p99 over all response times so far is no wider than
+// -until-ci-width. Requests already in flight still complete. Why it stopped is
+// sent on notices for printLiveMetrics to print.
+func watchCIWidth(ctx context.Context, cfg *Config, stop context.CancelFunc, notices chan<- string) {
+	ticker := time.NewTicker(ciCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.Lock.Lock()
+			times := make([]float64, len(metrics.ResponseTimes))
+			copy(times, metrics.ResponseTimes)
+			metrics.Lock.Unlock()
+
+			sort.Float64s(times)
+			lower, upper, ok := p99ConfidenceInterval(times)
+			if !ok || upper-lower > cfg.UntilCIWidth {
+				continue
+			}
+			notice := fmt.Sprintf("%sStopping: the 95%% confidence interval of p99 is %.4fs wide after %d responses, within the %.4fs target.%s",
+				ColorYellow, upper-lower, len(times), cfg.UntilCIWidth, ColorReset)
+			select {
+			case notices <- notice:
+			default:
+			}
+			stop()
+			return
+		}
+	}
+}
+
+// p99ConfidenceInterval returns a 95% confidence interval for the p99 of sorted,
+// using the order statistics a normal approximation to the binomial puts around
+// it. ok is false while there are too few samples for the upper bound, which
+// takes several hundred.
+func p99ConfidenceInterval(sorted []float64) (lower, upper float64, ok bool) {
+	const p, z = 0.99, 1.96
+	n := float64(len(sorted))
+	spread := z * math.Sqrt(n*p*(1-p))
+	lo := int(math.Floor(n*p - spread))
+	hi := int(math.Ceil(n*p + spread))
+	if lo < 0 || hi >= len(sorted) {
+		return 0, 0, false
+	}
+	return sorted[lo], sorted[hi], true
+}
+
+// isTerminal reports whether f refers to an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// defaultReportInterval returns the live metrics cadence used when -report-interval
+// is not given: a fast spinner on a terminal, and sparse log lines otherwise.
+func defaultReportInterval(interactive bool) time.Duration {
+	if interactive {
+		return 100 * time.Millisecond
+	}
+	return 5 * time.Second
+}
+
+var errAssertionFailed = errors.New("assertion failed")
+
+// errIntegrity marks a response whose body did not match -expect-sha256.
+var errIntegrity = errors.New("integrity check failed")
+
+// errTooSlow marks an otherwise successful response that exceeded -max-acceptable-latency.
+var errTooSlow = errors.New("too slow")
+
+// isConnectionReset reports whether err means the server dropped the connection:
+// a reset, a write to a closed connection, or EOF before the response was complete.
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isTimeout reports whether err is a timeout or an expired deadline.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// isConnectFailure reports whether a request failed with err before it ever had a
+// connection to send on: DNS, TCP connect and TLS handshake failures, and timeouts
+// during them. Requests cancelled by the run itself are not counted.
+func isConnectFailure(err error, timings *requestTimings) bool {
+	return err != nil && timings.gotConn.IsZero() && !errors.Is(err, context.Canceled)
+}
+
+// timeoutCategory classifies a timed-out request by how far it got: no connection
+// yet (connect-timeout), connected but no response headers (header-timeout), or
+// headers received but the body not read in time (body-timeout). A timeout that
+// cannot be tied to one phase, such as -poll-timeout over a whole polling
+// workflow, is a total-timeout. It returns "" if the request did not time out.
+func timeoutCategory(reqErr, bodyErr error, timings *requestTimings) string {
+	switch {
+	case errors.Is(reqErr, errPollTimeout):
+		return "total-timeout"
+	case isTimeout(reqErr):
+		if timings.gotConn.IsZero() {
+			return "connect-timeout"
+		}
+		if timings.firstByte.IsZero() {
+			return "header-timeout"
+		}
+		return "total-timeout"
+	case isTimeout(bodyErr):
+		return "body-timeout"
+	}
+	return ""
+}
+
+// errorCategory names the kind of failure behind a transport error (reqErr) or an
+// error reading or judging the response (bodyErr), for -errors-per-category.
+// Timeouts are split by phase as in timeoutCategory.
+func errorCategory(reqErr, bodyErr error, timings *requestTimings) string {
+	if category := timeoutCategory(reqErr, bodyErr, timings); category != "" {
+		return category
+	}
+	err := reqErr
+	if err == nil {
+		err = bodyErr
+	}
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection-refused"
+	case isConnectionReset(err):
+		return "connection-reset"
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return "tls"
+	case errors.Is(err, errAssertionFailed):
+		return "assertion"
+	case errors.Is(err, errIntegrity):
+		return "integrity"
+	case errors.Is(err, errTooSlow):
+		return "too-slow"
+	}
+	return "other"
+}
+
+// classifyResponse decides whether a response counts as a success: by default any
+// 2xx status, or whatever -success-expr evaluates to, and in either case only if all
+// trailer and body assertions pass and the response was within -max-acceptable-latency.
+// The returned error explains failures worth logging; a plain unsuccessful status
+// fails without one.
+func classifyResponse(cfg *Config, resp *http.Response, body []byte, latency float64) (bool, error) {
+	success := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if cfg.SuccessExpr.enabled() {
+		var err error
+		success, err = cfg.SuccessExpr.match(resp.StatusCode, latency, string(body))
+		if err != nil {
+			return false, err
+		}
+	}
+	if !success {
+		return false, nil
+	}
+	for _, assertion := range cfg.TrailerAsserts {
+		if actual := resp.Trailer.Get(assertion.Name); actual != assertion.Value {
+			return false, fmt.Errorf("%w: trailer %s is %q, want %q", errAssertionFailed, assertion.Name, actual, assertion.Value)
+		}
+	}
+	if len(cfg.JSONPathAsserts) > 0 {
+		if err := checkJSONPathAssertions(body, cfg.JSONPathAsserts); err != nil {
+			return false, err
+		}
+	}
+	if cfg.ExpectSHA256 != "" {
+		if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != cfg.ExpectSHA256 {
+			return false, fmt.Errorf("%w: body SHA-256 is %x, want %s", errIntegrity, sum, cfg.ExpectSHA256)
+		}
+	}
+	if cfg.MaxAcceptableLatency > 0 && latency > cfg.MaxAcceptableLatency {
+		return false, fmt.Errorf("%w: took %.4fs, over the %.4fs limit", errTooSlow, latency, cfg.MaxAcceptableLatency)
+	}
+	return true, nil
+}
+
+// checkJSONPathAssertions decodes a JSON response body and evaluates each assertion
+// against it, returning the first failure.
+func checkJSONPathAssertions(body []byte, assertions jsonPathAssertions) error {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("%w: response body is not valid JSON: %v", errAssertionFailed, err)
+	}
+	for _, assertion := range assertions {
+		if err := assertion.check(doc); err != nil {
+			return fmt.Errorf("%w: %v", errAssertionFailed, err)
+		}
+	}
+	return nil
+}
+
+// applyHeaders sets the default User-Agent and any custom headers on req.
+func applyHeaders(header http.Header, cfg *Config) {
+	header.Set("User-Agent", "httptest-load-tester/1.0")
+	if cfg.userAgents != nil {
+		header.Set("User-Agent", cfg.userAgents.pick())
+	}
+	for _, h := range cfg.Headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) == 2 {
+			header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+}
+
+var errPollTimeout = errors.New("polling timed out")
+
+// errSoftDeadline is the cause of a request context cancelled at -soft-deadline.
+var errSoftDeadline = errors.New("soft deadline exceeded")
+
+// pollLocation follows an asynchronous 202 Accepted response by polling its Location
+// URL until cfg.PollUntilStatus is returned or cfg.PollTimeout elapses. It returns
+// the final response and the number of polls made.
+func pollLocation(ctx context.Context, client *http.Client, cfg *Config, accepted *http.Response) (*http.Response, int, error) {
+	io.Copy(io.Discard, accepted.Body)
+	accepted.Body.Close()
+
+	location, err := accepted.Location()
+	if err != nil {
+		return nil, 0, fmt.Errorf("202 response without a usable Location header: %w", err)
+	}
+
+	deadline := time.Now().Add(cfg.PollTimeout)
+	polls := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, polls, ctx.Err()
+		case <-time.After(cfg.PollInterval):
+		}
+		if time.Now().After(deadline) {
+			return nil, polls, fmt.Errorf("%w after %s (%d polls of %s)", errPollTimeout, cfg.PollTimeout, polls, location)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location.String(), nil)
+		if err != nil {
+			return nil, polls, err
+		}
+		applyHeaders(req.Header, cfg)
+		resp, err := client.Do(req)
+		polls++
+		if err != nil {
+			return nil, polls, err
+		}
+		if resp.StatusCode == cfg.PollUntilStatus {
+			return resp, polls, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// injectLatency sleeps for the configured artificial latency plus jitter. It exists
+// only so the tool's own measurements can be validated against a known delay.
+func injectLatency(ctx context.Context, cfg *Config) {
+	delay := cfg.InjectLatency
+	if cfg.InjectJitter > 0 {
+		delay += time.Duration(mrand.Int63n(int64(cfg.InjectJitter)))
+	}
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// newUUID returns a random (version 4) UUID string.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:]) // never returns an error
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// inFlightSampleInterval is how often sampleInFlight records the in-flight count.
+// It is independent of -report-interval, which defaults to 5s outside a terminal.
+const inFlightSampleInterval = 100 * time.Millisecond
+
+// sampleInFlight adds the number of requests in flight to the timeline every
+// inFlightSampleInterval until ctx ends.
+func sampleInFlight(ctx context.Context) {
+	ticker := time.NewTicker(inFlightSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			metrics.Lock.Lock()
+			interval := metrics.intervalAt(now)
+			interval.inFlightSum += atomic.LoadInt64(&metrics.InFlight)
+			interval.inFlightSamples++
+			metrics.Lock.Unlock()
+		}
+	}
+}
+
+// printInterimSummary prints the summary of the run so far, as asked for with
+// SIGUSR1.
+func printInterimSummary(startTime time.Time, cfg *Config) {
+	if summary := buildSummary(startTime, cfg); summary != nil {
+		printReport("Interim Summary", summary, cfg)
+		fmt.Println()
+	}
+}
+
+// printLiveMetrics refreshes the live progress line until ctx ends, and prints an
+// interim summary whenever a signal arrives on interim and each message sent on
+// notices.
+func printLiveMetrics(ctx context.Context, startTime time.Time, cfg *Config, interim <-chan os.Signal, notices <-chan string) {
+	spinner := []string{"|", "/", "-", "\\"}
+	spinIdx := 0
+	interactive := isTerminal(os.Stdout)
+	ticker := time.NewTicker(cfg.ReportInterval)
+	defer ticker.Stop()
+
+	printNotice := func(notice string) {
+		if interactive {
+			fmt.Println() // end the live line rather than overwrite it
+		}
+		fmt.Println(notice)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// A notice sent just before the run stopped is still printed.
+			select {
+			case notice := <-notices:
+				printNotice(notice)
+			default:
+			}
+			return
+		case notice := <-notices:
+			printNotice(notice)
+		case <-interim:
+			// Printed from this goroutine so it never interleaves with the live line.
+			printInterimSummary(startTime, cfg)
+		case <-ticker.C:
+			metrics.Lock.Lock()
+			sent := metrics.SuccessCount + metrics.FailureCount
+			elapsedTime := time.Since(startTime).Seconds()
+
+			displayTotal := "/" + fmt.Sprint(cfg.Requests)
+			if cfg.Requests == 0 {
+				displayTotal = ""
+			}
+
+			avg := "N/A"
+			p99 := "N/A"
+
+			timesCopy := make([]float64, len(metrics.ResponseTimes))
+			copy(timesCopy, metrics.ResponseTimes)
+
+			if len(timesCopy) > 0 {
+				sort.Float64s(timesCopy)
+				avg = cfg.LatencyUnit.format(average(timesCopy))
+				p99 = cfg.LatencyUnit.format(percentile(timesCopy, 99))
+			} else if metrics.Digest != nil && metrics.TimedCount > 0 {
+				avg = cfg.LatencyUnit.format(metrics.TimeSum / float64(metrics.TimedCount))
+				p99 = cfg.LatencyUnit.format(metrics.Digest.quantile(99))
+			}
+
+			budget := ""
+			if cfg.sloEnabled() && sent > 0 {
+				breaches := countAbove(timesCopy, cfg.SLOP99)
+				if metrics.Digest != nil {
+					breaches = metrics.Digest.countAbove(cfg.SLOP99)
+				}
+				b := computeSLOBudget(breaches, metrics.TimedCount, sent, metrics.FailureCount, cfg.SLOP99, cfg.SLOErrorRate)
+				color := ColorGreen
+				if b.BudgetRemaining <= 0 {
+					color = ColorRed
+				}
+				budget = fmt.Sprintf(" | %sBudget: %.1f%%%s", color, b.BudgetRemaining, ColorReset)
+			}
+
+			recent := ""
+			if window := metrics.rollingWindow(time.Now(), int(math.Ceil(cfg.RollingWindow.Seconds()))); window.Requests > 0 {
+				color := ColorGreen
+				if window.Failures > 0 {
+					color = ColorRed
+				}
+				recent = fmt.Sprintf(" | Last %s: %sErr %.1f%%%s p99 %s", cfg.RollingWindow, color, window.ErrorRate, ColorReset, cfg.LatencyUnit.format(window.P99))
+			}
+
+			line := fmt.Sprintf("Requests Sent: %d%s | %sSuccess: %d%s | %sFailures: %d%s | Avg Resp: %s | 99th Pctl: %s%s | Elapsed: %.2fs%s",
+				sent, displayTotal, ColorGreen, metrics.SuccessCount, ColorReset, ColorRed, metrics.FailureCount, ColorReset, avg, p99, recent, elapsedTime, budget)
+			metrics.Lock.Unlock()
+
+			indicator := spinner[spinIdx]
+			// With -until-ci-width, Requests is only the -max-requests cap, so
+			// progress towards it would be meaningless.
+			if cfg.ProgressBar && cfg.Requests > 0 && cfg.UntilCIWidth == 0 {
+				percent, eta := progress(sent, int64(cfg.Requests), elapsedTime)
+				remaining := "ETA --"
+				if eta >= 0 {
+					remaining = "ETA " + eta.Round(time.Second).String()
+				}
+				indicator = fmt.Sprintf("%s %5.1f%% %s |", progressBar(percent, 20), percent, remaining)
+				if !interactive {
+					line = indicator + " " + line
+				}
+			}
+
+			if interactive {
+				fmt.Printf("\r%s%s %s%s ", ColorCyan, indicator, line, ColorReset)
+			} else {
+				// One self-contained snapshot per line so the output reads well in log files.
+				fmt.Printf("[%s] %s%s\n", time.Now().Format(time.RFC3339), line, ColorReset)
+			}
+
+			spinIdx = (spinIdx + 1) % len(spinner)
+		}
+	}
+}
+
+// progress returns how far a fixed-count run is, in percent, and the time it
+// should take to finish at the rate so far. The estimate is negative until the
+// first request completes.
+func progress(done, total int64, elapsed float64) (float64, time.Duration) {
+	if total <= 0 {
+		return 0, -1
+	}
+	if done > total {
+		done = total
+	}
+	percent := float64(done) / float64(total) * 100
+	if done == 0 || elapsed <= 0 {
+		return percent, -1
+	}
+	rate := float64(done) / elapsed
+	return percent, time.Duration(float64(total-done) / rate * float64(time.Second))
+}
+
+// progressBar renders percent as a bar of width cells, e.g. "[#####-----]".
+func progressBar(percent float64, width int) string {
+	filled := int(percent / 100 * float64(width))
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
+// printSummary prints the final report and returns the summary it was built from,
+// or nil if no requests were sent.
+func printSummary(startTime time.Time, cfg *Config) *Summary {
+	summary := buildSummary(startTime, cfg)
+	if summary == nil {
+		metrics.Lock.Lock()
+		cancelled := metrics.CancelledSlow
+		metrics.Lock.Unlock()
+		if cancelled > 0 {
+			fmt.Printf("\nNo requests completed; all %d were cancelled at the -soft-deadline of %gs.\n", cancelled, cfg.SoftDeadline)
+		} else {
+			fmt.Println("\nNo requests were sent.")
+		}
+		return nil
+	}
+	printReport("Load Test Summary", summary, cfg)
+
+	if cfg.OpenMetrics {
+		writeOpenMetrics(cfg.openMetricsOut, summary)
+	}
+
+	// --- JSON File Output ---
+	if cfg.OutputFile != "" {
+		jsonData, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			fmt.Printf("\nError marshalling summary to JSON: %v\n", err)
+			return summary
+		}
+		err = ioutil.WriteFile(cfg.OutputFile, jsonData, 0644)
+		if err != nil {
+			fmt.Printf("\nError writing summary to file '%s': %v\n", cfg.OutputFile, err)
+			return summary
+		}
+		fmt.Printf("\nSummary report saved to %s\n", cfg.OutputFile)
+	}
+	return summary
+}
+
+// buildSummary snapshots the metrics recorded so far into a Summary, or returns nil
+// if no requests were sent. The summary shares nothing with the live metrics, so it
+// can be printed while workers keep recording.
+func buildSummary(startTime time.Time, cfg *Config) *Summary {
+	metrics.Lock.Lock()
+	defer metrics.Lock.Unlock()
+
+	elapsedTime := time.Since(startTime).Seconds()
+	totalRequests := metrics.SuccessCount + metrics.FailureCount
+	if totalRequests == 0 {
+		return nil
+	}
+
+	finalResponseTimes := make([]float64, len(metrics.ResponseTimes))
+	copy(finalResponseTimes, metrics.ResponseTimes)
+	sort.Float64s(finalResponseTimes)
+
+	avgResponse := average(finalResponseTimes)
+	minResponse := min(finalResponseTimes)
+	maxResponse := max(finalResponseTimes)
+	// The percentiles, histogram and CDF show the distribution; with
+	// -clamp-max-latency they are built from times capped at the ceiling, while the
+	// average, minimum, maximum and outlier count keep the true times.
+	distributionTimes := finalResponseTimes
+	if cfg.ClampMaxLatency > 0 {
+		distributionTimes = make([]float64, len(finalResponseTimes))
+		for i, t := range finalResponseTimes {
+			if t > cfg.ClampMaxLatency {
+				t = cfg.ClampMaxLatency
+			}
+			distributionTimes[i] = t
+		}
+	}
+	p90 := percentile(distributionTimes, 90)
+	p99 := percentile(distributionTimes, 99)
+
+	summary := Summary{
+		TotalRequestsSent:  totalRequests,
+		SuccessfulRequests: metrics.SuccessCount,
+		FailedRequests:     metrics.FailureCount,
+		ConnectionFailures: metrics.ConnectFailures,
+		SuccessRate:        (float64(metrics.SuccessCount) / float64(totalRequests)) * 100,
+		FailureRate:        (float64(metrics.FailureCount) / float64(totalRequests)) * 100,
+		TotalTimeTaken:     elapsedTime,
+		RequestsPerSecond:  0.00,
+		AvgResponseTime:    avgResponse,
+		MinResponseTime:    minResponse,
+		MaxResponseTime:    maxResponse,
+		Percentile90:       p90,
+		Percentile99:       p99,
+		TimedRequests:      int(metrics.TimedCount),
+		StatusCodeDist:     make(map[int]int, len(metrics.StatusCodeCount)),
+		Histogram:          make([]*HistogramBucket, len(metrics.Histogram)),
+		CDF:                computeCDF(distributionTimes),
+		ErrorSummary:       append([]string(nil), metrics.ErrorLog...),
+		ErrorCategories:    metrics.ErrorExamples.categories(),
+		SlowestRequests:    metrics.Slowest.sorted(),
+		RequestEncoding:    "content-length",
+		AbortReason:        metrics.AbortReason,
+		BodyUsage:          make(map[string]int, len(metrics.BodyUsage)),
+		PollCount:          metrics.PollCount,
+		PollTimeouts:       metrics.PollTimeouts,
+		AssertionFailures:  metrics.AssertFailures,
+		IntegrityFailures:  metrics.IntegrityFails,
+		TooSlowRequests:    metrics.TooSlow,
+		TLSHandshakes:      metrics.TLSHandshakes,
+		TLSResumed:         metrics.TLSResumed,
+		PeakConnections:    metrics.PeakConns,
+		CancelledSlow:      metrics.CancelledSlow,
+		TrailerValues:      make(map[string]map[string]int, len(metrics.TrailerValues)),
+		Metadata:           newRunMetadata(cfg),
+	}
+	for code, count := range metrics.StatusCodeCount {
+		summary.StatusCodeDist[code] = count
+	}
+	for i, bucket := range metrics.Histogram {
+		summary.Histogram[i] = &HistogramBucket{Mark: bucket.Mark, Count: bucket.Count}
+	}
+	if cfg.ClampMaxLatency > 0 {
+		clampHistogram(summary.Histogram, cfg.ClampMaxLatency)
+	}
+	for name, count := range metrics.BodyUsage {
+		summary.BodyUsage[name] = count
+	}
+	for name, values := range metrics.TrailerValues {
+		summary.TrailerValues[name] = make(map[string]int, len(values))
+		for value, count := range values {
+			summary.TrailerValues[name][value] = count
+		}
+	}
+	if metrics.Cache != nil {
+		summary.Cache = metrics.Cache.copy()
+	}
+	if cfg.UntilCIWidth > 0 {
+		if lower, upper, ok := p99ConfidenceInterval(finalResponseTimes); ok {
+			summary.P99CI = &ConfidenceInterval{Lower: lower, Upper: upper, Width: upper - lower, Target: cfg.UntilCIWidth}
+		}
+	}
+	if cfg.ClampMaxLatency > 0 {
+		summary.Clamped = &ClampStats{Ceiling: cfg.ClampMaxLatency, Count: metrics.ClampedCount, TrueMax: metrics.ClampedMax}
+	}
+	if len(metrics.Timeouts) > 0 {
+		summary.Timeouts = make(map[string]int64, len(metrics.Timeouts))
+		for category, count := range metrics.Timeouts {
+			summary.Timeouts[category] = count
+		}
+	}
+	if len(metrics.ServerTiming) > 0 {
+		summary.ServerTiming = make(map[string]*TimingStats, len(metrics.ServerTiming))
+		for name, samples := range metrics.ServerTiming {
+			summary.ServerTiming[name] = samples.stats()
+		}
+	}
+	if len(metrics.Backends) > 0 {
+		summary.Backends = make(map[string]*BackendStats, len(metrics.Backends))
+		for addr, samples := range metrics.Backends {
+			summary.Backends[addr] = &BackendStats{
+				Requests: samples.Requests,
+				Failures: samples.Failures,
+				Latency:  samples.Times.stats(),
+			}
+		}
+	}
+	if len(metrics.ContentTypes) > 0 {
+		summary.ContentTypes = make(map[string]*ContentTypeStats, len(metrics.ContentTypes))
+		for contentType, samples := range metrics.ContentTypes {
+			summary.ContentTypes[contentType] = &ContentTypeStats{
+				Responses:  int(samples.Times.count),
+				Latency:    samples.Times.stats(),
+				TotalBytes: samples.Bytes,
+				AvgBytes:   float64(samples.Bytes) / float64(samples.Times.count),
+			}
+		}
+	}
+	if cfg.StreamResponse {
+		summary.Stream = &StreamStats{
+			BytesRead:    metrics.StreamBytes,
+			AvgReadTime:  metrics.StreamReadTimes.avg(),
+			Percentile99: percentile(metrics.StreamReadTimes.sorted(), 99),
+			MaxReadTime:  metrics.StreamReadTimes.max,
+		}
+	}
+	if metrics.QueueTimes.count > 0 {
+		summary.QueueWait = metrics.QueueTimes.stats()
+	}
+	if metrics.BuildTimes.count > 0 {
+		summary.BuildOverhead = metrics.BuildTimes.stats()
+	}
+	if metrics.TTFBTimes.count > 0 {
+		summary.TTFB = &TTFBStats{
+			Avg:         metrics.TTFBTimes.avg(),
+			StdDev:      metrics.TTFBTimes.stdDev(),
+			P99:         percentile(metrics.TTFBTimes.sorted(), 99),
+			Max:         metrics.WorstTTFB,
+			MaxAtSecond: metrics.WorstTTFBSecond,
+		}
+	}
+	if metrics.RecentTimes != nil && metrics.TimedCount > 0 {
+		recent := metrics.RecentTimes.values()
+		summary.RecentLatency = newTimingStats(recent)
+		summary.RecentSamples = len(recent)
+		// The lifetime figures that can be tracked exactly are; the percentiles
+		// and CDF are estimated by the digest.
+		summary.AvgResponseTime = metrics.TimeSum / float64(metrics.TimedCount)
+		summary.MinResponseTime = metrics.TimeMin
+		summary.MaxResponseTime = metrics.TimeMax
+		estimate := func(p float64) float64 {
+			latency := metrics.Digest.quantile(p)
+			if cfg.ClampMaxLatency > 0 {
+				latency = math.Min(latency, cfg.ClampMaxLatency)
+			}
+			return latency
+		}
+		summary.Percentile90 = estimate(90)
+		summary.Percentile99 = estimate(99)
+		for i := range summary.CDF {
+			summary.CDF[i].Latency = estimate(summary.CDF[i].Fraction)
+		}
+		summary.DigestPercentiles = true
+	}
+	if cfg.Chunked {
+		summary.RequestEncoding = "chunked"
+	}
+	summary.Throughput = ThroughputStats{TotalBytes: metrics.BytesReceived, GoodBytes: metrics.GoodBytes}
+	if elapsedTime > 0 {
+		summary.RequestsPerSecond = float64(totalRequests) / elapsedTime
+		summary.Throughput.BytesPerSecond = float64(metrics.BytesReceived) / elapsedTime
+		summary.Throughput.GoodputPerSecond = float64(metrics.GoodBytes) / elapsedTime
+	}
+	summary.RPSStats = computeRPSStats(metrics.Timeline, elapsedTime)
+	if metrics.ConnResets > 0 {
+		summary.ConnectionResets = &ResetStats{
+			Count:  metrics.ConnResets,
+			Rate:   float64(metrics.ConnResets) / float64(totalRequests) * 100,
+			Series: make([]int, len(metrics.Timeline)),
+		}
+		for _, interval := range metrics.Timeline {
+			summary.ConnectionResets.Series[interval.Second] = interval.Resets
+		}
+	}
+	if peak := atomic.LoadInt64(&metrics.PeakInFlight); peak > 0 {
+		summary.Concurrency = &ConcurrencyStats{
+			Limit:  cfg.Concurrency,
+			Max:    peak,
+			Series: make([]int, len(metrics.Timeline)),
+		}
+		var sum int64
+		var samples int
+		for _, interval := range metrics.Timeline {
+			sum += interval.inFlightSum
+			samples += interval.inFlightSamples
+			summary.Concurrency.Series[interval.Second] = interval.avgInFlight()
+		}
+		if samples > 0 {
+			summary.Concurrency.Avg = float64(sum) / float64(samples)
+		}
+	}
+	if cfg.sloEnabled() {
+		breaches := countAbove(finalResponseTimes, cfg.SLOP99)
+		if metrics.Digest != nil {
+			breaches = metrics.Digest.countAbove(cfg.SLOP99)
+		}
+		summary.SLOBudget = computeSLOBudget(breaches, metrics.TimedCount, totalRequests, metrics.FailureCount, cfg.SLOP99, cfg.SLOErrorRate)
+	}
+	return &summary
+}
+
+// printReport prints a summary to the console under the given title.
+func printReport(title string, summary *Summary, cfg *Config) {
+	width := terminalWidth()
+	fmt.Printf("\n\n%s%s%s\n%s%s%s\n", ColorYellow, title, ColorReset, ColorYellow, strings.Repeat("=", len(title)+1), ColorReset)
+	if summary.TotalRequestsSent > 0 && summary.SuccessfulRequests == 0 {
+		fmt.Printf("%sAll %d requests failed.%s\n", ColorRed, summary.TotalRequestsSent, ColorReset)
+		if len(summary.ErrorSummary) > 0 {
+			printTopErrors(summary.ErrorSummary, 5)
+		} else {
+			fmt.Println("No errors were logged; see the status code distribution below.")
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Total Requests Sent      : %s%d%s\n", ColorCyan, summary.TotalRequestsSent, ColorReset)
+	fmt.Printf("Successful Requests      : %s%d%s\n", ColorGreen, summary.SuccessfulRequests, ColorReset)
+	fmt.Printf("Failed Requests          : %s%d%s\n", ColorRed, summary.FailedRequests, ColorReset)
+	if summary.FailedRequests > 0 {
+		fmt.Printf("Connection Failures      : %s%d%s (no connection could be established)\n", ColorRed, summary.ConnectionFailures, ColorReset)
+		fmt.Printf("Request Failures         : %s%d%s (connected, then failed)\n", ColorRed, summary.FailedRequests-summary.ConnectionFailures, ColorReset)
+	}
+	if cfg.SoftDeadline > 0 {
+		fmt.Printf("Cancelled Slow           : %s%d%s (over the %gs soft deadline; not in the counts above)\n", ColorYellow, summary.CancelledSlow, ColorReset, cfg.SoftDeadline)
+	}
+	if len(cfg.JSONPathAsserts) > 0 || len(cfg.TrailerAsserts) > 0 {
+		fmt.Printf("Assertion Failures       : %s%d%s\n", ColorRed, summary.AssertionFailures, ColorReset)
+	}
+	if cfg.ExpectSHA256 != "" {
+		fmt.Printf("Integrity Failures       : %s%d%s\n", ColorRed, summary.IntegrityFailures, ColorReset)
+	}
+	if cfg.MaxAcceptableLatency > 0 {
+		fmt.Printf("Too-Slow Failures        : %s%d%s (over %s)\n", ColorRed, summary.TooSlowRequests, ColorReset, cfg.LatencyUnit.format(cfg.MaxAcceptableLatency))
+	}
+	fmt.Printf("Success Rate             : %s%.2f%%%s\n", ColorGreen, summary.SuccessRate, ColorReset)
+	fmt.Printf("Failure Rate             : %s%.2f%%%s\n", ColorRed, summary.FailureRate, ColorReset)
+	if summary.ConnectionResets != nil {
+		fmt.Printf("Connection Resets        : %s%d (%.2f%%)%s\n", ColorRed, summary.ConnectionResets.Count, summary.ConnectionResets.Rate, ColorReset)
+		fmt.Printf("Resets Over Time         : %s%s%s\n", ColorRed, sparkline(summary.ConnectionResets.Series, chartWidth(width, 27)), ColorReset)
+	}
+	for _, category := range []string{"connect-timeout", "header-timeout", "body-timeout", "total-timeout"} {
+		if count := summary.Timeouts[category]; count > 0 {
+			fmt.Printf("%-25s: %s%d%s\n", "Timeouts ("+category+")", ColorRed, count, ColorReset)
+		}
+	}
+	fmt.Printf("Total Time Taken         : %.2f seconds\n", summary.TotalTimeTaken)
+	fmt.Printf("Requests per Second      : %.2f\n", summary.RequestsPerSecond)
+	if summary.RPSStats != nil {
+		fmt.Printf("RPS Min / Max / StdDev   : %.2f / %.2f / %.2f\n", summary.RPSStats.Min, summary.RPSStats.Max, summary.RPSStats.StdDev)
+		fmt.Printf("RPS p10 / p50 / p90      : %.2f / %.2f / %.2f\n", summary.RPSStats.P10, summary.RPSStats.P50, summary.RPSStats.P90)
+		fmt.Printf("RPS Over Time            : %s%s%s\n", ColorCyan, sparkline(summary.RPSStats.Series, chartWidth(width, 27)), ColorReset)
+	}
+	fmt.Printf("Throughput               : %s/s (%s received)\n", formatBytes(summary.Throughput.BytesPerSecond), formatBytes(float64(summary.Throughput.TotalBytes)))
+	fmt.Printf("Goodput                  : %s%s/s%s (%s from successful requests)\n", ColorGreen, formatBytes(summary.Throughput.GoodputPerSecond), ColorReset, formatBytes(float64(summary.Throughput.GoodBytes)))
+	if cfg.PerWorkerRPS > 0 {
+		fmt.Printf("Per-Worker RPS           : %.2f (target %.2f)\n", summary.RequestsPerSecond/float64(cfg.Concurrency), cfg.PerWorkerRPS)
+	}
+	fmt.Printf("Request Body Encoding    : %s\n", summary.RequestEncoding)
+	fmt.Printf("TCP Settings             : nodelay=%t keepalive=%s\n", summary.Metadata.TCPNoDelay, summary.Metadata.TCPKeepAlive)
+	fmt.Printf("Latency Mode             : %s\n", summary.Metadata.LatencyMode)
+	if cfg.Connections > 0 {
+		fmt.Printf("Peak Open Connections    : %d (limit %d)\n", summary.PeakConnections, cfg.Connections)
+	} else {
+		fmt.Printf("Peak Open Connections    : %d\n", summary.PeakConnections)
+	}
+	if c := summary.Concurrency; c != nil {
+		fmt.Printf("Realized Concurrency     : avg %.1f, max %d (limit %d)\n", c.Avg, c.Max, c.Limit)
+		if len(c.Series) > 1 {
+			fmt.Printf("Concurrency Over Time    : %s\n", sparkline(c.Series, chartWidth(width, 27)))
+		}
+	}
+	if summary.TLSHandshakes > 0 {
+		fmt.Printf("TLS Handshakes           : %d (%d resumed)\n", summary.TLSHandshakes, summary.TLSResumed)
+	}
+	if summary.AbortReason != "" {
+		fmt.Printf("Run Aborted              : %s%s%s\n", ColorRed, summary.AbortReason, ColorReset)
+	}
+
+	if summary.Stream != nil {
+		fmt.Printf("\n%sTime to First Byte Metrics%s\n%s--------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	} else {
+		fmt.Printf("\n%sResponse Time Metrics%s\n%s---------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	}
+	unit := cfg.LatencyUnit
+	if summary.TimedRequests == 0 {
+		fmt.Printf("%sNot available: no request got far enough to be timed.%s\n", ColorRed, ColorReset)
+	} else {
+		if summary.SuccessfulRequests == 0 {
+			fmt.Printf("%sEvery request failed; these are the latencies of the failures.%s\n", ColorRed, ColorReset)
+		}
+		fmt.Printf("Average Response Time    : %s%s%s\n", ColorCyan, unit.format(summary.AvgResponseTime), ColorReset)
+		fmt.Printf("90th Percentile          : %s\n", unit.format(summary.Percentile90))
+		fmt.Printf("99th Percentile          : %s\n", unit.format(summary.Percentile99))
+		if summary.DigestPercentiles {
+			fmt.Printf("%sPercentiles are estimated to within 1%% from all %d response times (-max-latency-samples).%s\n",
+				ColorYellow, summary.TimedRequests, ColorReset)
+		}
+		if ci := summary.P99CI; ci != nil {
+			color := ColorGreen
+			if ci.Width > ci.Target {
+				color = ColorRed
+			}
+			fmt.Printf("p99 95%% Conf. Interval   : %s to %s (%swidth %s%s, target %s)\n", unit.format(ci.Lower), unit.format(ci.Upper), color, unit.format(ci.Width), ColorReset, unit.format(ci.Target))
+		} else if cfg.UntilCIWidth > 0 {
+			fmt.Printf("p99 95%% Conf. Interval   : %snot available: too few responses%s\n", ColorRed, ColorReset)
+		}
+		fmt.Printf("Minimum Response Time    : %s\n", unit.format(summary.MinResponseTime))
+		fmt.Printf("Maximum Response Time    : %s\n", unit.format(summary.MaxResponseTime))
+		if summary.Clamped != nil {
+			fmt.Printf("Clamped Outliers         : %s%d%s over %s", ColorRed, summary.Clamped.Count, ColorReset, unit.format(summary.Clamped.Ceiling))
+			if summary.Clamped.Count > 0 {
+				fmt.Printf(" (true maximum %s)", unit.format(summary.Clamped.TrueMax))
+			}
+			fmt.Println()
+		}
+
+		// Leave room for the bucket label, count and percentages on each histogram line.
+		printHistogram(summary.Histogram, chartWidth(width, 60))
+		printCDF(summary.CDF, unit)
+	}
+
+	if summary.Stream != nil {
+		fmt.Printf("\n%sStream Reads%s\n%s------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		fmt.Printf("Total Bytes Read         : %d\n", summary.Stream.BytesRead)
+		fmt.Printf("Average Read Time        : %s\n", unit.format(summary.Stream.AvgReadTime))
+		fmt.Printf("99th Percentile Read Time: %s\n", unit.format(summary.Stream.Percentile99))
+		fmt.Printf("Maximum Read Time        : %s\n", unit.format(summary.Stream.MaxReadTime))
+	}
+
+	if len(summary.ServerTiming) > 0 {
+		printServerTiming(summary, unit)
+	}
+
+	if summary.TTFB != nil {
+		fmt.Printf("\n%sTime to First Byte Stability%s\n%s----------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		fmt.Printf("Average TTFB             : %s\n", unit.format(summary.TTFB.Avg))
+		fmt.Printf("TTFB Std Dev (Jitter)    : %s%s%s\n", ColorCyan, unit.format(summary.TTFB.StdDev), ColorReset)
+		fmt.Printf("99th Percentile          : %s\n", unit.format(summary.TTFB.P99))
+		fmt.Printf("Worst TTFB Spike         : %s%s%s at %ds into the run\n", ColorRed, unit.format(summary.TTFB.Max), ColorReset, summary.TTFB.MaxAtSecond)
+	}
+
+	if summary.BuildOverhead != nil {
+		fmt.Printf("\n%sClient-Side Overhead%s\n%s--------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		fmt.Printf("Average Build Time       : %s\n", unit.format(summary.BuildOverhead.Avg))
+		fmt.Printf("50th Percentile          : %s\n", unit.format(summary.BuildOverhead.P50))
+		fmt.Printf("90th Percentile          : %s\n", unit.format(summary.BuildOverhead.P90))
+		fmt.Printf("99th Percentile          : %s\n", unit.format(summary.BuildOverhead.P99))
+		fmt.Printf("Maximum Build Time       : %s\n", unit.format(summary.BuildOverhead.Max))
+	}
+
+	if summary.RecentLatency != nil {
+		title := fmt.Sprintf("Recent Latency (last %d)", summary.RecentSamples)
+		fmt.Printf("\n%s%s%s\n%s%s%s\n", ColorYellow, title, ColorReset, ColorYellow, strings.Repeat("-", len(title)), ColorReset)
+		fmt.Printf("Average Response Time    : %s\n", unit.format(summary.RecentLatency.Avg))
+		fmt.Printf("50th Percentile          : %s\n", unit.format(summary.RecentLatency.P50))
+		fmt.Printf("90th Percentile          : %s\n", unit.format(summary.RecentLatency.P90))
+		fmt.Printf("99th Percentile          : %s\n", unit.format(summary.RecentLatency.P99))
+		fmt.Printf("Maximum Response Time    : %s\n", unit.format(summary.RecentLatency.Max))
+	}
+
+	if summary.QueueWait != nil {
+		fmt.Printf("\n%sConcurrency Queueing%s\n%s--------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		fmt.Printf("Average Wait for Slot    : %s\n", unit.format(summary.QueueWait.Avg))
+		fmt.Printf("50th Percentile          : %s\n", unit.format(summary.QueueWait.P50))
+		fmt.Printf("90th Percentile          : %s\n", unit.format(summary.QueueWait.P90))
+		fmt.Printf("99th Percentile          : %s\n", unit.format(summary.QueueWait.P99))
+		fmt.Printf("Maximum Wait for Slot    : %s\n", unit.format(summary.QueueWait.Max))
+	}
+
+	if cfg.PollUntilStatus > 0 {
+		fmt.Printf("\n%sLocation Polling%s\n%s----------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		fmt.Printf("Total Polls              : %d\n", summary.PollCount)
+		fmt.Printf("Average Polls per Request: %.2f\n", float64(summary.PollCount)/float64(summary.TotalRequestsSent))
+		fmt.Printf("Polling Timeouts         : %s%d%s\n", ColorRed, summary.PollTimeouts, ColorReset)
+	}
+
+	if summary.SLOBudget != nil {
+		printSLOBudget(summary.SLOBudget, cfg)
+	}
+
+	if len(summary.BodyUsage) > 0 {
+		fmt.Printf("\n%sRequest Bodies Sent%s\n%s-------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		names := make([]string, 0, len(summary.BodyUsage))
+		for name := range summary.BodyUsage {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%-24s : %d requests\n", name, summary.BodyUsage[name])
+		}
+	}
+
+	if len(summary.TrailerValues) > 0 {
+		fmt.Printf("\n%sResponse Trailers%s\n%s-----------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		names := make([]string, 0, len(summary.TrailerValues))
+		for name := range summary.TrailerValues {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			values := make([]string, 0, len(summary.TrailerValues[name]))
+			for value := range summary.TrailerValues[name] {
+				values = append(values, value)
+			}
+			sort.Strings(values)
+			for _, value := range values {
+				fmt.Printf("%s = %-16q : %d responses\n", name, value, summary.TrailerValues[name][value])
+			}
+		}
+	}
+
+	if summary.Cache != nil {
+		printCacheStats(summary.Cache)
+	}
+
+	if len(summary.Backends) > 0 {
+		fmt.Printf("\n%sBy Backend%s\n%s----------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		addrs := make([]string, 0, len(summary.Backends))
+		for addr := range summary.Backends {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			stats := summary.Backends[addr]
+			fmt.Printf("%-24s : %d requests, %s%d failed%s, avg %s, p99 %s\n", addr, stats.Requests, ColorRed, stats.Failures, ColorReset,
+				unit.format(stats.Latency.Avg), unit.format(stats.Latency.P99))
+		}
+	}
+
+	if len(summary.ContentTypes) > 0 {
+		fmt.Printf("\n%sBy Content-Type%s\n%s---------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		types := make([]string, 0, len(summary.ContentTypes))
+		for contentType := range summary.ContentTypes {
+			types = append(types, contentType)
+		}
+		sort.Strings(types)
+		for _, contentType := range types {
+			stats := summary.ContentTypes[contentType]
+			fmt.Printf("%-24s : %d responses, avg %s, p50 %s, p99 %s, max %s, avg size %s\n",
+				contentType, stats.Responses, unit.format(stats.Latency.Avg), unit.format(stats.Latency.P50),
+				unit.format(stats.Latency.P99), unit.format(stats.Latency.Max), formatBytes(stats.AvgBytes))
+		}
+	}
+
+	fmt.Printf("\n%sStatus Code Distribution%s\n%s------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	for code, count := range summary.StatusCodeDist {
+		color := ColorGreen
+		if code == 0 || code >= 400 {
+			color = ColorRed
+		}
+		if code == 0 {
+			fmt.Printf("Client-Side Errors : %s%d responses%s\n", color, count, ColorReset)
+		} else {
+			fmt.Printf("Status Code %-7d : %s%d responses%s\n", code, color, count, ColorReset)
+		}
+	}
+
+	if len(summary.ErrorSummary) > 0 {
+		fmt.Printf("\n%sError Summary (first 100)%s\n%s--------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		limit := 100
+		if len(summary.ErrorSummary) < limit {
+			limit = len(summary.ErrorSummary)
+		}
+		for i, err := range summary.ErrorSummary[:limit] {
+			fmt.Printf("%s%d. %s%s\n", ColorRed, i+1, err, ColorReset)
+		}
+	}
+
+	if len(summary.ErrorCategories) > 0 {
+		printErrorCategories(summary.ErrorCategories)
+	}
+
+	if len(summary.SlowestRequests) > 0 {
+		title := fmt.Sprintf("Top %d Slowest Requests", len(summary.SlowestRequests))
+		fmt.Printf("\n%s%s%s\n%s%s%s\n", ColorYellow, title, ColorReset, ColorYellow, strings.Repeat("-", len(title)), ColorReset)
+		for i, r := range summary.SlowestRequests {
+			outcome := fmt.Sprint(r.Status)
+			if r.Status == 0 {
+				outcome = ColorRed + "error: " + r.Error + ColorReset
+			}
+			fmt.Printf("%2d. %s%s%s at %s  %s  %s\n", i+1, ColorCyan, cfg.LatencyUnit.format(r.Latency), ColorReset, r.StartedAt.Format("15:04:05.000"), r.URL, outcome)
+		}
+	}
+}
 
-	// Disable colors on Windows
-	if runtime.GOOS == "windows" {
-		ColorReset = ""
-		ColorRed = ""
-		ColorGreen = ""
-		ColorYellow = ""
-		ColorCyan = ""
+// printErrorCategories prints each error category, most frequent first, with its
+// example messages.
+func printErrorCategories(categories map[string]*ErrorCategory) {
+	fmt.Printf("\n%sErrors by Category%s\n%s------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	names := make([]string, 0, len(categories))
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if categories[names[i]].Count != categories[names[j]].Count {
+			return categories[names[i]].Count > categories[names[j]].Count
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		category := categories[name]
+		fmt.Printf("%s (%d):\n", name, category.Count)
+		for _, example := range category.Examples {
+			fmt.Printf("  %s- %s%s\n", ColorRed, example, ColorReset)
+		}
 	}
 }
 
-func main() {
-	initializeMetrics()
-
-	// --- Command-Line Flags ---
-	url := flag.String("url", "", "The target URL to test. (Required)")
-	requests := flag.Int("requests", 0, "Total number of requests to send. Incompatible with -duration.")
-	concurrency := flag.Int("concurrency", 10, "Number of concurrent requests to send.")
-	duration := flag.Duration("duration", 0, "Duration of the test (e.g., '60s', '5m'). Incompatible with -requests.")
-	method := flag.String("method", "GET", "HTTP method to use (e.g., GET, POST).")
-	body := flag.String("body", "", "Request body for POST, PUT, etc. Incompatible with -body-file.")
-	bodyFile := flag.String("body-file", "", "Path to a file containing the request body. Incompatible with -body.")
-	outputFile := flag.String("output", "", "Path to save the summary report as a JSON file.")
-	var headers customHeaders
-	flag.Var(&headers, "header", "Custom header(s) to send with requests (can be specified multiple times). Format: 'Key:Value'")
+// computeRPSStats derives per-second throughput figures from the timeline. The
+// series covers every second of the run, including seconds with no completions;
+// min, max and standard deviation only consider complete seconds, since the final
+// partial second would otherwise always look like a dip.
+func computeRPSStats(timeline []*TimelineInterval, elapsed float64) *RPSStats {
+	seconds := int(math.Ceil(elapsed))
+	if seconds < len(timeline) {
+		seconds = len(timeline)
+	}
+	if seconds == 0 {
+		return nil
+	}
 
-	flag.Parse()
+	series := make([]int, seconds)
+	for _, interval := range timeline {
+		series[interval.Second] = interval.Requests
+	}
 
-	// --- Input Validation ---
-	if *url == "" {
-		fmt.Println("Error: -url is required.")
-		flag.Usage()
-		os.Exit(1)
+	complete := series
+	if full := int(elapsed); full > 0 && full < len(series) {
+		complete = series[:full]
+	}
+	rates := make([]float64, len(complete))
+	for i, count := range complete {
+		rates[i] = float64(count)
 	}
+	sorted := make([]float64, len(rates))
+	copy(sorted, rates)
+	sort.Float64s(sorted)
 
-	// Prepend https:// if no scheme is provided
-	if !strings.HasPrefix(*url, "http://") && !strings.HasPrefix(*url, "https://") {
-		*url = "https://" + *url
+	return &RPSStats{
+		Min:    min(rates),
+		Max:    max(rates),
+		StdDev: stdDev(rates),
+		P10:    percentile(sorted, 10),
+		P50:    percentile(sorted, 50),
+		P90:    percentile(sorted, 90),
+		Series: series,
 	}
+}
 
-	if *requests > 0 && *duration > 0 {
-		fmt.Println("Error: -requests and -duration are mutually exclusive. Please choose one.")
-		os.Exit(1)
+// sparkline renders values as a compact bar chart, averaging adjacent values
+// together when there are more of them than width.
+func sparkline(values []int, width int) string {
+	if len(values) == 0 {
+		return ""
 	}
-	if *requests == 0 && *duration == 0 {
-		fmt.Println("Error: Either -requests or -duration must be specified.")
-		os.Exit(1)
+	if len(values) > width {
+		condensed := make([]int, width)
+		for i := range condensed {
+			lo, hi := i*len(values)/width, (i+1)*len(values)/width
+			sum := 0
+			for _, v := range values[lo:hi] {
+				sum += v
+			}
+			condensed[i] = sum / (hi - lo)
+		}
+		values = condensed
 	}
 
-	// --- Setup Context for Graceful Shutdown ---
-	ctx, cancel := context.WithCancel(context.Background())
-	if *duration > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), *duration)
+	ticks := []rune("▁▂▃▄▅▆▇█")
+	maxVal := 0
+	for _, v := range values {
+		if v > maxVal {
+			maxVal = v
+		}
 	}
-	defer cancel()
+	var sb strings.Builder
+	for _, v := range values {
+		idx := 0
+		if maxVal > 0 {
+			idx = v * (len(ticks) - 1) / maxVal
+		}
+		sb.WriteRune(ticks[idx])
+	}
+	return sb.String()
+}
 
-	// Listen for interrupt signals (Ctrl+C)
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		fmt.Printf("\n%sInterrupt signal received. Shutting down gracefully...%s\n", ColorYellow, ColorReset)
-		cancel()
-	}()
+// labelValueEscaper escapes a label value for the OpenMetrics text format, which
+// allows only these three escapes; Go's %q would add others, such as \t or \u,
+// that parsers reject.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
 
-	// --- Test Execution ---
-	var requestBody string
-	if *body != "" && *bodyFile != "" {
-		fmt.Println("Error: -body and -body-file are mutually exclusive. Please choose one.")
-		os.Exit(1)
-	} else if *bodyFile != "" {
-		bodyBytes, err := ioutil.ReadFile(*bodyFile)
-		if err != nil {
-			fmt.Printf("Error reading body file: %v\n", err)
-			os.Exit(1)
+// writeOpenMetrics renders the summary in the OpenMetrics text exposition format.
+func writeOpenMetrics(w io.Writer, summary *Summary) {
+	metricFamily := func(name, kind, help string) {
+		fmt.Fprintf(w, "# TYPE %s %s\n# HELP %s %s\n", name, kind, name, help)
+	}
+	// labelSet renders the run's -label pairs followed by any sample-specific pairs.
+	labelSet := func(extra ...string) string {
+		keys := make([]string, 0, len(summary.Metadata.Labels))
+		for key := range summary.Metadata.Labels {
+			keys = append(keys, key)
 		}
-		requestBody = string(bodyBytes)
-	} else {
-		requestBody = *body
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys)+len(extra))
+		for _, key := range keys {
+			pairs = append(pairs, key+`="`+labelValueEscaper.Replace(summary.Metadata.Labels[key])+`"`)
+		}
+		pairs = append(pairs, extra...)
+		if len(pairs) == 0 {
+			return ""
+		}
+		return "{" + strings.Join(pairs, ",") + "}"
+	}
+	formatFloat := func(v float64) string {
+		if math.IsInf(v, 1) {
+			return "+Inf"
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
 	}
 
-	client := &http.Client{
-		Timeout: 60 * time.Second,
+	metricFamily("httptest_requests", "counter", "Total number of requests sent.")
+	fmt.Fprintf(w, "httptest_requests_total%s %d\n", labelSet(), summary.TotalRequestsSent)
+	metricFamily("httptest_requests_successful", "counter", "Number of requests that received a 2xx response.")
+	fmt.Fprintf(w, "httptest_requests_successful_total%s %d\n", labelSet(), summary.SuccessfulRequests)
+	metricFamily("httptest_requests_failed", "counter", "Number of requests that failed or received a non-2xx response.")
+	fmt.Fprintf(w, "httptest_requests_failed_total%s %d\n", labelSet(), summary.FailedRequests)
+
+	codes := make([]int, 0, len(summary.StatusCodeDist))
+	for code := range summary.StatusCodeDist {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	metricFamily("httptest_responses", "counter", "Responses by HTTP status code; code 0 counts client-side errors.")
+	for _, code := range codes {
+		fmt.Fprintf(w, "httptest_responses_total%s %d\n", labelSet(fmt.Sprintf("code=\"%d\"", code)), summary.StatusCodeDist[code])
 	}
 
-	startTime := time.Now()
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, *concurrency)
+	metricFamily("httptest_run_duration_seconds", "gauge", "Total time taken by the run.")
+	fmt.Fprintf(w, "httptest_run_duration_seconds%s %s\n", labelSet(), formatFloat(summary.TotalTimeTaken))
+	metricFamily("httptest_requests_per_second", "gauge", "Average request throughput over the run.")
+	fmt.Fprintf(w, "httptest_requests_per_second%s %s\n", labelSet(), formatFloat(summary.RequestsPerSecond))
 
-	go printLiveMetrics(ctx, startTime, *requests)
+	metricFamily("httptest_response_time_quantile_seconds", "gauge", "Response time percentiles.")
+	fmt.Fprintf(w, "httptest_response_time_quantile_seconds%s %s\n", labelSet(`quantile="0.9"`), formatFloat(summary.Percentile90))
+	fmt.Fprintf(w, "httptest_response_time_quantile_seconds%s %s\n", labelSet(`quantile="0.99"`), formatFloat(summary.Percentile99))
+	metricFamily("httptest_response_time_min_seconds", "gauge", "Fastest response time.")
+	fmt.Fprintf(w, "httptest_response_time_min_seconds%s %s\n", labelSet(), formatFloat(summary.MinResponseTime))
+	metricFamily("httptest_response_time_max_seconds", "gauge", "Slowest response time.")
+	fmt.Fprintf(w, "httptest_response_time_max_seconds%s %s\n", labelSet(), formatFloat(summary.MaxResponseTime))
 
-	worker := func() {
-		defer wg.Done()
-		defer func() { <-semaphore }()
-		sendRequest(ctx, client, *method, *url, &headers, requestBody)
+	metricFamily("httptest_response_time_seconds", "histogram", "Distribution of response times.")
+	cumulative := 0
+	for _, bucket := range summary.Histogram {
+		cumulative += bucket.Count
+		fmt.Fprintf(w, "httptest_response_time_seconds_bucket%s %d\n", labelSet(fmt.Sprintf("le=\"%s\"", formatFloat(bucket.Mark))), cumulative)
 	}
+	fmt.Fprintf(w, "httptest_response_time_seconds_count%s %d\n", labelSet(), cumulative)
+	fmt.Fprintf(w, "httptest_response_time_seconds_sum%s %s\n", labelSet(), formatFloat(summary.AvgResponseTime*float64(cumulative)))
 
-	if *requests > 0 { // Fixed number of requests
-		for i := 0; i < *requests; i++ {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				wg.Add(1)
-				semaphore <- struct{}{}
-				go worker()
-			}
-		}
-	} else { // Duration-based test
-		for {
-			select {
-			case <-ctx.Done():
-				wg.Wait()
-				printSummary(startTime, *outputFile)
-				return
-			default:
-				wg.Add(1)
-				semaphore <- struct{}{}
-				go worker()
-			}
-		}
-	}
+	fmt.Fprintln(w, "# EOF")
+}
 
-	wg.Wait()
-	printSummary(startTime, *outputFile)
+// latencyUnit is a custom flag type selecting how latencies are rendered on the console.
+type latencyUnit string
+
+func (u *latencyUnit) String() string {
+	return string(*u)
 }
 
-func sendRequest(ctx context.Context, client *http.Client, method, url string, headers *customHeaders, body string) {
-	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
-	if err != nil {
-		metrics.Lock.Lock()
-		metrics.FailureCount++
-		metrics.ErrorLog = append(metrics.ErrorLog, fmt.Sprintf("error creating request: %v", err))
-		metrics.Lock.Unlock()
-		return
+func (u *latencyUnit) Set(value string) error {
+	switch value {
+	case "s", "ms", "us", "auto":
+		*u = latencyUnit(value)
+		return nil
 	}
+	return fmt.Errorf("expected s, ms, us or auto, got %q", value)
+}
 
-	req.Header.Set("User-Agent", "httptest-load-tester/1.0")
-	for _, h := range *headers {
-		parts := strings.SplitN(h, ":", 2)
-		if len(parts) == 2 {
-			req.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+// format renders a latency given in seconds in the unit, with a suffix. The auto
+// unit picks microseconds below 1ms, milliseconds below 1s and seconds otherwise.
+func (u latencyUnit) format(seconds float64) string {
+	unit := u
+	if unit == "auto" {
+		switch {
+		case seconds < 0.001:
+			unit = "us"
+		case seconds < 1:
+			unit = "ms"
+		default:
+			unit = "s"
 		}
 	}
+	switch unit {
+	case "ms":
+		return fmt.Sprintf("%.2fms", seconds*1e3)
+	case "us":
+		return fmt.Sprintf("%.0fµs", seconds*1e6)
+	default:
+		return fmt.Sprintf("%.4fs", seconds)
+	}
+}
 
-	startTime := time.Now()
-	resp, err := client.Do(req)
-	elapsedTime := time.Since(startTime).Seconds()
-
+// writeRawLatencies writes every response time the run recorded, in seconds and
+// sorted, one per line or as a little-endian float64 array. These are the times as
+// measured, before any -clamp-max-latency ceiling.
+// Every time is kept in memory anyway, so this costs nothing extra; with
+// -max-latency-samples they are not kept, so the two are not combined.
+func writeRawLatencies(path string, binaryFormat bool) error {
 	metrics.Lock.Lock()
-	defer metrics.Lock.Unlock()
+	times := make([]float64, len(metrics.ResponseTimes))
+	copy(times, metrics.ResponseTimes)
+	metrics.Lock.Unlock()
+	sort.Float64s(times)
 
-	metrics.ResponseTimes = append(metrics.ResponseTimes, elapsedTime)
-
-	for _, bucket := range metrics.Histogram {
-		if elapsedTime <= bucket.Mark {
-			bucket.Count++
-			break
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if binaryFormat {
+		err = binary.Write(w, binary.LittleEndian, times)
+	} else {
+		for _, t := range times {
+			w.WriteString(strconv.FormatFloat(t, 'f', -1, 64))
+			w.WriteByte('\n')
 		}
 	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
 
+// writeTimeseriesCSV writes one row per -timeseries-csv step of the run timeline,
+// with the requests that completed in that step and their latency percentiles.
+// Closed rows use the percentiles computed as they closed; only the last, still
+// open row is computed from the times kept for it.
+func writeTimeseriesCSV(path string) error {
+	file, err := os.Create(path)
 	if err != nil {
-		metrics.FailureCount++
-		metrics.StatusCodeCount[0]++ // Representing client-side errors
-		if len(metrics.ErrorLog) < 100 {
-			metrics.ErrorLog = append(metrics.ErrorLog, err.Error())
+		return err
+	}
+
+	metrics.Lock.Lock()
+	defer metrics.Lock.Unlock()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"elapsed_seconds", "rps", "success", "failure", "p50", "p90", "p99", "avg", "in_flight"})
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+	step := metrics.TimeseriesStep
+	for row, start := 0, 0; start < len(metrics.Timeline); row, start = row+1, start+step {
+		bucket := metrics.Timeline[start:]
+		if len(bucket) > step {
+			bucket = bucket[:step]
 		}
-	} else {
-		defer resp.Body.Close()
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			metrics.SuccessCount++
+		var requests, failures int
+		merged := TimelineInterval{}
+		for _, second := range bucket {
+			requests += second.Requests
+			failures += second.Failures
+			merged.inFlightSum += second.inFlightSum
+			merged.inFlightSamples += second.inFlightSamples
+		}
+		var latency *TimingStats
+		if row < len(metrics.TimeseriesRows) {
+			latency = metrics.TimeseriesRows[row]
 		} else {
-			metrics.FailureCount++
+			latency = newTimingStats(intervalTimes(bucket))
 		}
-		metrics.StatusCodeCount[resp.StatusCode]++
+		w.Write([]string{
+			strconv.Itoa(start + len(bucket)),
+			formatFloat(float64(requests) / float64(len(bucket))),
+			strconv.Itoa(requests - failures),
+			strconv.Itoa(failures),
+			formatFloat(latency.P50),
+			formatFloat(latency.P90),
+			formatFloat(latency.P99),
+			formatFloat(latency.Avg),
+			strconv.Itoa(merged.avgInFlight()),
+		})
 	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
-func printLiveMetrics(ctx context.Context, startTime time.Time, totalRequests int) {
-	spinner := []string{"|", "/", "-", "\\"}
-	spinIdx := 0
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Print("")
-			return
-		case <-ticker.C:
-			metrics.Lock.Lock()
-			sent := metrics.SuccessCount + metrics.FailureCount
-			elapsedTime := time.Since(startTime).Seconds()
-
-			displayTotal := "/" + fmt.Sprint(totalRequests)
-			if totalRequests == 0 {
-				displayTotal = ""
-			}
-
-			avg := "N/A"
-			p99 := "N/A"
-
-			timesCopy := make([]float64, len(metrics.ResponseTimes))
-			copy(timesCopy, metrics.ResponseTimes)
-
-			if len(timesCopy) > 0 {
-				sort.Float64s(timesCopy)
-				avg = fmt.Sprintf("%.4fs", average(timesCopy))
-				p99 = fmt.Sprintf("%.4fs", percentile(timesCopy, 99))
-			}
-
-			fmt.Printf("\r%s%s Requests Sent: %d%s | %sSuccess: %d%s | %sFailures: %d%s | Avg Resp: %s | 99th Pctl: %s | Elapsed: %.2fs%s ",
-				ColorCyan, spinner[spinIdx], sent, displayTotal, ColorGreen, metrics.SuccessCount, ColorReset, ColorRed, metrics.FailureCount, ColorReset, avg, p99, elapsedTime, ColorReset)
-			metrics.Lock.Unlock()
+// printServerTiming prints the durations servers reported in Server-Timing next
+// to the measured latency, so time spent in the server can be told apart from
+// time spent on the network.
+func printServerTiming(summary *Summary, unit latencyUnit) {
+	fmt.Printf("\n%sServer-Timing (reported by the server)%s\n%s--------------------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	fmt.Printf("%-24s : avg %s, p99 %s\n", "measured latency", unit.format(summary.AvgResponseTime), unit.format(summary.Percentile99))
+	names := make([]string, 0, len(summary.ServerTiming))
+	for name := range summary.ServerTiming {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stats := summary.ServerTiming[name]
+		fmt.Printf("%-24s : avg %s, p50 %s, p99 %s, max %s\n", name, unit.format(stats.Avg), unit.format(stats.P50), unit.format(stats.P99), unit.format(stats.Max))
+	}
+	if len(names) >= maxServerTimingNames {
+		fmt.Printf("%sOnly the first %d metric names are tracked; later ones were ignored.%s\n", ColorYellow, maxServerTimingNames, ColorReset)
+	}
+}
 
-			spinIdx = (spinIdx + 1) % len(spinner)
+func printCacheStats(c *CacheStats) {
+	share := func(n int64) float64 {
+		if c.Responses == 0 {
+			return 0
 		}
+		return float64(n) / float64(c.Responses) * 100
+	}
+	fmt.Printf("\n%sCache Headers%s\n%s-------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	fmt.Printf("With Cache-Control       : %d (%.2f%%)\n", c.WithCacheControl, share(c.WithCacheControl))
+	fmt.Printf("no-store                 : %d (%.2f%%)\n", c.NoStore, share(c.NoStore))
+	fmt.Printf("no-cache                 : %d (%.2f%%)\n", c.NoCache, share(c.NoCache))
+	fmt.Printf("private                  : %d (%.2f%%)\n", c.Private, share(c.Private))
+	values := make([]string, 0, len(c.MaxAge))
+	for value := range c.MaxAge {
+		values = append(values, value)
+	}
+	// Numeric order, with anything unparseable last.
+	sort.Slice(values, func(i, j int) bool {
+		a, errA := strconv.Atoi(values[i])
+		b, errB := strconv.Atoi(values[j])
+		if errA != nil || errB != nil {
+			return errA == nil || (errB != nil && values[i] < values[j])
+		}
+		return a < b
+	})
+	for _, value := range values {
+		fmt.Printf("max-age=%-16s : %d responses\n", value, c.MaxAge[value])
 	}
 }
 
-func printSummary(startTime time.Time, outputFile string) {
-	metrics.Lock.Lock()
-	defer metrics.Lock.Unlock()
+// requestIDPrefix matches the "[request-id] " that logError puts before a message.
+var requestIDPrefix = regexp.MustCompile(`^\[[^\]]*\] `)
 
-	elapsedTime := time.Since(startTime).Seconds()
-	totalRequests := metrics.SuccessCount + metrics.FailureCount
-	if totalRequests == 0 {
-		fmt.Println("\nNo requests were sent.")
-		return
+// printTopErrors prints the n most frequent messages in errorLog, so the cause of
+// a run where nothing succeeded is visible at the top of the report.
+func printTopErrors(errorLog []string, n int) {
+	counts := make(map[string]int)
+	for _, message := range errorLog {
+		counts[requestIDPrefix.ReplaceAllString(message, "")]++
+	}
+	messages := make([]string, 0, len(counts))
+	for message := range counts {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if counts[messages[i]] != counts[messages[j]] {
+			return counts[messages[i]] > counts[messages[j]]
+		}
+		return messages[i] < messages[j]
+	})
+	if len(messages) > n {
+		messages = messages[:n]
 	}
+	fmt.Printf("Most common errors (of the first %d logged):\n", len(errorLog))
+	for _, message := range messages {
+		fmt.Printf("%s  %4dx %s%s\n", ColorRed, counts[message], message, ColorReset)
+	}
+}
 
-	finalResponseTimes := make([]float64, len(metrics.ResponseTimes))
-	copy(finalResponseTimes, metrics.ResponseTimes)
-	sort.Float64s(finalResponseTimes)
+// formatBytes renders a byte count with a binary unit, e.g. "1.50 MiB".
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.2f %s", n, units[i])
+}
 
-	avgResponse := average(finalResponseTimes)
-	minResponse := min(finalResponseTimes)
-	maxResponse := max(finalResponseTimes)
-	p90 := percentile(finalResponseTimes, 90)
-	p99 := percentile(finalResponseTimes, 99)
+// maxBodySize is the largest body -body-size will generate.
+const maxBodySize = 1 << 30
 
-	summary := Summary{
-		TotalRequestsSent:   totalRequests,
-		SuccessfulRequests:  metrics.SuccessCount,
-		FailedRequests:      metrics.FailureCount,
-		SuccessRate:         (float64(metrics.SuccessCount) / float64(totalRequests)) * 100,
-		FailureRate:         (float64(metrics.FailureCount) / float64(totalRequests)) * 100,
-		TotalTimeTaken:      elapsedTime,
-				RequestsPerSecond:   0.00,
-		AvgResponseTime:     avgResponse,
-		MinResponseTime:     minResponse,
-		MaxResponseTime:     maxResponse,
-		Percentile90:        p90,
-		Percentile99:        p99,
-		StatusCodeDist:      metrics.StatusCodeCount,
-		Histogram:           metrics.Histogram,
-		ErrorSummary:        metrics.ErrorLog,
+// parseByteSize parses a size such as "512", "1KB" or "5MiB" into bytes. Units are
+// binary whether or not they are written with the i, so 1KB is 1024 bytes.
+func parseByteSize(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	number := strings.TrimRight(upper, "KMGIB")
+	multiplier := int64(1)
+	switch strings.TrimSpace(strings.TrimPrefix(upper, number)) {
+	case "", "B":
+	case "K", "KB", "KIB":
+		multiplier = 1 << 10
+	case "M", "MB", "MIB":
+		multiplier = 1 << 20
+	case "G", "GB", "GIB":
+		multiplier = 1 << 30
+	default:
+		return 0, fmt.Errorf("unknown unit in %q; use B, KB, MB or GB", s)
 	}
-	if elapsedTime > 0 {
-		summary.RequestsPerSecond = float64(totalRequests) / elapsedTime
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size such as 512, 1KB or 5MB", s)
 	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("%q is too large", s)
+	}
+	return n * multiplier, nil
+}
 
-	// --- Console Output ---
-	fmt.Printf("\n\n%sLoad Test Summary%s\n%s==================%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
-	fmt.Printf("Total Requests Sent      : %s%d%s\n", ColorCyan, summary.TotalRequestsSent, ColorReset)
-	fmt.Printf("Successful Requests      : %s%d%s\n", ColorGreen, summary.SuccessfulRequests, ColorReset)
-	fmt.Printf("Failed Requests          : %s%d%s\n", ColorRed, summary.FailedRequests, ColorReset)
-	fmt.Printf("Success Rate             : %s%.2f%%%s\n", ColorGreen, summary.SuccessRate, ColorReset)
-	fmt.Printf("Failure Rate             : %s%.2f%%%s\n", ColorRed, summary.FailureRate, ColorReset)
-	fmt.Printf("Total Time Taken         : %.2f seconds\n", summary.TotalTimeTaken)
-	fmt.Printf("Requests per Second      : %.2f\n", summary.RequestsPerSecond)
-
-	fmt.Printf("\n%sResponse Time Metrics (seconds)%s\n%s--------------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
-	fmt.Printf("Average Response Time    : %s%.4f%s\n", ColorCyan, summary.AvgResponseTime, ColorReset)
-	fmt.Printf("90th Percentile          : %.4f\n", summary.Percentile90)
-	fmt.Printf("99th Percentile          : %.4f\n", summary.Percentile99)
-	fmt.Printf("Minimum Response Time    : %.4f\n", summary.MinResponseTime)
-	fmt.Printf("Maximum Response Time    : %.4f\n", summary.MaxResponseTime)
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	printHistogram(summary.Histogram)
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
 
-	fmt.Printf("\n%sStatus Code Distribution%s\n%s------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
-	for code, count := range summary.StatusCodeDist {
-		color := ColorGreen
-		if code == 0 || code >= 400 {
-			color = ColorRed
-		}
-		if code == 0 {
-			fmt.Printf("Client-Side Errors : %s%d responses%s\n", color, count, ColorReset)
-		} else {
-			fmt.Printf("Status Code %-7d : %s%d responses%s\n", code, color, count, ColorReset)
-		}
+// terminalWidth returns the width of the terminal attached to stdout, falling back
+// to $COLUMNS and then to 80 columns when it cannot be determined.
+func terminalWidth() int {
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		return width
 	}
-
-	if len(summary.ErrorSummary) > 0 {
-		fmt.Printf("\n%sError Summary (first 100)%s\n%s--------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
-		limit := 100
-		if len(summary.ErrorSummary) < limit {
-			limit = len(summary.ErrorSummary)
-		}
-		for i, err := range summary.ErrorSummary[:limit] {
-			fmt.Printf("%s%d. %s%s\n", ColorRed, i+1, err, ColorReset)
-		}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
 	}
+	return 80
+}
 
-	// --- JSON File Output ---
-	if outputFile != "" {
-		jsonData, err := json.MarshalIndent(summary, "", "  ")
-		if err != nil {
-			fmt.Printf("\nError marshalling summary to JSON: %v\n", err)
-			return
-		}
-		err = ioutil.WriteFile(outputFile, jsonData, 0644)
-		if err != nil {
-			fmt.Printf("\nError writing summary to file '%s': %v\n", outputFile, err)
-			return
-		}
-		fmt.Printf("\nSummary report saved to %s\n", outputFile)
+// chartWidth returns how many columns a bar or chart can use in a terminal of the
+// given width once the reserved label columns are taken, within sensible bounds.
+func chartWidth(termWidth, reserved int) int {
+	const minBar, maxBar = 10, 100
+	width := termWidth - reserved
+	if width < minBar {
+		return minBar
+	}
+	if width > maxBar {
+		return maxBar
 	}
+	return width
 }
 
-func printHistogram(histogram []*HistogramBucket) {
+func printHistogram(histogram []*HistogramBucket, barWidth int) {
 	fmt.Printf("\n%sResponse Time Distribution%s\n%s------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
-	maxCount := 0
+	maxCount, total := 0, 0
 	for _, bucket := range histogram {
 		if bucket.Count > maxCount {
 			maxCount = bucket.Count
 		}
+		total += bucket.Count
 	}
 
 	var lastMark float64
+	cumulative := 0
 	for _, bucket := range histogram {
-		bar := ""
+		barLen := 0
 		if maxCount > 0 {
-			bar = strings.Repeat("▇", (bucket.Count*40)/maxCount)
+			barLen = (bucket.Count * barWidth) / maxCount
+		}
+		// Pad the bar to full width so the percentage columns line up.
+		bar := strings.Repeat("▇", barLen) + strings.Repeat(" ", barWidth-barLen)
+		cumulative += bucket.Count
+		var share, cumulativeShare float64
+		if total > 0 {
+			share = float64(bucket.Count) / float64(total) * 100
+			cumulativeShare = float64(cumulative) / float64(total) * 100
 		}
 
+		label := fmt.Sprintf("%.2f-%.2fs", lastMark, bucket.Mark)
 		if math.IsInf(bucket.Mark, 1) {
-			fmt.Printf("[%s%.2fs+ %s] %s (%d)%s\n", ColorCyan, lastMark, ColorReset, bar, bucket.Count, ColorReset)
-		} else {
-			fmt.Printf("[%s%.2f-%.2fs%s] %s (%d)%s\n", ColorCyan, lastMark, bucket.Mark, ColorReset, bar, bucket.Count, ColorReset)
+			label = fmt.Sprintf("%.2fs+", lastMark)
 		}
+		fmt.Printf("[%s%-11s%s] %s %8s %5.1f%% cum %5.1f%%%s\n", ColorCyan, label, ColorReset, bar, fmt.Sprintf("(%d)", bucket.Count), share, cumulativeShare, ColorReset)
 		lastMark = bucket.Mark
 	}
+	fmt.Printf("Total: %d responses\n", total)
+}
+
+// clampHistogram moves the counts of buckets entirely above ceiling into the bucket
+// that holds it, as if every time had been capped at ceiling.
+func clampHistogram(buckets []*HistogramBucket, ceiling float64) {
+	for i, bucket := range buckets {
+		if bucket.Mark < ceiling {
+			continue
+		}
+		for _, above := range buckets[i+1:] {
+			bucket.Count += above.Count
+			above.Count = 0
+		}
+		return
+	}
+}
+
+// computeCDF reads the latency at each of cdfFractions from sorted response times,
+// using the same rule as percentile so the points agree with the p90/p99 lines.
+func computeCDF(sortedTimes []float64) []CDFPoint {
+	points := make([]CDFPoint, len(cdfFractions))
+	for i, fraction := range cdfFractions {
+		points[i] = CDFPoint{Fraction: fraction, Latency: percentile(sortedTimes, fraction)}
+	}
+	return points
+}
+
+func printCDF(points []CDFPoint, unit latencyUnit) {
+	fmt.Printf("\n%sCumulative Distribution%s\n%s-----------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	for _, point := range points {
+		label := strconv.FormatFloat(point.Fraction, 'f', -1, 64) + "%"
+		fmt.Printf("%7s of requests <= %s%s%s\n", label, ColorCyan, unit.format(point.Latency), ColorReset)
+	}
+}
+
+func printSLOBudget(budget *SLOBudget, cfg *Config) {
+	fmt.Printf("\n%sSLO Budget%s\n%s----------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	if cfg.SLOP99 > 0 {
+		fmt.Printf("Latency SLO (p99)        : %.4f seconds\n", cfg.SLOP99)
+		fmt.Printf("Requests Over SLO        : %d (%.2f%%)\n", budget.LatencyBreaches, budget.LatencyBreachRate)
+		fmt.Printf("Latency Budget Consumed  : %s%.2f%%%s\n", budgetColor(budget.LatencyBudgetConsumed), budget.LatencyBudgetConsumed, ColorReset)
+	}
+	if cfg.SLOErrorRate > 0 {
+		fmt.Printf("Error Rate SLO           : %.2f%%\n", cfg.SLOErrorRate)
+		fmt.Printf("Error Budget Consumed    : %s%.2f%%%s\n", budgetColor(budget.ErrorBudgetConsumed), budget.ErrorBudgetConsumed, ColorReset)
+	}
+	fmt.Printf("Budget Remaining         : %s%.2f%%%s\n", budgetColor(100-budget.BudgetRemaining), budget.BudgetRemaining, ColorReset)
+}
+
+func budgetColor(consumed float64) string {
+	if consumed >= 100 {
+		return ColorRed
+	}
+	if consumed >= 75 {
+		return ColorYellow
+	}
+	return ColorGreen
+}
+
+// computeSLOBudget calculates budget consumption from the number of timed responses
+// that exceeded sloP99 and the request counts observed so far. A p99 latency SLO
+// allows 1% of requests to exceed the threshold; an error-rate SLO allows the given
+// percentage of requests to fail.
+func computeSLOBudget(breaches, timed, total, failures int64, sloP99, sloErrorRate float64) *SLOBudget {
+	budget := &SLOBudget{}
+	if total <= 0 {
+		budget.BudgetRemaining = 100
+		return budget
+	}
+
+	var consumed float64
+	if sloP99 > 0 && timed > 0 {
+		budget.LatencyBreaches = breaches
+		budget.LatencyBreachRate = float64(breaches) / float64(timed) * 100
+		budget.LatencyBudgetConsumed = budget.LatencyBreachRate / (100 - 99) * 100
+		consumed = budget.LatencyBudgetConsumed
+	}
+	if sloErrorRate > 0 {
+		errorRate := float64(failures) / float64(total) * 100
+		budget.ErrorBudgetConsumed = errorRate / sloErrorRate * 100
+		consumed = math.Max(consumed, budget.ErrorBudgetConsumed)
+	}
+
+	budget.BudgetRemaining = math.Max(0, 100-consumed)
+	return budget
+}
+
+// countAbove returns how many of the sorted times exceed limit.
+func countAbove(sortedTimes []float64, limit float64) int64 {
+	within := sort.Search(len(sortedTimes), func(i int) bool { return sortedTimes[i] > limit })
+	return int64(len(sortedTimes) - within)
 }
 
 func average(data []float64) float64 {
@@ -474,6 +4415,18 @@ func max(data []float64) float64 {
 	return maxVal
 }
 
+func stdDev(data []float64) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	mean := average(data)
+	sumSquares := 0.0
+	for _, value := range data {
+		sumSquares += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(sumSquares / float64(len(data)))
+}
+
 func percentile(data []float64, p float64) float64 {
 	if len(data) == 0 {
 		return 0
@@ -0,0 +1,59 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// dialSocketOptions dials a local listener through newTransport and returns the
+// TCP_NODELAY, SO_KEEPALIVE and TCP_KEEPINTVL options of the resulting socket.
+func dialSocketOptions(t *testing.T, cfg *Config) (nodelay, keepalive, interval int) {
+	t.Helper()
+	initializeMetrics()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := newTransport(cfg, "").DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw, err := conn.(*trackedConn).Conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		if nodelay, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY); sockErr != nil {
+			return
+		}
+		if keepalive, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); sockErr != nil {
+			return
+		}
+		interval, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL)
+	})
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return nodelay, keepalive, interval
+}
+
+func TestDialerSocketOptions(t *testing.T) {
+	nodelay, keepalive, interval := dialSocketOptions(t, &Config{TCPNoDelay: true, TCPKeepAlive: 42 * time.Second})
+	if nodelay != 1 || keepalive != 1 || interval != 42 {
+		t.Errorf("nodelay=%d keepalive=%d interval=%ds, want 1, 1 and 42s", nodelay, keepalive, interval)
+	}
+
+	nodelay, keepalive, _ = dialSocketOptions(t, &Config{TCPNoDelay: false, TCPKeepAlive: -1})
+	if nodelay != 0 || keepalive != 0 {
+		t.Errorf("nodelay=%d keepalive=%d, want both off", nodelay, keepalive)
+	}
+}
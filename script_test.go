@@ -0,0 +1,2525 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain lets tests run the tool itself: when HTTPTEST_ARGS holds a JSON list
+// of arguments, the test binary runs main with them instead of the tests.
+func TestMain(m *testing.M) {
+	if encoded, ok := os.LookupEnv("HTTPTEST_ARGS"); ok {
+		var args []string
+		if err := json.Unmarshal([]byte(encoded), &args); err != nil {
+			panic(err)
+		}
+		os.Args = append([]string{"httptest"}, args...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// toolCommand returns a command that runs the tool with args in a child process.
+func toolCommand(t *testing.T, args ...string) *exec.Cmd {
+	t.Helper()
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "HTTPTEST_ARGS="+string(encoded))
+	return cmd
+}
+
+// runTool runs the tool with args in a child process and returns its combined
+// output and exit code.
+func runTool(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	out, err := toolCommand(t, args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("running the tool: %v", err)
+	}
+	return string(out), 0
+}
+
+// runSummary runs the tool with args plus -output and returns the JSON summary
+// it saved, along with its output.
+func runSummary(t *testing.T, args ...string) (*Summary, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "summary.json")
+	out, code := runTool(t, append(args, "-output", path)...)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("no summary written (exit code %d): %v\n%s", code, err, out)
+	}
+	var summary Summary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("decoding summary: %v", err)
+	}
+	return &summary, out
+}
+
+// recordingServer is a test server that keeps every request body it receives.
+type recordingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// approxEqual reports whether a and b agree to within a relative tolerance.
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestComputeSLOBudget(t *testing.T) {
+	// 200 requests so far: one failure, and 1 of the 199 timed responses over
+	// the 100ms p99 target.
+	times := make([]float64, 199)
+	for i := range times {
+		times[i] = 0.05
+	}
+	times[198] = 0.3
+
+	budget := computeSLOBudget(countAbove(times, 0.1), int64(len(times)), 200, 1, 0.1, 1)
+	if budget.LatencyBreaches != 1 {
+		t.Errorf("LatencyBreaches = %d, want 1", budget.LatencyBreaches)
+	}
+	if want := 100.0 / 199 * 100; !approxEqual(budget.LatencyBudgetConsumed, want) {
+		t.Errorf("LatencyBudgetConsumed = %v, want %v", budget.LatencyBudgetConsumed, want)
+	}
+	// A 0.5% error rate against a 1% SLO uses half the error budget.
+	if !approxEqual(budget.ErrorBudgetConsumed, 50) {
+		t.Errorf("ErrorBudgetConsumed = %v, want 50", budget.ErrorBudgetConsumed)
+	}
+	if want := 100 - 100.0/199*100; !approxEqual(budget.BudgetRemaining, want) {
+		t.Errorf("BudgetRemaining = %v, want %v", budget.BudgetRemaining, want)
+	}
+
+	// Breaching more than 1% of requests exhausts the latency budget.
+	times[197] = 0.2
+	times[196] = 0.2
+	if budget := computeSLOBudget(countAbove(times, 0.1), int64(len(times)), 200, 0, 0.1, 0); budget.BudgetRemaining != 0 {
+		t.Errorf("BudgetRemaining = %v after 3 breaches, want 0", budget.BudgetRemaining)
+	}
+}
+
+func TestComputeSLOBudgetWithoutRequests(t *testing.T) {
+	if budget := computeSLOBudget(0, 0, 0, 0, 0.1, 1); budget.BudgetRemaining != 100 {
+		t.Errorf("BudgetRemaining = %v, want 100", budget.BudgetRemaining)
+	}
+}
+
+func TestHistogramBucketJSON(t *testing.T) {
+	buckets := []*HistogramBucket{{Mark: 0.1, Count: 3}, {Mark: math.Inf(1), Count: 1}}
+	data, err := json.Marshal(buckets)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if want := `[{"mark":0.1,"count":3},{"mark":null,"count":1}]`; string(data) != want {
+		t.Errorf("JSON = %s, want %s", data, want)
+	}
+	var decoded []*HistogramBucket
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(decoded) != 2 || decoded[0].Mark != 0.1 || !math.IsInf(decoded[1].Mark, 1) || decoded[1].Count != 1 {
+		t.Errorf("decoded %+v %+v, want the original buckets", decoded[0], decoded[1])
+	}
+}
+
+func TestRepeatBody(t *testing.T) {
+	srv := newRecordingServer(t)
+	if out, code := runTool(t, "-url", srv.URL, "-method", "POST", "-body", "abc", "-repeat-body", "4", "-requests", "3"); code != 0 {
+		t.Fatalf("exit code %d\n%s", code, out)
+	}
+	if len(srv.bodies) != 3 {
+		t.Fatalf("server got %d requests, want 3", len(srv.bodies))
+	}
+	for _, body := range srv.bodies {
+		if string(body) != "abcabcabcabc" {
+			t.Errorf("body = %q, want the 3-byte body 4 times", body)
+		}
+	}
+}
+
+func TestChunkedRequest(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		srv := newRecordingServer(t)
+		args := []string{"-url", srv.URL, "-method", "POST", "-body", "hello", "-requests", "2"}
+		if chunked {
+			args = append(args, "-chunked-request")
+		}
+		summary, _ := runSummary(t, args...)
+		for _, r := range srv.requests {
+			isChunked := len(r.TransferEncoding) == 1 && r.TransferEncoding[0] == "chunked"
+			if isChunked != chunked {
+				t.Errorf("chunked=%v: Transfer-Encoding = %v", chunked, r.TransferEncoding)
+			}
+			if !chunked && r.ContentLength != 5 {
+				t.Errorf("Content-Length = %d, want 5", r.ContentLength)
+			}
+		}
+		want := "content-length"
+		if chunked {
+			want = "chunked"
+		}
+		if summary.RequestEncoding != want {
+			t.Errorf("RequestEncoding = %q, want %q", summary.RequestEncoding, want)
+		}
+	}
+}
+
+func TestAbortOnP99(t *testing.T) {
+	// Fast for the first half second, then every response takes 150ms.
+	start := time.Now()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if time.Since(start) > 500*time.Millisecond {
+			time.Sleep(150 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	summary, _ := runSummary(t, "-url", srv.URL, "-duration", "10s", "-concurrency", "4", "-abort-on-p99", "0.1", "-abort-window", "500ms")
+	if !strings.Contains(summary.AbortReason, "exceeded the 0.1000s threshold") {
+		t.Errorf("AbortReason = %q, want the p99 threshold to be reported", summary.AbortReason)
+	}
+	if summary.TotalTimeTaken > 5 {
+		t.Errorf("run took %.1fs; it should have aborted soon after the tail degraded", summary.TotalTimeTaken)
+	}
+}
+
+func TestReportInterval(t *testing.T) {
+	srv := newRecordingServer(t)
+	out, code := runTool(t, "-url", srv.URL, "-duration", "1s", "-concurrency", "1", "-report-interval", "200ms")
+	if code != 0 {
+		t.Fatalf("exit code %d\n%s", code, out)
+	}
+	// Off a terminal each report is a line of its own, and plain text.
+	lines := strings.Count(out, "] Requests Sent: ")
+	if lines < 3 || lines > 6 {
+		t.Errorf("got %d live metrics lines in 1s at a 200ms interval, want about 5", lines)
+	}
+	if strings.Contains(out, "\033[") {
+		t.Error("output to a pipe contains color codes")
+	}
+}
+
+func TestDefaultReportInterval(t *testing.T) {
+	if got := defaultReportInterval(true); got != 100*time.Millisecond {
+		t.Errorf("interactive interval = %v, want 100ms", got)
+	}
+	if got := defaultReportInterval(false); got != 5*time.Second {
+		t.Errorf("non-interactive interval = %v, want 5s", got)
+	}
+}
+
+func TestBodiesDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"n":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"n":2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := newRecordingServer(t)
+	summary, _ := runSummary(t, "-url", srv.URL, "-method", "POST", "-bodies-dir", dir, "-requests", "4", "-concurrency", "1")
+
+	seen := make(map[string]int)
+	for _, body := range srv.bodies {
+		seen[string(body)]++
+	}
+	if seen[`{"n":1}`] != 2 || seen[`{"n":2}`] != 2 {
+		t.Errorf("bodies sent = %v, want each file twice", seen)
+	}
+	if summary.BodyUsage["a.json"] != 2 || summary.BodyUsage["b.json"] != 2 {
+		t.Errorf("BodyUsage = %v, want 2 requests per file", summary.BodyUsage)
+	}
+}
+
+// openMetricsLine matches the lines of the OpenMetrics text format the tool
+// writes: metadata, samples with optional labels, and the closing # EOF.
+var openMetricsLine = regexp.MustCompile(`^(# (TYPE|HELP) [a-z_]+ .+|[a-z_]+(\{[a-z_]+="([^"\\]|\\.)*"(,[a-z_]+="([^"\\]|\\.)*")*\})? [-+0-9.eInf]+)$`)
+
+func TestOpenMetricsOutput(t *testing.T) {
+	srv := newRecordingServer(t)
+	cmd := toolCommand(t, "-url", srv.URL, "-requests", "5", "-openmetrics", "-label", `team=a "quoted" value`)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr.String())
+	}
+
+	// Stdout carries only the metrics; the report goes to stderr.
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if lines[len(lines)-1] != "# EOF" {
+		t.Errorf("last line = %q, want # EOF", lines[len(lines)-1])
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if !openMetricsLine.MatchString(line) {
+			t.Errorf("line is not valid OpenMetrics: %q", line)
+		}
+	}
+	if !strings.Contains(string(out), "httptest_requests_total{team=\"a \\\"quoted\\\" value\"} 5\n") {
+		t.Errorf("request count with the run label missing from:\n%s", out)
+	}
+	if !strings.Contains(stderr.String(), "Load Test Summary") {
+		t.Error("the report was not written to stderr")
+	}
+}
+
+func TestRequestsAndDurationCountFirst(t *testing.T) {
+	srv := newRecordingServer(t)
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "5", "-duration", "10s")
+	if summary.TotalRequestsSent != 5 {
+		t.Errorf("sent %d requests, want 5", summary.TotalRequestsSent)
+	}
+	if summary.TotalTimeTaken > 5 {
+		t.Errorf("run took %.1fs; it should end once 5 requests are sent", summary.TotalTimeTaken)
+	}
+}
+
+func TestRequestsAndDurationTimeFirst(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "100000", "-duration", "500ms", "-concurrency", "1")
+	if summary.TotalRequestsSent >= 100000 || summary.TotalRequestsSent == 0 {
+		t.Errorf("sent %d requests, want the duration to cut the run short", summary.TotalRequestsSent)
+	}
+	if summary.TotalTimeTaken < 0.4 || summary.TotalTimeTaken > 2 {
+		t.Errorf("run took %.2fs, want about the 500ms duration", summary.TotalTimeTaken)
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	// Every request is cut off, so each one shows up in the error log.
+	var mu sync.Mutex
+	var ids []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get("X-Request-ID"))
+		mu.Unlock()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "10", "-concurrency", "1", "-request-id-header", "X-Request-ID")
+
+	mu.Lock()
+	defer mu.Unlock()
+	sent := make(map[string]bool)
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, id := range ids {
+		if !uuid.MatchString(id) {
+			t.Errorf("X-Request-ID = %q, want a UUID", id)
+		}
+		if sent[id] {
+			t.Errorf("X-Request-ID %s was sent twice", id)
+		}
+		sent[id] = true
+	}
+	if len(sent) != 10 {
+		t.Fatalf("server saw %d request IDs, want 10", len(sent))
+	}
+
+	// Each logged error is tagged with the ID its request carried.
+	tagged := regexp.MustCompile(`^\[([^\]]+)\] `)
+	if len(summary.ErrorSummary) != 10 {
+		t.Fatalf("%d errors logged, want 10: %v", len(summary.ErrorSummary), summary.ErrorSummary)
+	}
+	for _, msg := range summary.ErrorSummary {
+		m := tagged.FindStringSubmatch(msg)
+		if m == nil || !sent[m[1]] {
+			t.Errorf("error %q is not tagged with a request ID that was sent", msg)
+		}
+	}
+}
+
+func TestInjectLatency(t *testing.T) {
+	srv := newRecordingServer(t)
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "3", "-inject-latency", "100ms")
+	if summary.MinResponseTime < 0.1 {
+		t.Errorf("fastest response took %.4fs, want at least the injected 100ms", summary.MinResponseTime)
+	}
+}
+
+func TestComputeRPSStats(t *testing.T) {
+	// Bursts of 100 requests every other second, with lulls of 10 between.
+	var timeline []*TimelineInterval
+	for second, requests := range []int{100, 10, 100, 10} {
+		timeline = append(timeline, &TimelineInterval{Second: second, Requests: requests})
+	}
+	stats := computeRPSStats(timeline, 4)
+	if len(stats.Series) != 4 {
+		t.Errorf("series has %d seconds, want 4", len(stats.Series))
+	}
+	if stats.Min != 10 || stats.Max != 100 {
+		t.Errorf("min/max = %v/%v, want 10/100", stats.Min, stats.Max)
+	}
+	if stats.StdDev != 45 {
+		t.Errorf("StdDev = %v, want 45", stats.StdDev)
+	}
+}
+
+func TestRPSSeriesCoversRun(t *testing.T) {
+	srv := newRecordingServer(t)
+	summary, _ := runSummary(t, "-url", srv.URL, "-duration", "2s", "-concurrency", "1")
+	if summary.RPSStats == nil {
+		t.Fatal("no RPS stats in the summary")
+	}
+	if want := int(math.Ceil(summary.TotalTimeTaken)); len(summary.RPSStats.Series) != want {
+		t.Errorf("series has %d seconds for a %.2fs run, want %d", len(summary.RPSStats.Series), summary.TotalTimeTaken, want)
+	}
+}
+
+func TestPollUntilStatus(t *testing.T) {
+	var mu sync.Mutex
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/job" {
+			w.Header().Set("Location", "/job")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if polls++; polls < 3 {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "1", "-poll-until-status", "200", "-poll-interval", "100ms", "-poll-timeout", "5s")
+	if summary.SuccessfulRequests != 1 {
+		t.Fatalf("%d successful requests, want 1", summary.SuccessfulRequests)
+	}
+	if summary.PollCount != 3 {
+		t.Errorf("PollCount = %d, want 3", summary.PollCount)
+	}
+	// Three polls 100ms apart: the latency covers the whole job.
+	if summary.AvgResponseTime < 0.3 {
+		t.Errorf("latency = %.3fs, want it to span the 3 polls", summary.AvgResponseTime)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	srv := newRecordingServer(t)
+	dir := t.TempDir()
+	cpu, mem := filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+	if out, code := runTool(t, "-url", srv.URL, "-requests", "20", "-cpuprofile", cpu, "-memprofile", mem); code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, out)
+	}
+	for _, path := range []string{cpu, mem} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("profile not written: %v", err)
+			continue
+		}
+		if info.Size() == 0 {
+			t.Errorf("%s is empty", filepath.Base(path))
+		}
+	}
+}
+
+func TestChartWidth(t *testing.T) {
+	for _, tt := range []struct{ term, want int }{{120, 60}, {80, 20}, {40, 10}, {400, 100}} {
+		if got := chartWidth(tt.term, 60); got != tt.want {
+			t.Errorf("chartWidth(%d, 60) = %d, want %d", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramBarsScaleWithWidth(t *testing.T) {
+	buckets := []*HistogramBucket{{Mark: 0.1, Count: 10}, {Mark: 0.2, Count: 5}, {Mark: 0.3, Count: 0}}
+	for _, width := range []int{20, 40, 80} {
+		out := captureStdout(t, func() { printHistogram(buckets, width) })
+		var bars []int
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(line, "[") {
+				bars = append(bars, strings.Count(line, "▇"))
+			}
+		}
+		if want := []int{width, width / 2, 0}; len(bars) != 3 || bars[0] != want[0] || bars[1] != want[1] || bars[2] != want[2] {
+			t.Errorf("width %d: bar lengths %v, want %v", width, bars, want)
+		}
+	}
+}
+
+func TestTrailerAssertion(t *testing.T) {
+	var mu sync.Mutex
+	var n int
+	var protos []int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		status := "0"
+		if n%2 == 0 {
+			status = "13"
+		}
+		protos = append(protos, r.ProtoMajor)
+		mu.Unlock()
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("payload"))
+		w.Header().Set("Grpc-Status", status)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	// Trust the test server's certificate in the child process.
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", ca)
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "4", "-concurrency", "1", "-assert-trailer", "grpc-status=0")
+	for _, proto := range protos {
+		if proto != 2 {
+			t.Fatalf("request used HTTP/%d, want HTTP/2", proto)
+		}
+	}
+	if summary.SuccessfulRequests != 2 || summary.FailedRequests != 2 {
+		t.Errorf("%d successes and %d failures, want 2 and 2:\n%s", summary.SuccessfulRequests, summary.FailedRequests, out)
+	}
+	if got := summary.TrailerValues["Grpc-Status"]; got["0"] != 2 || got["13"] != 2 {
+		t.Errorf("grpc-status values = %v, want 2 of each", got)
+	}
+}
+
+func TestRollingWindowReflectsRecentErrors(t *testing.T) {
+	start := time.Now()
+	m := &Metrics{StartTime: start, TimesKept: 5}
+	for second := 0; second < 60; second++ {
+		at := start.Add(time.Duration(second)*time.Second + 500*time.Millisecond)
+		for i := 0; i < 100; i++ {
+			// The last five seconds fail half their requests, slowly.
+			failed := second >= 55 && i%2 == 0
+			latency := 0.01
+			if failed {
+				latency = 0.5
+			}
+			m.recordInterval(at, latency, failed, false)
+		}
+	}
+
+	window := m.rollingWindow(start.Add(59*time.Second+500*time.Millisecond), 5)
+	if window.Requests != 500 || window.Failures != 250 {
+		t.Fatalf("window has %d requests and %d failures, want 500 and 250", window.Requests, window.Failures)
+	}
+	if window.ErrorRate != 50 {
+		t.Errorf("window error rate = %.1f%%, want 50%% (lifetime is about 4%%)", window.ErrorRate)
+	}
+	if window.P99 != 0.5 {
+		t.Errorf("window p99 = %v, want 0.5", window.P99)
+	}
+	if m.Timeline[50].ResponseTimes != nil {
+		t.Error("response times kept for an interval outside the window")
+	}
+}
+
+func TestDurationLetsLateRequestsFinish(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(400 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	// Each request takes 400ms, so the last ones are still in flight when the
+	// second is up; they must finish rather than fail against the run deadline.
+	summary, out := runSummary(t, "-url", srv.URL, "-duration", "1s", "-concurrency", "4")
+	if summary.TotalRequestsSent == 0 {
+		t.Fatalf("no requests sent:\n%s", out)
+	}
+	if summary.FailedRequests != 0 {
+		t.Errorf("%d of %d requests failed:\n%s", summary.FailedRequests, summary.TotalRequestsSent, out)
+	}
+	if summary.TotalTimeTaken < 1.2 {
+		t.Errorf("run took %.2fs, want it to wait for the requests in flight at 1s", summary.TotalTimeTaken)
+	}
+}
+
+func TestRunMetadataTCPSettings(t *testing.T) {
+	tests := []struct {
+		keepAlive time.Duration
+		want      string
+	}{
+		{0, "15s"},
+		{-1, "disabled"},
+		{90 * time.Second, "1m30s"},
+	}
+	for _, tt := range tests {
+		meta := newRunMetadata(&Config{TCPNoDelay: false, TCPKeepAlive: tt.keepAlive})
+		if meta.TCPNoDelay || meta.TCPKeepAlive != tt.want {
+			t.Errorf("keep-alive %v: metadata nodelay=%t keepalive=%q, want false and %q", tt.keepAlive, meta.TCPNoDelay, meta.TCPKeepAlive, tt.want)
+		}
+	}
+}
+
+// newStreamingServer returns a server that sends one chunk at once and a second
+// after delay.
+func newStreamingServer(t *testing.T, delay time.Duration) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(delay):
+			w.Write([]byte("data: 2\n\n"))
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStreamResponseTTFB(t *testing.T) {
+	srv := newStreamingServer(t, 600*time.Millisecond)
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "2", "-concurrency", "1", "-stream-response")
+	if summary.SuccessfulRequests != 2 || summary.Stream == nil {
+		t.Fatalf("%d successes, stream stats %v:\n%s", summary.SuccessfulRequests, summary.Stream, out)
+	}
+	if summary.AvgResponseTime > 0.3 {
+		t.Errorf("response time %.3fs includes the stream; want the time to first byte", summary.AvgResponseTime)
+	}
+	if summary.Stream.AvgReadTime < 0.5 {
+		t.Errorf("stream read time %.3fs, want it to cover the delayed chunk", summary.Stream.AvgReadTime)
+	}
+	if summary.Stream.BytesRead != 36 {
+		t.Errorf("read %d bytes, want 36", summary.Stream.BytesRead)
+	}
+}
+
+func TestStreamResponseLimits(t *testing.T) {
+	srv := newStreamingServer(t, 5*time.Second)
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "2", "-concurrency", "1", "-stream-response", "-read-duration", "200ms")
+	if summary.SuccessfulRequests != 2 || summary.Stream == nil {
+		t.Fatalf("%d successes, stream stats %v:\n%s", summary.SuccessfulRequests, summary.Stream, out)
+	}
+	if summary.Stream.MaxReadTime > 1 {
+		t.Errorf("stream read for %.3fs, want it abandoned after 200ms", summary.Stream.MaxReadTime)
+	}
+
+	summary, out = runSummary(t, "-url", srv.URL, "-requests", "2", "-concurrency", "1", "-stream-response", "-read-bytes", "4")
+	if summary.SuccessfulRequests != 2 || summary.Stream == nil {
+		t.Fatalf("%d successes, stream stats %v:\n%s", summary.SuccessfulRequests, summary.Stream, out)
+	}
+	if summary.Stream.BytesRead != 8 || summary.Stream.MaxReadTime > 1 {
+		t.Errorf("read %d bytes in up to %.3fs, want 8 bytes read at once", summary.Stream.BytesRead, summary.Stream.MaxReadTime)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	served := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := hits
+		hits = 0
+		return n
+	}
+
+	out, code := runTool(t, "-url", srv.URL, "-requests", "20", "-preflight")
+	if code != 1 || !strings.Contains(out, "Aborting: the preflight request failed") {
+		t.Errorf("exit code %d, want 1 and an abort message:\n%s", code, out)
+	}
+	if !strings.Contains(out, "Status: 404 Not Found") {
+		t.Errorf("preflight response not printed:\n%s", out)
+	}
+	if n := served(); n != 1 {
+		t.Errorf("server saw %d requests, want only the preflight", n)
+	}
+
+	out, _ = runTool(t, "-url", srv.URL, "-requests", "20", "-preflight", "-force")
+	if n := served(); n != 21 {
+		t.Errorf("with -force: server saw %d requests, want the preflight and the full load:\n%s", n, out)
+	}
+
+	// The preflight uses the run's own success criteria.
+	out, code = runTool(t, "-url", srv.URL, "-requests", "20", "-preflight", "-success-expr", "status == 404")
+	if n := served(); code != 0 || n != 21 {
+		t.Errorf("with a matching -success-expr: exit code %d and %d requests, want 0 and 21:\n%s", code, n, out)
+	}
+}
+
+func TestWorkerSlotPace(t *testing.T) {
+	const workers, sends = 3, 5
+	interval := 50 * time.Millisecond
+	elapsed := make([]time.Duration, workers)
+	var wg sync.WaitGroup
+	for i := range elapsed {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slot := &workerSlot{}
+			start := time.Now()
+			for n := 0; n < sends; n++ {
+				if !slot.pace(context.Background(), interval) {
+					t.Error("pace reported a cancelled context")
+				}
+			}
+			elapsed[i] = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+	// Each slot is paced on its own: five sends take four intervals however many
+	// other slots are sending at the same time.
+	want := (sends - 1) * interval
+	for i, d := range elapsed {
+		if d < want || d > want+interval {
+			t.Errorf("worker %d took %v for %d sends, want about %v", i, d, sends, want)
+		}
+	}
+}
+
+func TestPerWorkerRPS(t *testing.T) {
+	srv := newRecordingServer(t)
+	// Three workers at 5 requests per second each send 15 requests in 0.8s: each
+	// sends 5, four intervals of 200ms apart.
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "15", "-concurrency", "3", "-per-worker-rps", "5")
+	if summary.SuccessfulRequests != 15 {
+		t.Fatalf("%d successes, want 15:\n%s", summary.SuccessfulRequests, out)
+	}
+	if summary.TotalTimeTaken < 0.75 || summary.TotalTimeTaken > 1.2 {
+		t.Errorf("run took %.2fs, want about 0.8s", summary.TotalTimeTaken)
+	}
+	if !strings.Contains(out, "Per-Worker RPS           : ") {
+		t.Errorf("realized per-worker rate not reported:\n%s", out)
+	}
+}
+
+func TestComputeCDF(t *testing.T) {
+	times := make([]float64, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		// Mostly fast with a slow tail, and many ties.
+		times = append(times, float64(i%50)/1000+float64(i/990))
+	}
+	sort.Float64s(times)
+	points := computeCDF(times)
+	if len(points) != len(cdfFractions) {
+		t.Fatalf("%d points, want %d", len(points), len(cdfFractions))
+	}
+	for i, point := range points {
+		if point.Fraction != cdfFractions[i] {
+			t.Errorf("point %d is for %v%%, want %v%%", i, point.Fraction, cdfFractions[i])
+		}
+		if want := percentile(times, point.Fraction); point.Latency != want {
+			t.Errorf("%v%%: latency %v, want the percentile %v", point.Fraction, point.Latency, want)
+		}
+		if i > 0 && point.Latency < points[i-1].Latency {
+			t.Errorf("CDF decreases from %v at %v%% to %v at %v%%", points[i-1].Latency, points[i-1].Fraction, point.Latency, point.Fraction)
+		}
+	}
+	if last := points[len(points)-1]; last.Latency != times[len(times)-1] {
+		t.Errorf("100%% point is %v, want the maximum %v", last.Latency, times[len(times)-1])
+	}
+}
+
+func TestSummaryCDFMatchesPercentiles(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		delay := time.Duration(n%5) * 10 * time.Millisecond
+		mu.Unlock()
+		time.Sleep(delay)
+	}))
+	defer srv.Close()
+
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "40", "-concurrency", "4")
+	byFraction := make(map[float64]float64)
+	for i, point := range summary.CDF {
+		byFraction[point.Fraction] = point.Latency
+		if i > 0 && point.Latency < summary.CDF[i-1].Latency {
+			t.Errorf("CDF decreases at %v%%", point.Fraction)
+		}
+	}
+	if byFraction[90] != summary.Percentile90 || byFraction[99] != summary.Percentile99 {
+		t.Errorf("CDF p90/p99 = %v/%v, summary has %v/%v", byFraction[90], byFraction[99], summary.Percentile90, summary.Percentile99)
+	}
+}
+
+func TestLabelsInOpenMetrics(t *testing.T) {
+	summary := &Summary{
+		TotalRequestsSent: 3,
+		StatusCodeDist:    map[int]int{200: 2, 503: 1},
+		Metadata:          &RunMetadata{Labels: map[string]string{"region": "eu", "env": "prod"}},
+	}
+	var out strings.Builder
+	writeOpenMetrics(&out, summary)
+
+	samples := 0
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		samples++
+		if !strings.Contains(line, `{env="prod",region="eu"`) {
+			t.Errorf("sample without the run labels: %q", line)
+		}
+	}
+	if samples == 0 {
+		t.Fatal("no samples written")
+	}
+	if !strings.Contains(out.String(), `httptest_responses_total{env="prod",region="eu",code="503"} 1`) {
+		t.Errorf("status code sample does not combine run and sample labels:\n%s", out.String())
+	}
+}
+
+func TestLabelsInSummaryMetadata(t *testing.T) {
+	srv := newRecordingServer(t)
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "1", "-label", "env=staging", "-label", "build=1.2.3")
+	if summary.Metadata == nil {
+		t.Fatal("no metadata in the summary")
+	}
+	if got := summary.Metadata.Labels; len(got) != 2 || got["env"] != "staging" || got["build"] != "1.2.3" {
+		t.Errorf("metadata labels = %v, want env=staging and build=1.2.3", got)
+	}
+
+	var labels runLabels
+	for _, bad := range []string{"novalue", "=x", "bad-key=x", "1st=x"} {
+		if err := labels.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestQueueWait(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	// Two slots and eight 200ms requests: both slots free up together every
+	// 200ms, so one request of each later pair waits that long for its slot.
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "8", "-concurrency", "2")
+	if summary.QueueWait == nil {
+		t.Fatal("no queue wait stats in the summary")
+	}
+	if summary.QueueWait.P90 < 0.15 || summary.QueueWait.Avg < 0.05 {
+		t.Errorf("queue wait p90 %.3fs, avg %.3fs; want about 0.2s and 0.075s", summary.QueueWait.P90, summary.QueueWait.Avg)
+	}
+
+	// With a slot for every request nothing waits.
+	summary, _ = runSummary(t, "-url", srv.URL, "-requests", "4", "-concurrency", "4")
+	if summary.QueueWait == nil || summary.QueueWait.Max > 0.05 {
+		t.Errorf("queue wait %+v with free slots, want about zero", summary.QueueWait)
+	}
+}
+
+func TestMaxRuntime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(10 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "100", "-concurrency", "2", "-max-runtime", "500ms")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run took %v, want it cut off at 500ms", elapsed)
+	}
+	if summary.TotalTimeTaken < 0.5 || summary.TotalTimeTaken > 1.5 {
+		t.Errorf("summary says the run took %.2fs, want about 0.5s", summary.TotalTimeTaken)
+	}
+	if summary.AbortReason != "max runtime of 500ms reached" {
+		t.Errorf("abort reason = %q", summary.AbortReason)
+	}
+	if !strings.Contains(out, "Stopping: max runtime of 500ms reached.") {
+		t.Errorf("no max runtime note in the output:\n%s", out)
+	}
+}
+
+func TestBinaryBodyFile(t *testing.T) {
+	payload := []byte{0x0a, 0x00, 0xff, 0x00, 0x00, 0x80, 'p', 'b', 0x00, 0xc3, 0x28, 0xfe}
+	path := filepath.Join(t.TempDir(), "payload.bin")
+	if err := os.WriteFile(path, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := newRecordingServer(t)
+	runSummary(t, "-url", srv.URL, "-method", "POST", "-body-file", path, "-requests", "3", "-header", "Content-Type:application/x-protobuf")
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.bodies) != 3 {
+		t.Fatalf("server got %d requests, want 3", len(srv.bodies))
+	}
+	for i, body := range srv.bodies {
+		if string(body) != string(payload) {
+			t.Errorf("request %d body = %x, want %x", i, body, payload)
+		}
+		if srv.requests[i].ContentLength != int64(len(payload)) {
+			t.Errorf("request %d Content-Length = %d, want %d", i, srv.requests[i].ContentLength, len(payload))
+		}
+	}
+}
+
+func TestLatencyUnitFormat(t *testing.T) {
+	tests := []struct {
+		unit    latencyUnit
+		seconds float64
+		want    string
+	}{
+		{"s", 0.0001234, "0.0001s"},
+		{"s", 2.5, "2.5000s"},
+		{"ms", 0.0001234, "0.12ms"},
+		{"ms", 0.25, "250.00ms"},
+		{"ms", 2.5, "2500.00ms"},
+		{"us", 0.0001234, "123µs"},
+		{"us", 0.25, "250000µs"},
+		{"auto", 0.0001234, "123µs"},
+		{"auto", 0.25, "250.00ms"},
+		{"auto", 2.5, "2.5000s"},
+		{"auto", 0.001, "1.00ms"},
+		{"auto", 1, "1.0000s"},
+	}
+	for _, tt := range tests {
+		if got := tt.unit.format(tt.seconds); got != tt.want {
+			t.Errorf("%s.format(%v) = %q, want %q", tt.unit, tt.seconds, got, tt.want)
+		}
+	}
+
+	var unit latencyUnit
+	if err := unit.Set("ns"); err == nil {
+		t.Error("Set(ns) succeeded, want an error")
+	}
+}
+
+func TestLatencyUnitInSummary(t *testing.T) {
+	srv := newRecordingServer(t)
+	for unit, pattern := range map[string]*regexp.Regexp{
+		"s":  regexp.MustCompile(`Average Response Time +: \d+\.\d{4}s\n`),
+		"ms": regexp.MustCompile(`Average Response Time +: \d+\.\d{2}ms\n`),
+		"us": regexp.MustCompile(`Average Response Time +: \d+µs\n`),
+	} {
+		out, code := runTool(t, "-url", srv.URL, "-requests", "3", "-latency-unit", unit)
+		if code != 0 || !pattern.MatchString(out) {
+			t.Errorf("-latency-unit %s: exit code %d, want the average rendered as %s:\n%s", unit, code, pattern, out)
+		}
+	}
+
+	// The JSON summary stays in seconds whatever the console shows.
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "3", "-latency-unit", "us")
+	if summary.AvgResponseTime <= 0 || summary.AvgResponseTime > 1 {
+		t.Errorf("JSON average = %v, want seconds", summary.AvgResponseTime)
+	}
+}
+
+func TestConnectionResets(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		reset := n%3 == 0
+		mu.Unlock()
+		if !reset {
+			return
+		}
+		// Closing with a zero linger time sends a TCP RST instead of a FIN.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	// POST requests are not retried by the transport, so every reset is seen.
+	summary, out := runSummary(t, "-url", srv.URL, "-method", "POST", "-requests", "30", "-concurrency", "3")
+	if summary.ConnectionResets == nil {
+		t.Fatalf("no connection resets reported:\n%s", out)
+	}
+	resets := summary.ConnectionResets
+	if resets.Count != 10 || summary.FailedRequests != 10 {
+		t.Errorf("%d resets and %d failures, want 10 of each", resets.Count, summary.FailedRequests)
+	}
+	if !approxEqual(resets.Rate, float64(resets.Count)/float64(summary.TotalRequestsSent)*100) {
+		t.Errorf("reset rate %.2f%% does not match %d of %d requests", resets.Rate, resets.Count, summary.TotalRequestsSent)
+	}
+	total := 0
+	for _, count := range resets.Series {
+		total += count
+	}
+	if int64(total) != resets.Count {
+		t.Errorf("series %v adds up to %d, want %d", resets.Series, total, resets.Count)
+	}
+}
+
+func TestBodyCommand(t *testing.T) {
+	for _, name := range []string{"echo", "date", "ls"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not available: %v", name, err)
+		}
+	}
+
+	srv := newRecordingServer(t)
+	runSummary(t, "-url", srv.URL, "-method", "POST", "-requests", "3", "-body-command", "echo hello  world")
+	srv.mu.Lock()
+	for i, body := range srv.bodies {
+		if string(body) != "hello world\n" {
+			t.Errorf("request %d body = %q, want the command's output", i, body)
+		}
+	}
+	srv.bodies = nil
+	srv.mu.Unlock()
+
+	// Per request, the command runs again for every body.
+	runSummary(t, "-url", srv.URL, "-method", "POST", "-requests", "3", "-concurrency", "1", "-body-command", "date +%s%N", "-body-command-per-request")
+	srv.mu.Lock()
+	seen := make(map[string]bool)
+	for _, body := range srv.bodies {
+		seen[string(body)] = true
+	}
+	srv.mu.Unlock()
+	if len(seen) != 3 {
+		t.Errorf("got %d distinct bodies from 3 runs of the command, want 3", len(seen))
+	}
+
+	out, code := runTool(t, "-url", srv.URL, "-method", "POST", "-requests", "1", "-body-command", "ls /nonexistent-httptest-dir")
+	if code != 1 || !strings.Contains(out, "Error running body command: exit status") || !strings.Contains(out, "nonexistent-httptest-dir") {
+		t.Errorf("exit code %d, want 1 and the command's error with its stderr:\n%s", code, out)
+	}
+}
+
+func TestExpandOutputPath(t *testing.T) {
+	now := time.Date(2024, 3, 9, 14, 5, 7, 0, time.UTC)
+	tests := []struct {
+		path, url string
+		want      string
+	}{
+		{"run-{timestamp}.json", "http://example.com", "run-20240309T140507.json"},
+		{"{target}-c{concurrency}.json", "https://api.example.com:8443/v1", "api.example.com_8443-c10.json"},
+		{"{target}.json", "http://[::1]:80/", "___1__80.json"},
+		{"{target}.json", "not a url", "unknown.json"},
+		{"reports/plain.json", "http://example.com", "reports/plain.json"},
+	}
+	for _, tt := range tests {
+		if got := expandOutputPath(tt.path, &Config{URL: tt.url, Concurrency: 10}, now); got != tt.want {
+			t.Errorf("expandOutputPath(%q) for %s = %q, want %q", tt.path, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestOutputPathPlaceholders(t *testing.T) {
+	srv := newRecordingServer(t)
+	dir := t.TempDir()
+	out, code := runTool(t, "-url", srv.URL, "-requests", "1", "-concurrency", "3", "-output", filepath.Join(dir, "{target}-{concurrency}-{timestamp}.json"))
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, out)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(matches) != 1 {
+		t.Fatalf("files written: %v, want one", matches)
+	}
+	host := strings.Replace(strings.TrimPrefix(srv.URL, "http://"), ":", "_", 1)
+	name := regexp.MustCompile(`^` + regexp.QuoteMeta(host) + `-3-\d{8}T\d{6}\.json$`)
+	if !name.MatchString(filepath.Base(matches[0])) {
+		t.Errorf("summary written to %s, want %s", filepath.Base(matches[0]), name)
+	}
+	if !strings.Contains(out, matches[0]) {
+		t.Errorf("output does not name the expanded path %s:\n%s", matches[0], out)
+	}
+}
+
+func TestHistogramPercentages(t *testing.T) {
+	buckets := []*HistogramBucket{{Mark: 0.1, Count: 1}, {Mark: 0.5, Count: 3}, {Mark: 1, Count: 0}, {Mark: math.Inf(1), Count: 6}}
+	out := captureStdout(t, func() { printHistogram(buckets, 20) })
+
+	columns := regexp.MustCompile(`\((\d+)\) +([\d.]+)% cum +([\d.]+)%`)
+	var got []string
+	for _, line := range strings.Split(out, "\n") {
+		if m := columns.FindStringSubmatch(line); m != nil {
+			got = append(got, strings.Join(m[1:], " "))
+		}
+	}
+	want := []string{"1 10.0 10.0", "3 30.0 40.0", "0 0.0 40.0", "6 60.0 100.0"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("count, share and cumulative columns = %q, want %q", got, want)
+	}
+	if !strings.Contains(out, "Total: 10 responses\n") {
+		t.Errorf("no total row:\n%s", out)
+	}
+	if !strings.Contains(out, "1.00s+") {
+		t.Errorf("open-ended bucket not labelled:\n%s", out)
+	}
+}
+
+func TestHeadersFile(t *testing.T) {
+	t.Setenv("HTTPTEST_TOKEN", "s3cret")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "headers.txt")
+	content := "# auth for the staging API\nAuthorization: Bearer ${HTTPTEST_TOKEN}\n\nX-Team: core\nX-Override: from file\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := newRecordingServer(t)
+	runSummary(t, "-url", srv.URL, "-requests", "1", "-headers-file", path, "-header", "X-Override: from flag")
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.requests) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(srv.requests))
+	}
+	header := srv.requests[0].Header
+	for name, want := range map[string]string{"Authorization": "Bearer s3cret", "X-Team": "core", "X-Override": "from flag"} {
+		if got := header.Values(name); len(got) != 1 || got[0] != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.txt")
+	os.WriteFile(bad, []byte("X-Good: yes\nno colon here\n"), 0644)
+	if out, code := runTool(t, "-url", srv.URL, "-requests", "1", "-headers-file", bad); code != 1 || !strings.Contains(out, "bad.txt:2: expected 'Key: Value'") {
+		t.Errorf("exit code %d, want 1 and the bad line's number:\n%s", code, out)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		webSocket bool
+		ok        bool
+	}{
+		{"http://example.com/path", false, true},
+		{"https://127.0.0.1:8443", false, true},
+		{"ws://example.com/socket", true, true},
+		{"ws://example.com/socket", false, false},
+		{"https://example.com", true, false},
+		{"ftp://example.com", false, false},
+		{"http://", false, false},
+		{"http:///path", false, false},
+		{"http://exa mple.com", false, false},
+		{"http://[::1", false, false},
+	}
+	for _, tt := range tests {
+		if err := validateURL(tt.url, tt.webSocket); (err == nil) != tt.ok {
+			t.Errorf("validateURL(%q, %v) = %v, want ok=%v", tt.url, tt.webSocket, err, tt.ok)
+		}
+	}
+}
+
+func TestSchemelessURL(t *testing.T) {
+	srv := newRecordingServer(t)
+	hostPort := strings.TrimPrefix(srv.URL, "http://")
+
+	// A loopback address is assumed to serve plain http.
+	summary, out := runSummary(t, "-url", hostPort+"/ping", "-requests", "2")
+	if summary.SuccessfulRequests != 2 {
+		t.Errorf("%d successes for a schemeless loopback URL, want 2:\n%s", summary.SuccessfulRequests, out)
+	}
+
+	out, code := runTool(t, "-url", hostPort, "-requests", "2", "-no-auto-scheme")
+	if code != 1 || !strings.Contains(out, "has no scheme. Add http:// or https://") {
+		t.Errorf("with -no-auto-scheme: exit code %d, want 1 and a scheme error:\n%s", code, out)
+	}
+	out, code = runTool(t, "-url", hostPort, "-websocket", "-duration", "1s", "-no-auto-scheme")
+	if code != 1 || !strings.Contains(out, "Add ws:// or wss://") {
+		t.Errorf("with -websocket -no-auto-scheme: exit code %d, want 1 and a ws scheme hint:\n%s", code, out)
+	}
+}
+
+func TestMalformedURL(t *testing.T) {
+	srv := newRecordingServer(t)
+	for url, want := range map[string]string{
+		"http://":                 "no host",
+		"gopher://" + srv.URL[7:]: "unsupported scheme",
+		"http://exa mple.com":     "invalid character",
+	} {
+		out, code := runTool(t, "-url", url, "-requests", "1")
+		if code != 1 || !strings.Contains(out, "Error: invalid -url: ") || !strings.Contains(out, want) {
+			t.Errorf("-url %q: exit code %d, want 1 and %q:\n%s", url, code, want, out)
+		}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.requests) != 0 {
+		t.Errorf("%d requests sent for malformed URLs", len(srv.requests))
+	}
+}
+
+func TestBuildOverhead(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skipf("sleep not available: %v", err)
+	}
+	srv := newRecordingServer(t)
+	// Running a 50ms command for every body is costly client-side work that must not
+	// be counted as server latency.
+	summary, out := runSummary(t, "-url", srv.URL, "-method", "POST", "-requests", "4", "-concurrency", "1", "-body-command", "sleep 0.05", "-body-command-per-request")
+	if summary.BuildOverhead == nil {
+		t.Fatalf("no build overhead in the summary:\n%s", out)
+	}
+	if summary.BuildOverhead.P50 < 0.04 {
+		t.Errorf("build overhead p50 %.4fs, want at least the command's 50ms", summary.BuildOverhead.P50)
+	}
+	if summary.AvgResponseTime > 0.03 {
+		t.Errorf("response time %.4fs includes the build overhead", summary.AvgResponseTime)
+	}
+	if !strings.Contains(out, "Client-Side Overhead") {
+		t.Errorf("overhead section missing:\n%s", out)
+	}
+}
+
+func TestDNSFailureAbort(t *testing.T) {
+	start := time.Now()
+	summary, out := runSummary(t, "-url", "http://httptest-unresolvable.invalid/", "-duration", "30s", "-concurrency", "2", "-dns-failure-threshold", "5")
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("run took %v, want an early abort", elapsed)
+	}
+	if !strings.HasPrefix(summary.AbortReason, "target appears unresolvable: the first ") {
+		t.Errorf("abort reason = %q", summary.AbortReason)
+	}
+	if !strings.Contains(out, "Aborting: target appears unresolvable") {
+		t.Errorf("no abort message in the output:\n%s", out)
+	}
+}
+
+func TestTimeseriesCSV(t *testing.T) {
+	srv := newRecordingServer(t)
+	path := filepath.Join(t.TempDir(), "series.csv")
+	summary, _ := runSummary(t, "-url", srv.URL, "-duration", "3s", "-concurrency", "2", "-report-interval", "2s", "-timeseries-csv", path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "elapsed_seconds,rps,success,failure,p50,p90,p99,avg,in_flight"
+	if len(records) == 0 || strings.Join(records[0], ",") != want {
+		t.Fatalf("header = %v, want %s", records, want)
+	}
+	rows := records[1:]
+	// A 3s run in 2s intervals has one full interval and one partial one.
+	if len(rows) != 2 {
+		t.Fatalf("%d rows, want 2:\n%v", len(rows), rows)
+	}
+	var successes int64
+	for i, row := range rows {
+		elapsed, _ := strconv.Atoi(row[0])
+		if i == 0 && elapsed != 2 || i == 1 && elapsed != 3 && elapsed != 4 {
+			t.Errorf("row %d ends at %ds", i, elapsed)
+		}
+		n, _ := strconv.ParseInt(row[2], 10, 64)
+		successes += n
+		p50, _ := strconv.ParseFloat(row[4], 64)
+		p90, _ := strconv.ParseFloat(row[5], 64)
+		p99, _ := strconv.ParseFloat(row[6], 64)
+		if p50 > p90 || p90 > p99 {
+			t.Errorf("row %d percentiles out of order: %v", i, row[4:7])
+		}
+	}
+	if successes != summary.SuccessfulRequests {
+		t.Errorf("rows count %d successes, summary has %d", successes, summary.SuccessfulRequests)
+	}
+}
+
+func TestTimeseriesRowsBounded(t *testing.T) {
+	start := time.Now()
+	m := &Metrics{StartTime: start, TimesKept: 2, TimeseriesStep: 2}
+	// Ten seconds of one request a second, each taking its second number.
+	for second := 0; second < 10; second++ {
+		m.recordInterval(start.Add(time.Duration(second)*time.Second+time.Millisecond), float64(second), false, false)
+	}
+	// Four rows have closed; the fifth, seconds 8 and 9, is still open.
+	if len(m.TimeseriesRows) != 4 {
+		t.Fatalf("%d closed rows, want 4", len(m.TimeseriesRows))
+	}
+	for i, row := range m.TimeseriesRows {
+		if want := float64(2*i) + 0.5; row.Avg != want || row.Max != float64(2*i+1) {
+			t.Errorf("row %d: avg %v, max %v; want %v and %d", i, row.Avg, row.Max, want, 2*i+1)
+		}
+	}
+	for _, interval := range m.Timeline[:8] {
+		if interval.ResponseTimes != nil {
+			t.Errorf("second %d still keeps its response times", interval.Second)
+		}
+	}
+	if got := intervalTimes(m.Timeline[8:]); fmt.Sprint(got) != "[8 9]" {
+		t.Errorf("open row keeps %v, want [8 9]", got)
+	}
+}
+
+func TestMaxAcceptableLatency(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") != "" {
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL+"/?slow=1", "-requests", "3", "-max-acceptable-latency", "0.1")
+	if summary.TooSlowRequests != 3 || summary.FailedRequests != 3 {
+		t.Errorf("%d too slow and %d failed, want 3 of each:\n%s", summary.TooSlowRequests, summary.FailedRequests, out)
+	}
+	if summary.StatusCodeDist[200] != 3 {
+		t.Errorf("status codes %v, want the 200s still counted", summary.StatusCodeDist)
+	}
+
+	summary, _ = runSummary(t, "-url", srv.URL, "-requests", "3", "-max-acceptable-latency", "0.1")
+	if summary.TooSlowRequests != 0 || summary.SuccessfulRequests != 3 {
+		t.Errorf("fast responses: %d too slow, %d successes; want 0 and 3", summary.TooSlowRequests, summary.SuccessfulRequests)
+	}
+}
+
+// connCountingServer is a test server that tracks how many client connections it
+// has open at once.
+type connCountingServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	open, peak int
+	protos     map[int]int // requests by HTTP major version
+}
+
+func newConnCountingServer(t *testing.T, http2 bool) *connCountingServer {
+	s := &connCountingServer{protos: make(map[int]int)}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.protos[r.ProtoMajor]++
+		s.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}))
+	s.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch state {
+		case http.StateNew:
+			s.open++
+			if s.open > s.peak {
+				s.peak = s.open
+			}
+		case http.StateClosed, http.StateHijacked:
+			s.open--
+		}
+	}
+	if http2 {
+		s.EnableHTTP2 = true
+		s.StartTLS()
+	} else {
+		s.Start()
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestConnectionsLimitHTTP2(t *testing.T) {
+	srv := newConnCountingServer(t, true)
+
+	// Trust the test server's certificate in the child process.
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", ca)
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "100", "-concurrency", "20", "-connections", "2")
+	if summary.SuccessfulRequests != 100 {
+		t.Fatalf("%d successes, want 100:\n%s", summary.SuccessfulRequests, out)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.protos[2] != 100 {
+		t.Errorf("requests by HTTP version: %v, want all over HTTP/2", srv.protos)
+	}
+	if srv.peak > 2 || summary.PeakConnections > 2 {
+		t.Errorf("server saw up to %d connections and the tool reports %d, want at most 2", srv.peak, summary.PeakConnections)
+	}
+}
+
+func TestConnectionsLimitHTTP1(t *testing.T) {
+	srv := newConnCountingServer(t, false)
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "60", "-concurrency", "10", "-connections", "3")
+	if summary.SuccessfulRequests != 60 {
+		t.Fatalf("%d successes, want 60:\n%s", summary.SuccessfulRequests, out)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.peak > 3 || summary.PeakConnections > 3 {
+		t.Errorf("server saw up to %d connections and the tool reports %d, want at most 3", srv.peak, summary.PeakConnections)
+	}
+}
+
+func TestLatencyMode(t *testing.T) {
+	chunk := []byte(strings.Repeat("x", 64<<10))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A large body that takes 300ms to download after a prompt first byte.
+		for i := 0; i < 10; i++ {
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	latency := make(map[string]float64)
+	for _, mode := range []string{"headers", "ttfb", "total"} {
+		summary, out := runSummary(t, "-url", srv.URL, "-requests", "2", "-concurrency", "1", "-latency-mode", mode)
+		if summary.SuccessfulRequests != 2 {
+			t.Fatalf("-latency-mode %s: %d successes, want 2:\n%s", mode, summary.SuccessfulRequests, out)
+		}
+		if summary.Metadata == nil || summary.Metadata.LatencyMode != mode {
+			t.Errorf("-latency-mode %s: metadata %+v does not report the mode", mode, summary.Metadata)
+		}
+		latency[mode] = summary.AvgResponseTime
+	}
+	if latency["total"] < 0.25 {
+		t.Errorf("total latency %.3fs, want it to include the 300ms download", latency["total"])
+	}
+	for _, mode := range []string{"headers", "ttfb"} {
+		if latency[mode] > latency["total"]/3 {
+			t.Errorf("%s latency %.3fs is not much lower than the total %.3fs", mode, latency[mode], latency["total"])
+		}
+	}
+}
+
+func TestUserAgentFile(t *testing.T) {
+	agents := []string{"Mozilla/5.0 (X11; Linux x86_64)", "curl/8.4.0", "Googlebot/2.1"}
+	path := filepath.Join(t.TempDir(), "agents.txt")
+	if err := os.WriteFile(path, []byte("# pool\n"+strings.Join(agents, "\n")+"\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := newRecordingServer(t)
+	// sent returns the User-Agents received since it was last called.
+	sent := func() []string {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		var got []string
+		for _, r := range srv.requests {
+			got = append(got, r.UserAgent())
+		}
+		srv.requests = nil
+		return got
+	}
+
+	runSummary(t, "-url", srv.URL, "-requests", "6", "-concurrency", "1", "-user-agent-file", path)
+	want := append(append([]string(nil), agents...), agents...)
+	if got := sent(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("User-Agents %q, want the pool in rotation %q", got, want)
+	}
+
+	// Picked at random, only agents from the pool are sent.
+	runSummary(t, "-url", srv.URL, "-requests", "30", "-user-agent-file", path, "-user-agent-random")
+	for _, agent := range sent() {
+		if !contains(agents, agent) {
+			t.Errorf("User-Agent %q is not from the pool", agent)
+		}
+	}
+
+	// An explicit -header still wins.
+	runSummary(t, "-url", srv.URL, "-requests", "2", "-user-agent-file", path, "-header", "User-Agent: pinned/1.0")
+	for _, agent := range sent() {
+		if agent != "pinned/1.0" {
+			t.Errorf("User-Agent %q, want the -header value", agent)
+		}
+	}
+}
+
+func TestGoodput(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		fail := n%2 == 0
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(make([]byte, 3000))
+			return
+		}
+		w.Write(make([]byte, 1000))
+	}))
+	defer srv.Close()
+
+	summary, _ := runSummary(t, "-url", srv.URL, "-requests", "10", "-concurrency", "1")
+	if summary.SuccessfulRequests != 5 || summary.FailedRequests != 5 {
+		t.Fatalf("%d successes and %d failures, want 5 of each", summary.SuccessfulRequests, summary.FailedRequests)
+	}
+	got := summary.Throughput
+	if got.TotalBytes != 20000 || got.GoodBytes != 5000 {
+		t.Errorf("%d bytes in total and %d good, want 20000 and 5000", got.TotalBytes, got.GoodBytes)
+	}
+	// Goodput falls short of throughput by exactly the failed responses' bytes.
+	if !approxEqual(got.BytesPerSecond-got.GoodputPerSecond, 15000/summary.TotalTimeTaken) {
+		t.Errorf("throughput %.0f B/s and goodput %.0f B/s differ by more than the failed bytes", got.BytesPerSecond, got.GoodputPerSecond)
+	}
+}
+
+func TestDefaultScheme(t *testing.T) {
+	tests := map[string]string{
+		"localhost":              "http",
+		"localhost:8080/health":  "http",
+		"LOCALHOST:3000":         "http",
+		"api.localhost:8080":     "http",
+		"127.0.0.1:9000":         "http",
+		"127.0.0.53":             "http",
+		"[::1]:8080/path":        "http",
+		"10.1.2.3:80":            "http",
+		"192.168.0.10/admin":     "http",
+		"172.16.5.4?x=1":         "http",
+		"example.com":            "https",
+		"example.com:8080/path":  "https",
+		"8.8.8.8":                "https",
+		"localhost.example.com":  "https",
+		"[2001:db8::1]:443/path": "https",
+	}
+	for url, want := range tests {
+		if got := defaultScheme(url); got != want {
+			t.Errorf("defaultScheme(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestDefaultSchemeFlag(t *testing.T) {
+	srv := newRecordingServer(t)
+	hostPort := strings.TrimPrefix(srv.URL, "http://")
+
+	// Forcing https on a plain http server makes every request fail the handshake.
+	summary, _ := runSummary(t, "-url", hostPort, "-requests", "2", "-default-scheme", "https")
+	if summary.FailedRequests != 2 {
+		t.Errorf("-default-scheme https: %d failures, want the loopback URL sent over https", summary.FailedRequests)
+	}
+	summary, _ = runSummary(t, "-url", hostPort, "-requests", "2", "-default-scheme", "http")
+	if summary.SuccessfulRequests != 2 {
+		t.Errorf("-default-scheme http: %d successes, want 2", summary.SuccessfulRequests)
+	}
+	if out, code := runTool(t, "-url", hostPort, "-default-scheme", "ftp"); code != 1 || !strings.Contains(out, "-default-scheme must be 'http' or 'https'") {
+		t.Errorf("-default-scheme ftp: exit code %d, want 1 and an error:\n%s", code, out)
+	}
+}
+
+func TestLatencyRing(t *testing.T) {
+	ring := newLatencyRing(4)
+	if got := ring.values(); len(got) != 0 {
+		t.Errorf("empty ring has values %v", got)
+	}
+	for i := 1; i <= 3; i++ {
+		ring.add(float64(i))
+	}
+	if got := fmt.Sprint(ring.values()); got != "[1 2 3]" {
+		t.Errorf("partly filled ring = %s, want [1 2 3]", got)
+	}
+	for i := 4; i <= 10; i++ {
+		ring.add(float64(i))
+	}
+	// Exactly the last four, oldest first.
+	if got := fmt.Sprint(ring.values()); got != "[7 8 9 10]" {
+		t.Errorf("ring = %s, want [7 8 9 10]", got)
+	}
+}
+
+func TestRecordTimeBounded(t *testing.T) {
+	m := &Metrics{RecentTimes: newLatencyRing(100), Digest: &latencyDigest{}}
+	for i := 1; i <= 10000; i++ {
+		m.recordTime(float64(i))
+	}
+	if len(m.ResponseTimes) != 0 || len(m.RecentTimes.samples) != 100 {
+		t.Fatalf("kept %d lifetime and %d recent times, want 0 and 100", len(m.ResponseTimes), len(m.RecentTimes.samples))
+	}
+	recent := m.RecentTimes.values()
+	if recent[0] != 9901 || recent[99] != 10000 {
+		t.Errorf("recent times run from %v to %v, want 9901 to 10000", recent[0], recent[99])
+	}
+	// The count, mean and extremes stay exact however little is kept.
+	if m.TimedCount != 10000 || m.TimeSum != 50005000 || m.TimeMin != 1 || m.TimeMax != 10000 {
+		t.Errorf("count %d, sum %v, min %v, max %v", m.TimedCount, m.TimeSum, m.TimeMin, m.TimeMax)
+	}
+	// The digest covers the whole run, not just its end.
+	if got := m.Digest.quantile(50); math.Abs(got-5001)/5001 > 0.01 {
+		t.Errorf("estimated median = %v, want within 1%% of 5001", got)
+	}
+}
+
+func TestLatencyDigest(t *testing.T) {
+	d := &latencyDigest{}
+	if got := d.quantile(99); got != 0 {
+		t.Errorf("quantile of an empty digest = %v, want 0", got)
+	}
+
+	// Times spread over five orders of magnitude, from 100µs to 10s.
+	times := make([]float64, 0, 50001)
+	for i := 0; i <= 50000; i++ {
+		t := 1e-4 * math.Pow(10, float64(i)/10000)
+		times = append(times, t)
+		d.add(t)
+	}
+	for _, p := range []float64{0, 1, 50, 90, 99, 99.9, 100} {
+		want := percentile(times, p)
+		if got := d.quantile(p); math.Abs(got-want)/want > 0.01 {
+			t.Errorf("quantile(%v) = %v, want within 1%% of %v", p, got, want)
+		}
+	}
+	if got := d.quantile(100); got != times[len(times)-1] {
+		t.Errorf("quantile(100) = %v, want the largest time %v", got, times[len(times)-1])
+	}
+
+	// Counting only whole buckets above the limit misses at most the times just over it.
+	above := countAbove(times, 1)
+	if got := d.countAbove(1); got > above || above-got > int64(len(times))/100 {
+		t.Errorf("countAbove(1) = %d, want at most %d and close to it", got, above)
+	}
+	if got := d.countAbove(100); got != 0 {
+		t.Errorf("countAbove(100) = %d, want 0", got)
+	}
+}
+
+func TestMaxLatencySamplesSummary(t *testing.T) {
+	srv := newRecordingServer(t)
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "50", "-max-latency-samples", "10")
+	if summary.TimedRequests != 50 || summary.RecentSamples != 10 {
+		t.Errorf("%d timed requests and %d recent samples, want 50 and 10", summary.TimedRequests, summary.RecentSamples)
+	}
+	if !summary.DigestPercentiles || summary.RecentLatency == nil {
+		t.Errorf("estimated percentiles %v, recent latency %v; want both reported:\n%s", summary.DigestPercentiles, summary.RecentLatency, out)
+	}
+	if summary.Percentile99 < summary.MinResponseTime || summary.Percentile99 > summary.MaxResponseTime {
+		t.Errorf("p99 %v outside the %v to %v range of the run", summary.Percentile99, summary.MinResponseTime, summary.MaxResponseTime)
+	}
+}
+
+func TestCacheStatsRecord(t *testing.T) {
+	c := newCacheStats()
+	c.record(http.Header{"Cache-Control": {"public", `MAX-AGE="300"`}})
+	c.record(http.Header{"Cache-Control": {"no-cache, no-store, max-age=0"}})
+	c.record(http.Header{})
+	if c.Responses != 3 || c.WithCacheControl != 2 || c.NoStore != 1 || c.NoCache != 1 || c.Private != 0 {
+		t.Errorf("counts = %+v", c)
+	}
+	if len(c.MaxAge) != 2 || c.MaxAge["300"] != 1 || c.MaxAge["0"] != 1 {
+		t.Errorf("max-age values = %v, want 300 and 0 once each", c.MaxAge)
+	}
+}
+
+func TestAnalyzeCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/static":
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+		case "/account":
+			w.Header().Set("Cache-Control", "private, no-cache, max-age=0")
+		case "/token":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+	}))
+	defer srv.Close()
+	var urls strings.Builder
+	for _, path := range []string{"/static", "/account", "/token", "/plain"} {
+		urls.WriteString(srv.URL + path + "\n")
+	}
+	file := filepath.Join(t.TempDir(), "urls.txt")
+	os.WriteFile(file, []byte(urls.String()), 0644)
+
+	summary, out := runSummary(t, "-urls-file", file, "-requests", "8", "-concurrency", "1", "-analyze-cache")
+	c := summary.Cache
+	if c == nil {
+		t.Fatalf("no cache analysis in the summary:\n%s", out)
+	}
+	if c.Responses != 8 || c.WithCacheControl != 6 || c.NoStore != 2 || c.NoCache != 2 || c.Private != 2 {
+		t.Errorf("counts = %+v", c)
+	}
+	if len(c.MaxAge) != 2 || c.MaxAge["3600"] != 2 || c.MaxAge["0"] != 2 {
+		t.Errorf("max-age values = %v, want 3600 and 0 twice each", c.MaxAge)
+	}
+
+	summary, _ = runSummary(t, "-urls-file", file, "-requests", "4")
+	if summary.Cache != nil {
+		t.Error("cache analysis reported without -analyze-cache")
+	}
+}
+
+func TestCacheBust(t *testing.T) {
+	srv := newRecordingServer(t)
+	runSummary(t, "-url", srv.URL+"/search?q=go+test&page=2", "-requests", "20", "-cache-bust")
+	first := cacheBustValues(t, srv)
+	if len(first) != 20 {
+		t.Fatalf("%d distinct _cb values over 20 requests, want 20", len(first))
+	}
+
+	// A second run must not reuse the first run's values.
+	runSummary(t, "-url", srv.URL+"/plain", "-requests", "5", "-cache-bust")
+	for value := range cacheBustValues(t, srv) {
+		if first[value] {
+			t.Errorf("_cb=%s was sent in both runs", value)
+		}
+	}
+}
+
+// cacheBustValues checks the requests srv received since it was last called
+// and returns their distinct _cb values.
+func cacheBustValues(t *testing.T, srv *recordingServer) map[string]bool {
+	t.Helper()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	values := make(map[string]bool)
+	for _, r := range srv.requests {
+		query := r.URL.Query()
+		if r.URL.Path == "/search" && (query.Get("q") != "go test" || query.Get("page") != "2") {
+			t.Errorf("existing query parameters not preserved in %s", r.URL)
+		}
+		if r.URL.Path == "/plain" && len(query) != 1 {
+			t.Errorf("query of %s, want only _cb", r.URL)
+		}
+		if cb := query["_cb"]; len(cb) != 1 || cb[0] == "" {
+			t.Errorf("%s does not carry one _cb value", r.URL)
+		} else {
+			values[cb[0]] = true
+		}
+	}
+	srv.requests = nil
+	return values
+}
+
+func TestTTFBSpike(t *testing.T) {
+	var mu sync.Mutex
+	var start time.Time
+	spiked := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if start.IsZero() {
+			start = time.Now()
+		}
+		// One response, a little over a second into the run, is slow to start.
+		spike := !spiked && time.Since(start) > 1100*time.Millisecond
+		if spike {
+			spiked = true
+		}
+		mu.Unlock()
+		if spike {
+			time.Sleep(300 * time.Millisecond)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-duration", "2500ms", "-concurrency", "1")
+	ttfb := summary.TTFB
+	if ttfb == nil {
+		t.Fatalf("no TTFB stats in the summary:\n%s", out)
+	}
+	if ttfb.Max < 0.3 || ttfb.MaxAtSecond != 1 {
+		t.Errorf("worst TTFB %.3fs at second %d, want the 300ms spike in second 1", ttfb.Max, ttfb.MaxAtSecond)
+	}
+	if ttfb.Avg > 0.05 || ttfb.StdDev <= 0 {
+		t.Errorf("TTFB avg %.4fs, std dev %.4fs; want a low average with jitter from the spike", ttfb.Avg, ttfb.StdDev)
+	}
+	if !strings.Contains(out, "Worst TTFB Spike         : ") || !strings.Contains(out, "at 1s into the run") {
+		t.Errorf("spike not reported:\n%s", out)
+	}
+}
+
+func TestTimingSampleStdDev(t *testing.T) {
+	var s timingSample
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		s.add(v)
+	}
+	if s.avg() != 5 || !approxEqual(s.stdDev(), 2) || s.max != 9 {
+		t.Errorf("avg %v, std dev %v, max %v; want 5, 2 and 9", s.avg(), s.stdDev(), s.max)
+	}
+}
+
+// newClosedPortURL returns an http URL on a local port nothing is listening on.
+func newClosedPortURL(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "http://" + addr + "/"
+}
+
+func TestNormalizeContentType(t *testing.T) {
+	tests := map[string]string{
+		"application/json":           "application/json",
+		"text/HTML; charset=UTF-8":   "text/html",
+		" text/plain ;format=flowed": "text/plain",
+		"":                           "(none)",
+	}
+	for in, want := range tests {
+		if got := normalizeContentType(in); got != want {
+			t.Errorf("normalizeContentType(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSplitByContentType(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		if n%3 == 0 {
+			time.Sleep(30 * time.Millisecond)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(strings.Repeat("x", 1000)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "9", "-concurrency", "1", "-split-by-content-type")
+	htmlStats, jsonStats := summary.ContentTypes["text/html"], summary.ContentTypes["application/json"]
+	if len(summary.ContentTypes) != 2 || htmlStats == nil || jsonStats == nil {
+		t.Fatalf("content types %v, want text/html and application/json:\n%s", summary.ContentTypes, out)
+	}
+	if htmlStats.Responses != 3 || htmlStats.TotalBytes != 3000 || htmlStats.AvgBytes != 1000 {
+		t.Errorf("text/html: %d responses, %d bytes, %.0f avg; want 3, 3000 and 1000", htmlStats.Responses, htmlStats.TotalBytes, htmlStats.AvgBytes)
+	}
+	if jsonStats.Responses != 6 || jsonStats.TotalBytes != 66 || jsonStats.AvgBytes != 11 {
+		t.Errorf("application/json: %d responses, %d bytes, %.0f avg; want 6, 66 and 11", jsonStats.Responses, jsonStats.TotalBytes, jsonStats.AvgBytes)
+	}
+	if htmlStats.Latency.P50 < 0.03 || jsonStats.Latency.P50 >= 0.03 {
+		t.Errorf("p50 latency %.4fs for text/html and %.4fs for application/json; want only text/html slow", htmlStats.Latency.P50, jsonStats.Latency.P50)
+	}
+}
+
+func TestAllRequestsFailed(t *testing.T) {
+	out, _ := runTool(t, "-url", newClosedPortURL(t), "-requests", "4", "-concurrency", "1", "-method", "BAD METHOD")
+	if !strings.Contains(out, "All 4 requests failed.") || !strings.Contains(out, `4x error creating request: net/http: invalid method "BAD METHOD"`) {
+		t.Errorf("no all-failed banner with the errors behind it:\n%s", out)
+	}
+	if !strings.Contains(out, "Not available: no request got far enough to be timed.") || strings.Contains(out, "Average Response Time") {
+		t.Errorf("latency metrics printed for requests that were never timed:\n%s", out)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "4", "-concurrency", "1")
+	if summary.FailedRequests != 4 || summary.StatusCodeDist[500] != 4 {
+		t.Errorf("%d failures, status codes %v; want four 500s", summary.FailedRequests, summary.StatusCodeDist)
+	}
+	if !strings.Contains(out, "All 4 requests failed.") || !strings.Contains(out, "Every request failed; these are the latencies of the failures.") {
+		t.Errorf("failure latencies not marked as such:\n%s", out)
+	}
+}
+
+func TestParseServerTiming(t *testing.T) {
+	got := parseServerTiming([]string{`db;dur=53, app;desc="App, total";dur=47.2`, `cache;desc=miss, db;dur=7`})
+	want := map[string]float64{"db": 0.060, "app": 0.0472}
+	if len(got) != len(want) {
+		t.Fatalf("parseServerTiming = %v, want %v", got, want)
+	}
+	for name, dur := range want {
+		if !approxEqual(got[name], dur) {
+			t.Errorf("%s = %v, want %v", name, got[name], dur)
+		}
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		timings := []string{fmt.Sprintf("db;dur=%d", 10*n), `app;desc="handler";dur=5`}
+		// Every request also reports a metric name of its own, which the
+		// name cap has to keep from being tracked without limit.
+		timings = append(timings, fmt.Sprintf("req%d;dur=1", n))
+		w.Header().Set("Server-Timing", strings.Join(timings, ", "))
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "40", "-concurrency", "1")
+	db, app := summary.ServerTiming["db"], summary.ServerTiming["app"]
+	if db == nil || app == nil {
+		t.Fatalf("server timings %v, want db and app:\n%s", summary.ServerTiming, out)
+	}
+	if !approxEqual(db.Avg, 0.205) || !approxEqual(db.Max, 0.4) || !approxEqual(app.Avg, 0.005) {
+		t.Errorf("db avg %v max %v, app avg %v; want 0.205, 0.4 and 0.005", db.Avg, db.Max, app.Avg)
+	}
+	if len(summary.ServerTiming) != maxServerTimingNames {
+		t.Errorf("%d metric names tracked, want the cap of %d", len(summary.ServerTiming), maxServerTimingNames)
+	}
+	if !strings.Contains(out, "later ones were ignored") {
+		t.Errorf("the cap on metric names is not reported:\n%s", out)
+	}
+}
+
+func TestTimeoutCategory(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/body" {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	// fetch sends one request the way sendRequest does, with a 100ms deadline,
+	// and returns the errors and timings timeoutCategory is given.
+	fetch := func(transport *http.Transport, path string) (reqErr, bodyErr error, timings *requestTimings) {
+		timings = &requestTimings{}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, timings.trace()), "GET", srv.URL+path, nil)
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		if err != nil {
+			return err, nil, timings
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return nil, err, timings
+	}
+	stalledDial := &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+
+	tests := []struct {
+		name      string
+		transport *http.Transport
+		path      string
+		want      string
+	}{
+		{"connect", stalledDial, "/", "connect-timeout"},
+		{"header", &http.Transport{}, "/", "header-timeout"},
+		{"body", &http.Transport{}, "/body", "body-timeout"},
+	}
+	for _, tt := range tests {
+		reqErr, bodyErr, timings := fetch(tt.transport, tt.path)
+		if got := timeoutCategory(reqErr, bodyErr, timings); got != tt.want {
+			t.Errorf("%s: timeoutCategory(%v, %v) = %q, want %q", tt.name, reqErr, bodyErr, got, tt.want)
+		}
+		tt.transport.CloseIdleConnections()
+	}
+
+	pollErr := fmt.Errorf("%w after 1s", errPollTimeout)
+	if got := timeoutCategory(pollErr, nil, &requestTimings{}); got != "total-timeout" {
+		t.Errorf("poll timeout classified as %q, want total-timeout", got)
+	}
+	if got := timeoutCategory(errors.New("connection refused"), nil, &requestTimings{}); got != "" {
+		t.Errorf("non-timeout classified as %q, want none", got)
+	}
+}
+
+func TestTimeoutsInSummary(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/job")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "2", "-concurrency", "2",
+		"-poll-until-status", "200", "-poll-interval", "20ms", "-poll-timeout", "100ms")
+	if summary.Timeouts["total-timeout"] != 2 || len(summary.Timeouts) != 1 {
+		t.Errorf("timeouts %v, want two total-timeouts", summary.Timeouts)
+	}
+	if !strings.Contains(out, "Timeouts (total-timeout) : 2") {
+		t.Errorf("timeout category not reported:\n%s", out)
+	}
+}
+
+func TestClampHistogram(t *testing.T) {
+	buckets := []*HistogramBucket{{Mark: 0.1, Count: 5}, {Mark: 0.25, Count: 2}, {Mark: 0.5, Count: 1}, {Mark: 1, Count: 3}, {Mark: math.Inf(1), Count: 4}}
+	clampHistogram(buckets, 0.3)
+	var got []int
+	for _, bucket := range buckets {
+		got = append(got, bucket.Count)
+	}
+	if fmt.Sprint(got) != "[5 2 8 0 0]" {
+		t.Errorf("clamped counts = %v, want [5 2 8 0 0]", got)
+	}
+}
+
+func TestClampMaxLatency(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		if n%5 == 0 {
+			time.Sleep(400 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "10", "-concurrency", "1", "-clamp-max-latency", "0.25")
+	clamped := summary.Clamped
+	if clamped == nil || clamped.Count != 2 || clamped.TrueMax < 0.4 || clamped.Ceiling != 0.25 {
+		t.Fatalf("clamped stats %+v, want 2 outliers over 0.25s with a true max of at least 0.4s", clamped)
+	}
+	var counts []int
+	for _, bucket := range summary.Histogram {
+		counts = append(counts, bucket.Count)
+	}
+	if fmt.Sprint(counts) != "[8 2 0 0 0 0 0 0]" {
+		t.Errorf("histogram counts = %v, want the outliers in the 0.25s bucket", counts)
+	}
+	if summary.MaxResponseTime < 0.4 || summary.CDF[len(summary.CDF)-1].Latency > 0.25 {
+		t.Errorf("max %.3fs, top of CDF %.3fs; want the true max kept and the CDF clamped", summary.MaxResponseTime, summary.CDF[len(summary.CDF)-1].Latency)
+	}
+	// Both percentiles land on an outlier, so both are the ceiling.
+	if summary.Percentile90 != 0.25 || summary.Percentile99 != 0.25 {
+		t.Errorf("p90 %.3fs, p99 %.3fs; want both clamped to 0.25s", summary.Percentile90, summary.Percentile99)
+	}
+	if !strings.Contains(out, "Clamped Outliers         : 2 over 0.2500s (true maximum 0.4") {
+		t.Errorf("outliers not reported:\n%s", out)
+	}
+}
+
+func TestRealizedConcurrency(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	summary, out := runSummary(t, "-url", slow.URL, "-duration", "1s", "-concurrency", "10")
+	c := summary.Concurrency
+	if c == nil {
+		t.Fatalf("no concurrency stats:\n%s", out)
+	}
+	if c.Limit != 10 || c.Max != 10 || c.Avg < 8 {
+		t.Errorf("slow server: limit %d, max %d, avg %.1f; want the cap of 10 reached and held", c.Limit, c.Max, c.Avg)
+	}
+	if len(c.Series) == 0 || c.Series[0] < 8 {
+		t.Errorf("slow server: per-second series %v, want close to 10", c.Series)
+	}
+	if !strings.Contains(out, "Realized Concurrency     : avg ") || !strings.Contains(out, "max 10 (limit 10)") {
+		t.Errorf("realized concurrency not reported:\n%s", out)
+	}
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+	summary, _ = runSummary(t, "-url", fast.URL, "-duration", "1s", "-concurrency", "10", "-per-worker-rps", "3")
+	if c := summary.Concurrency; c == nil || c.Avg > 1 {
+		t.Errorf("fast paced server: concurrency %+v, want an average well under the cap", c)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{"512": 512, "0": 0, "1KB": 1024, "1k": 1024, "5MB": 5 << 20, "2 MiB": 2 << 20, "1GB": 1 << 30, "64B": 64}
+	for in, want := range tests {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "KB", "1TB", "-5", "1.5MB", "99999999999GB"} {
+		if _, err := parseByteSize(bad); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestBodySize(t *testing.T) {
+	for _, fill := range []string{"random", "zero"} {
+		srv := newRecordingServer(t)
+		out, code := runTool(t, "-url", srv.URL, "-method", "POST", "-requests", "3", "-body-size", "3KB", "-body-fill", fill)
+		if code != 0 || len(srv.bodies) != 3 {
+			t.Fatalf("-body-fill %s: exit code %d, %d requests received:\n%s", fill, code, len(srv.bodies), out)
+		}
+		for i, body := range srv.bodies {
+			if srv.requests[i].ContentLength != 3072 || len(body) != 3072 {
+				t.Errorf("-body-fill %s: Content-Length %d, body of %d bytes; want 3072", fill, srv.requests[i].ContentLength, len(body))
+			}
+			zeros := bytes.Count(body, []byte{0})
+			if (fill == "zero") != (zeros == len(body)) {
+				t.Errorf("-body-fill %s: %d of %d bytes are zero", fill, zeros, len(body))
+			}
+		}
+	}
+}
+
+func TestBodySizeLimits(t *testing.T) {
+	out, code := runTool(t, "-url", "http://127.0.0.1:1/", "-requests", "1", "-body-size", "2GB")
+	if code != 1 || !strings.Contains(out, "Error: -body-size must be at most 1GiB") {
+		t.Errorf("exit code %d, want 1 and the 1GiB cap named:\n%s", code, out)
+	}
+	out, code = runTool(t, "-url", "http://127.0.0.1:1/", "-requests", "1", "-body-size", "1.5KB")
+	if code != 1 || !strings.Contains(out, "Error: invalid -body-size") {
+		t.Errorf("exit code %d, want a fractional size rejected:\n%s", code, out)
+	}
+	out, code = runTool(t, "-url", "http://127.0.0.1:1/", "-requests", "1", "-body-size", "1KB", "-body", "x")
+	if code != 1 || !strings.Contains(out, "mutually exclusive") {
+		t.Errorf("exit code %d, want -body-size and -body rejected together:\n%s", code, out)
+	}
+}
+
+func TestSoftDeadline(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		if n%2 == 0 {
+			select {
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "6", "-concurrency", "1", "-soft-deadline", "0.1")
+	// Uncut, the three slow requests alone would take 6s.
+	if summary.TotalTimeTaken > 2 {
+		t.Errorf("run took %.2fs; slow requests should have been cut off after 100ms", summary.TotalTimeTaken)
+	}
+	if summary.CancelledSlow != 3 || summary.SuccessfulRequests != 3 || summary.FailedRequests != 0 {
+		t.Errorf("%d cancelled slow, %d successes, %d failures; want 3, 3 and 0", summary.CancelledSlow, summary.SuccessfulRequests, summary.FailedRequests)
+	}
+	if len(summary.ErrorSummary) != 0 {
+		t.Errorf("cancelled requests were logged as errors: %v", summary.ErrorSummary)
+	}
+	if !strings.Contains(out, "Cancelled Slow           : 3 (over the 0.1s soft deadline") {
+		t.Errorf("cancelled requests not reported:\n%s", out)
+	}
+}
+
+func TestSoftDeadlinePolling(t *testing.T) {
+	// The first request succeeds at once; the second starts a job that never
+	// finishes, so only the soft deadline can end it.
+	var mu sync.Mutex
+	started := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first := !started
+		started = true
+		mu.Unlock()
+		if !first {
+			w.Header().Set("Location", "/job")
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "2", "-concurrency", "1", "-soft-deadline", "0.3",
+		"-poll-until-status", "200", "-poll-interval", "50ms", "-poll-timeout", "10s")
+	if summary.CancelledSlow != 1 || summary.PollTimeouts != 0 {
+		t.Errorf("%d cancelled slow, %d poll timeouts; want the polling cut off by the soft deadline:\n%s", summary.CancelledSlow, summary.PollTimeouts, out)
+	}
+	if summary.TotalTimeTaken > 5 {
+		t.Errorf("run took %.1fs; polling should stop at the 0.3s soft deadline", summary.TotalTimeTaken)
+	}
+}
+
+func TestComputeRPSStatsBursty(t *testing.T) {
+	// Bursts of 100 requests alternate with quiet seconds of 10, second 5 had no
+	// completions at all, and the run ended 0.4s into second 10.
+	var timeline []*TimelineInterval
+	for second := 0; second < 10; second++ {
+		if second == 5 {
+			continue
+		}
+		requests := 10
+		if second%2 == 0 {
+			requests = 100
+		}
+		timeline = append(timeline, &TimelineInterval{Second: second, Requests: requests})
+	}
+	timeline = append(timeline, &TimelineInterval{Second: 10, Requests: 3})
+
+	stats := computeRPSStats(timeline, 10.4)
+	if fmt.Sprint(stats.Series) != "[100 10 100 10 100 0 100 10 100 10 3]" {
+		t.Errorf("series = %v, want every second including the empty one and the partial last", stats.Series)
+	}
+	if stats.Min != 0 || stats.Max != 100 {
+		t.Errorf("min %v, max %v; want 0 and 100 from the complete seconds", stats.Min, stats.Max)
+	}
+	mean := 540.0 / 10
+	if stats.P10 != 10 || stats.P50 != 100 || stats.P90 != 100 {
+		t.Errorf("p10/p50/p90 = %v/%v/%v, want 10/100/100", stats.P10, stats.P50, stats.P90)
+	}
+	if stats.P10 > mean/2 || stats.StdDev < 40 {
+		t.Errorf("p10 %v, std dev %v against a mean of %v; want the bursts to show", stats.P10, stats.StdDev, mean)
+	}
+
+	if computeRPSStats(nil, 0) != nil {
+		t.Error("stats computed for an empty run")
+	}
+}
+
+func TestEmptyBodyContentLength(t *testing.T) {
+	tests := []struct {
+		args          []string
+		contentLength string // "" for no header
+	}{
+		{[]string{"-method", "GET"}, ""},
+		{[]string{"-method", "GET", "-chunked-request"}, ""},
+		{[]string{"-method", "POST"}, "0"},
+		{[]string{"-method", "POST", "-body", "abc"}, "3"},
+	}
+	for _, tt := range tests {
+		srv := newRecordingServer(t)
+		out, code := runTool(t, append([]string{"-url", srv.URL, "-requests", "1"}, tt.args...)...)
+		if code != 0 || len(srv.requests) != 1 {
+			t.Fatalf("%v: exit code %d, %d requests:\n%s", tt.args, code, len(srv.requests), out)
+		}
+		req := srv.requests[0]
+		if got := req.Header.Get("Content-Length"); got != tt.contentLength {
+			t.Errorf("%v: Content-Length %q, want %q", tt.args, got, tt.contentLength)
+		}
+		if len(req.TransferEncoding) != 0 {
+			t.Errorf("%v: Transfer-Encoding %v, want none", tt.args, req.TransferEncoding)
+		}
+	}
+}
+
+func TestErrorExamples(t *testing.T) {
+	e := newErrorExamples(2)
+	for _, msg := range []string{"a", "a", "b", "c", "d"} {
+		e.add("dns", msg)
+	}
+	e.add("tls", "bad certificate")
+	got := e.categories()
+	if dns := got["dns"]; dns.Count != 5 || strings.Join(dns.Examples, ",") != "a,b" {
+		t.Errorf("dns: %d errors, examples %q; want 5 and the first two distinct", dns.Count, dns.Examples)
+	}
+	if tlsErrs := got["tls"]; tlsErrs.Count != 1 || len(tlsErrs.Examples) != 1 {
+		t.Errorf("tls: %+v, want one error with its example", tlsErrs)
+	}
+	var none *errorExamples
+	if none.categories() != nil {
+		t.Error("categories of a nil errorExamples is not nil")
+	}
+}
+
+func TestErrorsPerCategory(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		switch n % 10 {
+		case 0:
+			// The rare failure: a 200 whose body fails the assertion.
+			w.Write([]byte(`{"ok":false}`))
+		case 1, 4, 7:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 2, 5, 8:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "200", "-concurrency", "4", "-assert-jsonpath", "$.ok=true", "-errors-per-category", "2")
+	status, assertion := summary.ErrorCategories["http-status"], summary.ErrorCategories["assertion"]
+	if status == nil || assertion == nil {
+		t.Fatalf("error categories %v, want http-status and assertion:\n%s", summary.ErrorCategories, out)
+	}
+	if status.Count != 180 || len(status.Examples) != 2 {
+		t.Errorf("http-status: %d errors, examples %q; want 180 and 2 examples", status.Count, status.Examples)
+	}
+	if assertion.Count != 20 || len(assertion.Examples) != 1 || !strings.Contains(assertion.Examples[0], "$.ok") {
+		t.Errorf("assertion: %d errors, examples %q; want 20 and the one distinct message", assertion.Count, assertion.Examples)
+	}
+	if !strings.Contains(out, "Errors by Category") || !strings.Contains(out, "http-status (180):\n") || !strings.Contains(out, "assertion (20):\n") {
+		t.Errorf("categories not reported:\n%s", out)
+	}
+}
+
+func TestConnectionFailures(t *testing.T) {
+	summary, out := runSummary(t, "-url", newClosedPortURL(t), "-requests", "4", "-concurrency", "1")
+	if summary.ConnectionFailures != 4 || summary.FailedRequests != 4 {
+		t.Errorf("closed port: %d connection failures of %d failures, want 4 of 4", summary.ConnectionFailures, summary.FailedRequests)
+	}
+	if !strings.Contains(out, "Connection Failures      : 4") || !strings.Contains(out, "Request Failures         : 0") {
+		t.Errorf("closed port: failures not split:\n%s", out)
+	}
+
+	// This server accepts each connection and then drops it without a response.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+	summary, out = runSummary(t, "-url", srv.URL, "-method", "POST", "-requests", "3", "-concurrency", "1")
+	if summary.ConnectionFailures != 0 || summary.FailedRequests != 3 {
+		t.Errorf("dropped connections: %d connection failures of %d failures, want 0 of 3", summary.ConnectionFailures, summary.FailedRequests)
+	}
+	if !strings.Contains(out, "Request Failures         : 3") {
+		t.Errorf("dropped connections: failures not split:\n%s", out)
+	}
+}
+
+func TestIsConnectFailure(t *testing.T) {
+	connected := &requestTimings{gotConn: time.Now()}
+	tests := []struct {
+		err     error
+		timings *requestTimings
+		want    bool
+	}{
+		{nil, &requestTimings{}, false},
+		{errors.New("connection refused"), &requestTimings{}, true},
+		{errors.New("EOF"), connected, false},
+		{context.Canceled, &requestTimings{}, false},
+	}
+	for _, tt := range tests {
+		if got := isConnectFailure(tt.err, tt.timings); got != tt.want {
+			t.Errorf("isConnectFailure(%v, connected=%v) = %v, want %v", tt.err, !tt.timings.gotConn.IsZero(), got, tt.want)
+		}
+	}
+}
+
+func TestExpectSHA256(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		if n%3 == 0 {
+			w.Write([]byte("app.js v1")) // a stale copy
+			return
+		}
+		w.Write([]byte("app.js v2"))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte("app.js v2"))
+	expected := strings.ToUpper(hex.EncodeToString(sum[:]))
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "6", "-concurrency", "1", "-expect-sha256", expected)
+	if summary.SuccessfulRequests != 4 || summary.FailedRequests != 2 || summary.IntegrityFailures != 2 {
+		t.Errorf("%d successes, %d failures, %d integrity failures; want 4, 2 and 2", summary.SuccessfulRequests, summary.FailedRequests, summary.IntegrityFailures)
+	}
+	stale := sha256.Sum256([]byte("app.js v1"))
+	if !strings.Contains(out, "Integrity Failures       : 2") || !strings.Contains(out, hex.EncodeToString(stale[:])) {
+		t.Errorf("integrity failures not reported with the mismatching checksum:\n%s", out)
+	}
+
+	for _, bad := range []string{"abc", strings.Repeat("zz", 32)} {
+		out, code := runTool(t, "-url", srv.URL, "-requests", "1", "-expect-sha256", bad)
+		if code != 1 || !strings.Contains(out, "Error: -expect-sha256 must be 64 hexadecimal digits.") {
+			t.Errorf("-expect-sha256 %s: exit code %d, want 1:\n%s", bad, code, out)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	tests := []struct {
+		done, total int64
+		elapsed     float64
+		percent     float64
+		eta         time.Duration
+	}{
+		{250, 1000, 5, 25, 15 * time.Second}, // 50 req/s, 750 to go
+		{999, 1000, 9.99, 99.9, 10 * time.Millisecond},
+		{1000, 1000, 20, 100, 0},
+		{1200, 1000, 20, 100, 0}, // overshoot from requests already in flight
+		{0, 1000, 2, 0, -1},      // no rate yet
+		{10, 0, 2, 0, -1},
+	}
+	for _, tt := range tests {
+		percent, eta := progress(tt.done, tt.total, tt.elapsed)
+		if !approxEqual(percent, tt.percent) || (eta-tt.eta).Abs() > time.Microsecond {
+			t.Errorf("progress(%d, %d, %v) = %v%%, %v; want %v%%, %v", tt.done, tt.total, tt.elapsed, percent, eta, tt.percent, tt.eta)
+		}
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	tests := map[float64]string{0: "[----------]", 25: "[##--------]", 99.9: "[#########-]", 100: "[##########]", 120: "[##########]"}
+	for percent, want := range tests {
+		if got := progressBar(percent, 10); got != want {
+			t.Errorf("progressBar(%v, 10) = %q, want %q", percent, got, want)
+		}
+	}
+}
+
+func TestP99ConfidenceInterval(t *testing.T) {
+	sorted := make([]float64, 1000)
+	for i := range sorted {
+		sorted[i] = float64(i) / 1000
+	}
+	lower, upper, ok := p99ConfidenceInterval(sorted)
+	// n·p = 990 ± 1.96·√9.9 ≈ 6.17, so the bounds are the 983rd and 997th values.
+	if !ok || !approxEqual(lower, 0.983) || !approxEqual(upper, 0.997) {
+		t.Errorf("interval = [%v, %v], %v; want [0.983, 0.997]", lower, upper, ok)
+	}
+	if _, _, ok := p99ConfidenceInterval(sorted[:300]); ok {
+		t.Error("interval computed from 300 samples, too few for the upper bound")
+	}
+}
+
+func TestUntilCIWidth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-until-ci-width", "0.01", "-max-requests", "1000000", "-concurrency", "4")
+	ci := summary.P99CI
+	if ci == nil || ci.Width > 0.01 || ci.Target != 0.01 {
+		t.Fatalf("p99 interval %+v, want one within the 0.01s target:\n%s", ci, out)
+	}
+	if summary.TotalRequestsSent >= 1000000 || summary.AbortReason != "" {
+		t.Errorf("%d requests sent, abort reason %q; want the run stopped by the interval, under the cap", summary.TotalRequestsSent, summary.AbortReason)
+	}
+	if !strings.Contains(out, "Stopping: the 95% confidence interval of p99 is") {
+		t.Errorf("the reason for stopping is not reported:\n%s", out)
+	}
+
+	// Too few responses for an interval at all, so only the cap can end the run.
+	summary, out = runSummary(t, "-url", srv.URL, "-until-ci-width", "0.01", "-max-requests", "300", "-concurrency", "4")
+	if summary.TotalRequestsSent != 300 || summary.P99CI != nil {
+		t.Errorf("%d requests sent, interval %+v; want the 300-request cap reached without one", summary.TotalRequestsSent, summary.P99CI)
+	}
+	if !strings.Contains(out, "not available: too few responses") {
+		t.Errorf("the missing interval is not reported:\n%s", out)
+	}
+
+	if out, code := runTool(t, "-url", srv.URL, "-until-ci-width", "0.01", "-max-latency-samples", "10"); code != 1 {
+		t.Errorf("-until-ci-width with -max-latency-samples: exit code %d, want 1:\n%s", code, out)
+	}
+}
+
+func TestRawLatencies(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		time.Sleep(time.Duration(n%4) * 5 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	textPath, binaryPath := filepath.Join(dir, "latencies.txt"), filepath.Join(dir, "latencies.bin")
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "20", "-concurrency", "2", "-raw-latencies", textPath)
+	data, err := os.ReadFile(textPath)
+	if err != nil {
+		t.Fatalf("no raw latencies file: %v\n%s", err, out)
+	}
+	var values []float64
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		v, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		values = append(values, v)
+	}
+	if len(values) != 20 || !sort.Float64sAreSorted(values) {
+		t.Fatalf("%d values, sorted %v; want 20 in order", len(values), sort.Float64sAreSorted(values))
+	}
+	if values[0] != summary.MinResponseTime || values[19] != summary.MaxResponseTime || !approxEqual(average(values), summary.AvgResponseTime) {
+		t.Errorf("min %v, max %v, avg %v; the summary has %v, %v and %v",
+			values[0], values[19], average(values), summary.MinResponseTime, summary.MaxResponseTime, summary.AvgResponseTime)
+	}
+
+	runTool(t, "-url", srv.URL, "-requests", "20", "-concurrency", "2", "-raw-latencies", binaryPath, "-raw-latencies-format", "binary")
+	data, err = os.ReadFile(binaryPath)
+	if err != nil || len(data) != 20*8 {
+		t.Fatalf("binary file of %d bytes (%v), want 20 float64s", len(data), err)
+	}
+	decoded := make([]float64, 20)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !sort.Float64sAreSorted(decoded) || decoded[0] <= 0 || decoded[19] < 0.015 {
+		t.Errorf("decoded latencies %v, want the 20 sorted response times", decoded)
+	}
+
+	out, code := runTool(t, "-url", srv.URL, "-requests", "1", "-raw-latencies", textPath, "-max-latency-samples", "10")
+	if code != 1 {
+		t.Errorf("-raw-latencies with -max-latency-samples: exit code %d, want 1:\n%s", code, out)
+	}
+}
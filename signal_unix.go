@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyInterimSummary relays SIGUSR1, which asks for an interim summary, to c.
+func notifyInterimSummary(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,63 @@
+//go:build !windows
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterimSummaryOnSIGUSR1(t *testing.T) {
+	var mu sync.Mutex
+	var served []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		served = append(served, time.Now())
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	cmd := toolCommand(t, "-url", srv.URL, "-duration", "2s", "-concurrency", "2")
+	var out strings.Builder
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	signalledAt := time.Now()
+	if err := cmd.Process.Signal(syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("run did not survive SIGUSR1: %v\n%s", err, out.String())
+	}
+
+	report := out.String()
+	interim := strings.Index(report, "Interim Summary")
+	final := strings.Index(report, "Load Test Summary")
+	if interim < 0 || final < interim {
+		t.Fatalf("want an interim summary before the final one:\n%s", report)
+	}
+	if !strings.Contains(report[interim:final], "Total Requests Sent      : ") {
+		t.Errorf("interim summary has no request count:\n%s", report[interim:final])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	after := 0
+	for _, at := range served {
+		if at.After(signalledAt.Add(100 * time.Millisecond)) {
+			after++
+		}
+	}
+	if after == 0 {
+		t.Error("no requests were served after the interim summary")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// notifyInterimSummary does nothing on Windows, which has no SIGUSR1.
+func notifyInterimSummary(c chan<- os.Signal) {}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// SlowRequest describes one of the slowest requests of a run, for -top-slowest.
+type SlowRequest struct {
+	URL       string    `json:"url"`
+	Status    int       `json:"status"` // 0 if no response arrived
+	Latency   float64   `json:"latency"`
+	StartedAt time.Time `json:"startedAt"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// slowestRequests keeps the limit slowest requests seen so far in a min-heap, so
+// the fastest of them is the one replaced when a slower request comes along.
+type slowestRequests struct {
+	limit int
+	heap  slowHeap
+}
+
+func newSlowestRequests(limit int) *slowestRequests {
+	return &slowestRequests{limit: limit}
+}
+
+// wants reports whether a request with this latency would be kept. It returns
+// false for a nil receiver, so callers need not check whether -top-slowest is set.
+func (s *slowestRequests) wants(latency float64) bool {
+	if s == nil {
+		return false
+	}
+	return len(s.heap) < s.limit || latency > s.heap[0].Latency
+}
+
+func (s *slowestRequests) add(r SlowRequest) {
+	if len(s.heap) < s.limit {
+		heap.Push(&s.heap, r)
+		return
+	}
+	if r.Latency > s.heap[0].Latency {
+		s.heap[0] = r
+		heap.Fix(&s.heap, 0)
+	}
+}
+
+// sorted returns the kept requests, slowest first. It returns nil for a nil
+// receiver, so callers need not check whether -top-slowest is set.
+func (s *slowestRequests) sorted() []SlowRequest {
+	if s == nil {
+		return nil
+	}
+	out := append([]SlowRequest(nil), s.heap...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Latency > out[j].Latency })
+	return out
+}
+
+// slowHeap implements heap.Interface ordered by latency, fastest on top.
+type slowHeap []SlowRequest
+
+func (h slowHeap) Len() int            { return len(h) }
+func (h slowHeap) Less(i, j int) bool  { return h[i].Latency < h[j].Latency }
+func (h slowHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *slowHeap) Push(x interface{}) { *h = append(*h, x.(SlowRequest)) }
+
+func (h *slowHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlowestRequests(t *testing.T) {
+	s := newSlowestRequests(3)
+	for _, latency := range []float64{5, 1, 9, 3, 7, 2, 8} {
+		if s.wants(latency) {
+			s.add(SlowRequest{Latency: latency})
+		}
+	}
+	var got []float64
+	for _, r := range s.sorted() {
+		got = append(got, r.Latency)
+	}
+	if fmt.Sprint(got) != "[9 8 7]" {
+		t.Errorf("slowest = %v, want [9 8 7]", got)
+	}
+	if s.wants(6) || !s.wants(7.5) {
+		t.Errorf("wants(6) = %v, wants(7.5) = %v; want only latencies above the fastest kept", s.wants(6), s.wants(7.5))
+	}
+
+	var none *slowestRequests
+	if none.wants(100) || none.sorted() != nil {
+		t.Error("a nil slowestRequests keeps requests")
+	}
+}
+
+func TestTopSlowest(t *testing.T) {
+	// Request n sleeps for delays[n-1] and answers with status 200+n, so the
+	// slowest can be told apart in the summary.
+	delays := []int{30, 5, 80, 10, 60, 0, 45, 20, 70, 15}
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		n := count
+		mu.Unlock()
+		time.Sleep(time.Duration(delays[n-1]) * time.Millisecond)
+		w.WriteHeader(200 + n)
+	}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "10", "-concurrency", "1", "-top-slowest", "3")
+	slowest := summary.SlowestRequests
+	if len(slowest) != 3 {
+		t.Fatalf("%d slowest requests, want 3:\n%s", len(slowest), out)
+	}
+	for i, want := range []struct {
+		status int
+		delay  float64
+	}{{203, 0.08}, {209, 0.07}, {205, 0.06}} {
+		r := slowest[i]
+		if r.Status != want.status || r.Latency < want.delay || r.URL != srv.URL || r.StartedAt.IsZero() {
+			t.Errorf("slowest[%d] = %+v, want status %d taking at least %vs", i, r, want.status, want.delay)
+		}
+	}
+	if !strings.Contains(out, "Top 3 Slowest Requests\n----------------------\n") || !strings.Contains(out, " 1. 0.08") {
+		t.Errorf("slowest requests not printed, slowest first:\n%s", out)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// targetSource supplies the URL each request is sent to.
+type targetSource interface {
+	pick() (string, error)
+}
+
+// urlPool rotates through a fixed set of URLs, one per request.
+type urlPool struct {
+	urls []string
+	next uint64
+}
+
+func (p *urlPool) pick() (string, error) {
+	i := atomic.AddUint64(&p.next, 1) - 1
+	return p.urls[i%uint64(len(p.urls))], nil
+}
+
+// urlStream reads URLs from a file one line at a time as requests need them, so a
+// file of any size is used with constant memory. It starts over at the end.
+type urlStream struct {
+	mu     sync.Mutex
+	file   *os.File
+	reader *bufio.Reader
+	found  bool // whether any URL has been read since the last rewind
+}
+
+func openURLStream(path string) (*urlStream, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &urlStream{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+func (s *urlStream) pick() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		line, err := s.reader.ReadString('\n')
+		if target, ok := urlLine(line); ok {
+			s.found = true
+			return target, validateURL(target, false)
+		}
+		if err == io.EOF {
+			if !s.found {
+				return "", fmt.Errorf("no URLs in %s", s.file.Name())
+			}
+			if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+				return "", err
+			}
+			s.reader.Reset(s.file)
+			s.found = false
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// loadURLs reads the URLs in path. With sample > 0 it keeps a uniform random
+// sample of that many (reservoir sampling), so memory is bounded by the sample
+// rather than the file.
+func loadURLs(path string, sample int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var urls []string
+	seen := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		target, ok := urlLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		seen++
+		switch {
+		case sample <= 0 || len(urls) < sample:
+			urls = append(urls, target)
+		default:
+			// Keep each of the seen URLs with equal probability sample/seen.
+			if j := mrand.Intn(seen); j < sample {
+				urls[j] = target
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no URLs in %s", path)
+	}
+	for _, target := range urls {
+		if err := validateURL(target, false); err != nil {
+			return nil, err
+		}
+	}
+	return urls, nil
+}
+
+// urlLine extracts the URL from one line of a -urls-file, skipping blank lines
+// and # comments.
+func urlLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	return line, true
+}
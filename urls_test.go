@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeURLsFile writes n URLs of the form base/item/<i> to a file, with a comment
+// and a blank line mixed in, and returns its path.
+func writeURLsFile(t *testing.T, base string, n int) string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("# generated\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%s/item/%d\n", base, i)
+	}
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadURLsSample(t *testing.T) {
+	path := writeURLsFile(t, "http://example.com", 100000)
+
+	urls, err := loadURLs(path, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sample is all that is kept, however large the file.
+	if len(urls) != 50 || cap(urls) > 100 {
+		t.Fatalf("kept %d URLs with capacity %d, want 50", len(urls), cap(urls))
+	}
+	distinct := make(map[string]bool)
+	late := 0
+	for _, u := range urls {
+		distinct[u] = true
+		var i int
+		if _, err := fmt.Sscanf(u, "http://example.com/item/%d", &i); err != nil {
+			t.Fatalf("sampled %q, which is not from the file", u)
+		}
+		if i >= 50000 {
+			late++
+		}
+	}
+	if len(distinct) != 50 {
+		t.Errorf("%d distinct URLs in a sample of 50", len(distinct))
+	}
+	// A uniform sample takes about half its URLs from the second half of the file.
+	if late < 10 || late > 40 {
+		t.Errorf("%d of 50 sampled URLs come from the second half of the file, want about 25", late)
+	}
+
+	all, err := loadURLs(path, 0)
+	if err != nil || len(all) != 100000 {
+		t.Errorf("without sampling: %d URLs, %v; want all 100000", len(all), err)
+	}
+}
+
+func TestURLStream(t *testing.T) {
+	stream, err := openURLStream(writeURLsFile(t, "http://example.com", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.file.Close()
+	var got []string
+	for i := 0; i < 7; i++ {
+		u, err := stream.pick()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.TrimPrefix(u, "http://example.com/item/"))
+	}
+	// The stream starts over when it reaches the end of the file.
+	if strings.Join(got, ",") != "0,1,2,0,1,2,0" {
+		t.Errorf("picked items %v, want 0,1,2 in rotation", got)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.txt")
+	os.WriteFile(empty, []byte("# nothing here\n\n"), 0644)
+	stream, err = openURLStream(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.file.Close()
+	if _, err := stream.pick(); err == nil || !strings.Contains(err.Error(), "no URLs in") {
+		t.Errorf("pick from an empty file = %v, want a no URLs error", err)
+	}
+}
+
+func TestSampleURLsDistinctTargets(t *testing.T) {
+	srv := newRecordingServer(t)
+	path := writeURLsFile(t, srv.URL, 10000)
+	runSummary(t, "-urls-file", path, "-sample-urls", "5", "-requests", "40")
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	paths := make(map[string]bool)
+	for _, r := range srv.requests {
+		paths[r.URL.Path] = true
+	}
+	if len(srv.requests) != 40 || len(paths) != 5 {
+		t.Errorf("%d requests to %d distinct URLs, want 40 to 5", len(srv.requests), len(paths))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// warmUp resolves the target's host and, for an https target, completes one TLS
+// handshake with tlsConfig before the run, so that its session cache holds a
+// ticket the measured requests can resume instead of each paying for a full
+// handshake on first contact. Certificates of the insecure hosts are not verified.
+func warmUp(ctx context.Context, target string, tlsConfig *tls.Config, insecure insecureHosts) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+
+	start := time.Now()
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return fmt.Errorf("DNS lookup: %w", err)
+	}
+	fmt.Printf("%sWarm-up:%s resolved %s in %s\n", ColorYellow, ColorReset, host, time.Since(start).Round(time.Microsecond))
+	if u.Scheme != "https" {
+		return nil
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	// The transport caches sessions under the server name, so the warm-up
+	// handshake must use the same one, and offer the same protocols.
+	config := tlsConfig.Clone()
+	config.ServerName = host
+	if len(insecure) > 0 {
+		insecure.apply(config, host)
+	}
+	config.NextProtos = []string{"h2", "http/1.1"}
+	dialer := &tls.Dialer{Config: config}
+	start = time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("TLS handshake: %w", err)
+	}
+	elapsed := time.Since(start)
+	// TLS 1.3 session tickets arrive after the handshake and are only processed
+	// when the connection is read.
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	conn.Read(make([]byte, 1))
+	conn.Close()
+	fmt.Printf("%sWarm-up:%s TLS handshake with %s in %s\n", ColorYellow, ColorReset, host, elapsed.Round(time.Microsecond))
+	return nil
+}
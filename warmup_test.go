@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWarmTLSResumesSession(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	// Trust the test server's certificate in the child process.
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", ca)
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "5", "-concurrency", "1", "-warm-tls")
+	if summary.SuccessfulRequests != 5 {
+		t.Fatalf("%d successful requests, want 5:\n%s", summary.SuccessfulRequests, out)
+	}
+	if summary.TLSHandshakes != 1 || summary.TLSResumed != 1 {
+		t.Errorf("%d handshakes, %d resumed; want the one handshake to resume the warm-up session", summary.TLSHandshakes, summary.TLSResumed)
+	}
+	if !strings.Contains(out, "Warm-up: resolved 127.0.0.1") || !strings.Contains(out, "Warm-up: TLS handshake with 127.0.0.1") {
+		t.Errorf("warm-up steps not reported:\n%s", out)
+	}
+
+	summary, out = runSummary(t, "-url", srv.URL, "-requests", "5", "-concurrency", "1")
+	if summary.TLSHandshakes != 1 || summary.TLSResumed != 0 {
+		t.Errorf("without -warm-tls: %d handshakes, %d resumed; want one full handshake", summary.TLSHandshakes, summary.TLSResumed)
+	}
+	if strings.Contains(out, "Warm-up:") {
+		t.Errorf("warm-up ran without -warm-tls:\n%s", out)
+	}
+}
+
+func TestWarmTLSPlainHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	summary, out := runSummary(t, "-url", srv.URL, "-requests", "2", "-warm-tls")
+	if summary.SuccessfulRequests != 2 || summary.TLSHandshakes != 0 {
+		t.Errorf("%d successes, %d TLS handshakes; want 2 and 0", summary.SuccessfulRequests, summary.TLSHandshakes)
+	}
+	if !strings.Contains(out, "Warm-up: resolved 127.0.0.1") || strings.Contains(out, "TLS handshake with") {
+		t.Errorf("an http target should only be resolved:\n%s", out)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsMetrics holds the data collected from a -websocket run.
+type wsMetrics struct {
+	ConnectTimes     []float64
+	RoundTripTimes   []float64
+	ConnectFailures  int64
+	Disconnects      int64
+	MessagesSent     int64
+	MessagesReceived int64
+	ErrorLog         []string
+	Lock             sync.Mutex
+}
+
+func (m *wsMetrics) logError(err error) {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+	if len(m.ErrorLog) < 100 {
+		m.ErrorLog = append(m.ErrorLog, err.Error())
+	}
+}
+
+// WebSocketSummary holds the final results of a -websocket run.
+type WebSocketSummary struct {
+	Connections         int      `json:"connections"`
+	ConnectionsOpened   int      `json:"connectionsOpened"`
+	ConnectFailures     int64    `json:"connectFailures"`
+	Disconnects         int64    `json:"disconnects"`
+	MessagesSent        int64    `json:"messagesSent"`
+	MessagesReceived    int64    `json:"messagesReceived"`
+	TotalTimeTaken      float64  `json:"totalTimeTaken"`
+	AvgConnectTime      float64  `json:"avgConnectTime"`
+	Percentile99Connect float64  `json:"percentile99ConnectTime"`
+	MaxConnectTime      float64  `json:"maxConnectTime"`
+	AvgRoundTrip        float64  `json:"avgRoundTrip"`
+	Percentile50RTT     float64  `json:"percentile50RoundTrip"`
+	Percentile99RTT     float64  `json:"percentile99RoundTrip"`
+	MaxRoundTrip        float64  `json:"maxRoundTrip"`
+	ErrorSummary        []string `json:"errorSummary"`
+}
+
+// runWebSocket holds -concurrency WebSocket connections open until ctx ends. With
+// -ws-message, each connection sends the message every -ws-interval and times the
+// reply; connections the server drops are counted and reopened.
+func runWebSocket(ctx context.Context, cfg *Config) *WebSocketSummary {
+	m := &wsMetrics{}
+	startTime := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				wsSession(ctx, cfg, m)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+	sort.Float64s(m.ConnectTimes)
+	sort.Float64s(m.RoundTripTimes)
+	return &WebSocketSummary{
+		Connections:         cfg.Concurrency,
+		ConnectionsOpened:   len(m.ConnectTimes),
+		ConnectFailures:     m.ConnectFailures,
+		Disconnects:         m.Disconnects,
+		MessagesSent:        m.MessagesSent,
+		MessagesReceived:    m.MessagesReceived,
+		TotalTimeTaken:      time.Since(startTime).Seconds(),
+		AvgConnectTime:      average(m.ConnectTimes),
+		Percentile99Connect: percentile(m.ConnectTimes, 99),
+		MaxConnectTime:      max(m.ConnectTimes),
+		AvgRoundTrip:        average(m.RoundTripTimes),
+		Percentile50RTT:     percentile(m.RoundTripTimes, 50),
+		Percentile99RTT:     percentile(m.RoundTripTimes, 99),
+		MaxRoundTrip:        max(m.RoundTripTimes),
+		ErrorSummary:        m.ErrorLog,
+	}
+}
+
+// wsSession opens one connection and uses it until ctx ends or the connection fails.
+func wsSession(ctx context.Context, cfg *Config, m *wsMetrics) {
+	wsConfig, err := websocket.NewConfig(cfg.URL, wsOrigin(cfg.URL))
+	if err != nil {
+		m.logError(err)
+		m.Lock.Lock()
+		m.ConnectFailures++
+		m.Lock.Unlock()
+		return
+	}
+	header := http.Header{}
+	applyHeaders(header, cfg)
+	wsConfig.Header = header
+	if len(cfg.InsecureHosts) > 0 {
+		wsConfig.TlsConfig = &tls.Config{}
+		cfg.InsecureHosts.apply(wsConfig.TlsConfig, wsConfig.Location.Hostname())
+	}
+
+	connectStart := time.Now()
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		m.logError(err)
+		m.Lock.Lock()
+		m.ConnectFailures++
+		m.Lock.Unlock()
+		// Back off briefly so a refusing server isn't hammered with dials.
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+		return
+	}
+	m.Lock.Lock()
+	m.ConnectTimes = append(m.ConnectTimes, time.Since(connectStart).Seconds())
+	m.Lock.Unlock()
+
+	// Closing the connection is the only way to interrupt a blocked read.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	if cfg.WSMessage == "" {
+		var discard []byte
+		for {
+			if err := websocket.Message.Receive(conn, &discard); err != nil {
+				wsDisconnected(ctx, m, err)
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(cfg.WSInterval)
+	defer ticker.Stop()
+	for {
+		sent := time.Now()
+		if err := websocket.Message.Send(conn, cfg.WSMessage); err != nil {
+			wsDisconnected(ctx, m, err)
+			return
+		}
+		m.Lock.Lock()
+		m.MessagesSent++
+		m.Lock.Unlock()
+
+		var reply string
+		if err := websocket.Message.Receive(conn, &reply); err != nil {
+			wsDisconnected(ctx, m, err)
+			return
+		}
+		m.Lock.Lock()
+		m.MessagesReceived++
+		m.RoundTripTimes = append(m.RoundTripTimes, time.Since(sent).Seconds())
+		m.Lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wsDisconnected records a connection that ended before the run did.
+func wsDisconnected(ctx context.Context, m *wsMetrics, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	m.logError(err)
+	m.Lock.Lock()
+	m.Disconnects++
+	m.Lock.Unlock()
+}
+
+// wsOrigin derives the Origin header a browser would send for a ws:// or wss:// URL.
+func wsOrigin(url string) string {
+	if strings.HasPrefix(url, "wss://") {
+		return "https://" + strings.TrimPrefix(url, "wss://")
+	}
+	return "http://" + strings.TrimPrefix(url, "ws://")
+}
+
+func printWebSocketSummary(summary *WebSocketSummary, cfg *Config) {
+	unit := cfg.LatencyUnit
+	fmt.Printf("\n\n%sWebSocket Test Summary%s\n%s=======================%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	fmt.Printf("Concurrent Connections   : %s%d%s\n", ColorCyan, summary.Connections, ColorReset)
+	fmt.Printf("Connections Opened       : %s%d%s\n", ColorGreen, summary.ConnectionsOpened, ColorReset)
+	fmt.Printf("Connect Failures         : %s%d%s\n", ColorRed, summary.ConnectFailures, ColorReset)
+	fmt.Printf("Disconnects              : %s%d%s\n", ColorRed, summary.Disconnects, ColorReset)
+	fmt.Printf("Total Time Taken         : %.2f seconds\n", summary.TotalTimeTaken)
+
+	fmt.Printf("\n%sConnection Establishment%s\n%s------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+	fmt.Printf("Average Connect Time     : %s%s%s\n", ColorCyan, unit.format(summary.AvgConnectTime), ColorReset)
+	fmt.Printf("99th Percentile          : %s\n", unit.format(summary.Percentile99Connect))
+	fmt.Printf("Maximum Connect Time     : %s\n", unit.format(summary.MaxConnectTime))
+
+	if cfg.WSMessage != "" {
+		fmt.Printf("\n%sMessage Round Trips%s\n%s-------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		fmt.Printf("Messages Sent            : %d\n", summary.MessagesSent)
+		fmt.Printf("Replies Received         : %d\n", summary.MessagesReceived)
+		fmt.Printf("Average Round Trip       : %s%s%s\n", ColorCyan, unit.format(summary.AvgRoundTrip), ColorReset)
+		fmt.Printf("50th Percentile          : %s\n", unit.format(summary.Percentile50RTT))
+		fmt.Printf("99th Percentile          : %s\n", unit.format(summary.Percentile99RTT))
+		fmt.Printf("Maximum Round Trip       : %s\n", unit.format(summary.MaxRoundTrip))
+	}
+
+	if len(summary.ErrorSummary) > 0 {
+		fmt.Printf("\n%sError Summary (first 100)%s\n%s--------------------------%s\n", ColorYellow, ColorReset, ColorYellow, ColorReset)
+		for i, err := range summary.ErrorSummary {
+			fmt.Printf("%s%d. %s%s\n", ColorRed, i+1, err, ColorReset)
+		}
+	}
+
+	if cfg.OutputFile != "" {
+		jsonData, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			fmt.Printf("\nError marshalling summary to JSON: %v\n", err)
+			return
+		}
+		if err := ioutil.WriteFile(cfg.OutputFile, jsonData, 0644); err != nil {
+			fmt.Printf("\nError writing summary to file '%s': %v\n", cfg.OutputFile, err)
+			return
+		}
+		fmt.Printf("\nSummary report saved to %s\n", cfg.OutputFile)
+	}
+}
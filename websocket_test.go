@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// runWebSocketSummary runs the tool in -websocket mode against srv and returns the
+// summary it saved.
+func runWebSocketSummary(t *testing.T, srv *httptest.Server, args ...string) *WebSocketSummary {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "summary.json")
+	url := "ws://" + strings.TrimPrefix(srv.URL, "http://")
+	out, code := runTool(t, append([]string{"-websocket", "-url", url, "-output", path}, args...)...)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("no summary written (exit code %d): %v\n%s", code, err, out)
+	}
+	var summary WebSocketSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("decoding summary: %v", err)
+	}
+	return &summary
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		for {
+			var msg string
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			if err := websocket.Message.Send(conn, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	summary := runWebSocketSummary(t, srv, "-concurrency", "3", "-duration", "1s", "-ws-message", "ping", "-ws-interval", "100ms")
+	if summary.ConnectionsOpened != 3 || summary.ConnectFailures != 0 || summary.Disconnects != 0 {
+		t.Errorf("%d opened, %d connect failures, %d disconnects; want 3, 0 and 0", summary.ConnectionsOpened, summary.ConnectFailures, summary.Disconnects)
+	}
+	// Each connection sends every 100ms for a second.
+	if summary.MessagesReceived < 15 || summary.MessagesReceived > summary.MessagesSent {
+		t.Errorf("%d sent and %d replies, want about 30 of each", summary.MessagesSent, summary.MessagesReceived)
+	}
+	if summary.AvgRoundTrip < 0.02 || summary.AvgRoundTrip > 0.5 {
+		t.Errorf("average round trip %.3fs, want just over the server's 20ms", summary.AvgRoundTrip)
+	}
+	if summary.Percentile50RTT > summary.Percentile99RTT || summary.Percentile99RTT > summary.MaxRoundTrip {
+		t.Errorf("round trip p50 %v, p99 %v, max %v are out of order", summary.Percentile50RTT, summary.Percentile99RTT, summary.MaxRoundTrip)
+	}
+	if summary.AvgConnectTime <= 0 {
+		t.Error("connect time not measured")
+	}
+}
+
+func TestWebSocketDisconnects(t *testing.T) {
+	// The server hangs up after answering one message; the tool reconnects.
+	srv := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		var msg string
+		if websocket.Message.Receive(conn, &msg) == nil {
+			websocket.Message.Send(conn, msg)
+		}
+	}))
+	defer srv.Close()
+
+	summary := runWebSocketSummary(t, srv, "-concurrency", "2", "-duration", "1s", "-ws-message", "ping", "-ws-interval", "100ms")
+	if summary.Disconnects == 0 {
+		t.Error("no disconnects counted")
+	}
+	if summary.ConnectionsOpened <= 2 {
+		t.Errorf("%d connections opened, want reconnects after the disconnects", summary.ConnectionsOpened)
+	}
+}
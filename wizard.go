@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errWizardInput is returned when the input ends before the wizard has what it needs.
+var errWizardInput = errors.New("input ended before the wizard finished")
+
+// runWizard fills in cfg by prompting on out and reading answers from in, one per
+// line. Blank answers take the default shown in brackets; invalid ones are asked
+// again. Only the basic settings are asked for; everything else keeps its flag
+// default.
+func runWizard(in io.Reader, out io.Writer, cfg *Config) error {
+	scanner := bufio.NewScanner(in)
+	ask := func(prompt, def string) (string, error) {
+		if def != "" {
+			fmt.Fprintf(out, "%s [%s]: ", prompt, def)
+		} else {
+			fmt.Fprintf(out, "%s: ", prompt)
+		}
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", errWizardInput
+		}
+		if answer := strings.TrimSpace(scanner.Text()); answer != "" {
+			return answer, nil
+		}
+		return def, nil
+	}
+	askInt := func(prompt string, def int) (int, error) {
+		for {
+			answer, err := ask(prompt, strconv.Itoa(def))
+			if err != nil {
+				return 0, err
+			}
+			if n, err := strconv.Atoi(answer); err == nil && n > 0 {
+				return n, nil
+			}
+			fmt.Fprintln(out, "Please enter a whole number greater than 0.")
+		}
+	}
+
+	fmt.Fprintf(out, "%shttptest wizard%s: answer a few questions to configure a load test.\n\n", ColorYellow, ColorReset)
+	for cfg.URL == "" {
+		answer, err := ask("Target URL", "")
+		if err != nil {
+			return err
+		}
+		cfg.URL = answer
+	}
+	method, err := ask("HTTP method", cfg.Method)
+	if err != nil {
+		return err
+	}
+	cfg.Method = strings.ToUpper(method)
+	if cfg.Concurrency, err = askInt("Concurrency", cfg.Concurrency); err != nil {
+		return err
+	}
+	for {
+		answer, err := ask("Duration, e.g. 30s or 5m (blank to send a fixed number of requests)", "")
+		if err != nil {
+			return err
+		}
+		if answer == "" {
+			break
+		}
+		if d, err := time.ParseDuration(answer); err == nil && d > 0 {
+			cfg.Duration = d
+			break
+		}
+		fmt.Fprintln(out, "Please enter a duration such as 30s, 5m or 1h.")
+	}
+	if cfg.Duration == 0 {
+		if cfg.Requests, err = askInt("Number of requests", 100); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(out, "Headers as 'Key: Value', one per line (blank line to finish):")
+	for {
+		answer, err := ask("Header", "")
+		if err != nil {
+			return err
+		}
+		if answer == "" {
+			break
+		}
+		if parts := strings.SplitN(answer, ":", 2); len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			fmt.Fprintln(out, "Please use the form 'Key: Value'.")
+			continue
+		}
+		cfg.Headers = append(cfg.Headers, answer)
+	}
+
+	fmt.Fprintf(out, "\nEquivalent command: %s\n\n", wizardCommand(cfg))
+	return nil
+}
+
+// wizardCommand renders the flags that reproduce the wizard's answers, so the run
+// can be repeated without it.
+func wizardCommand(cfg *Config) string {
+	args := []string{"httptest", "-url", shellQuote(cfg.URL), "-method", shellQuote(cfg.Method), "-concurrency", strconv.Itoa(cfg.Concurrency)}
+	if cfg.Duration > 0 {
+		args = append(args, "-duration", cfg.Duration.String())
+	} else {
+		args = append(args, "-requests", strconv.Itoa(cfg.Requests))
+	}
+	for _, header := range cfg.Headers {
+		args = append(args, "-header", shellQuote(header))
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes s for a POSIX shell. Inside single quotes nothing is special,
+// so only single quotes themselves need escaping, by closing the quotes around
+// an escaped one. Go's double-quoted strings would leave $ and backticks live.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunWizard(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Config
+	}{
+		{
+			name:  "duration",
+			input: "https://example.com/api\npost\n25\n2m\nAuthorization: Bearer abc\nX-Trace: on\n\n",
+			want:  Config{URL: "https://example.com/api", Method: "POST", Concurrency: 25, Duration: 2 * time.Minute, Headers: customHeaders{"Authorization: Bearer abc", "X-Trace: on"}},
+		},
+		{
+			name:  "defaults and request count",
+			input: "\nexample.com\n\n\n\n\n\n",
+			want:  Config{URL: "example.com", Method: "GET", Concurrency: 10, Requests: 100},
+		},
+		{
+			name:  "invalid answers asked again",
+			input: "http://localhost:8080\nGET\nzero\n0\n4\nsoon\n\n-5\n250\nno colon\nX-Ok: 1\n\n",
+			want:  Config{URL: "http://localhost:8080", Method: "GET", Concurrency: 4, Requests: 250, Headers: customHeaders{"X-Ok: 1"}},
+		},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Method: "GET", Concurrency: 10}
+		var out strings.Builder
+		if err := runWizard(strings.NewReader(tt.input), &out, cfg); err != nil {
+			t.Errorf("%s: %v\n%s", tt.name, err, out.String())
+			continue
+		}
+		if cfg.URL != tt.want.URL || cfg.Method != tt.want.Method || cfg.Concurrency != tt.want.Concurrency ||
+			cfg.Duration != tt.want.Duration || cfg.Requests != tt.want.Requests ||
+			strings.Join(cfg.Headers, "|") != strings.Join(tt.want.Headers, "|") {
+			t.Errorf("%s: config = url %q method %q concurrency %d duration %v requests %d headers %q, want %+v",
+				tt.name, cfg.URL, cfg.Method, cfg.Concurrency, cfg.Duration, cfg.Requests, cfg.Headers, tt.want)
+		}
+		if !strings.Contains(out.String(), "Equivalent command: httptest -url ") {
+			t.Errorf("%s: no equivalent command printed:\n%s", tt.name, out.String())
+		}
+	}
+}
+
+func TestRunWizardInputEnds(t *testing.T) {
+	cfg := &Config{Method: "GET", Concurrency: 10}
+	if err := runWizard(strings.NewReader("https://example.com\nGET\n"), &strings.Builder{}, cfg); !errors.Is(err, errWizardInput) {
+		t.Errorf("err = %v, want errWizardInput", err)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"plain":                    `'plain'`,
+		"":                         `''`,
+		"https://x.test/?a=1&b=2":  `'https://x.test/?a=1&b=2'`,
+		"Authorization: Bearer $T": `'Authorization: Bearer $T'`,
+		"it's `here`":              `'it'\''s ` + "`here`" + `'`,
+	}
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+
+	// The shell must hand each quoted value back unchanged.
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	for in := range tests {
+		out, err := exec.Command(sh, "-c", "printf %s "+shellQuote(in)).Output()
+		if err != nil || string(out) != in {
+			t.Errorf("sh read %s back as %q (%v), want %q", shellQuote(in), out, err, in)
+		}
+	}
+}